@@ -5,6 +5,7 @@ import (
 	"flag"
 	"os"
 	"sync"
+	"time"
 )
 
 // Config contains configuration information.
@@ -17,6 +18,23 @@ type Config struct {
 	DebugMode   bool
 	EnableHTTPS bool
 	ConfigPath  string
+	// DeletedRetention is period after which deleted urls are purged, zero disables purging
+	DeletedRetention time.Duration
+	// DeleteQueuePath is file of durable queue of pending deletions, empty keeps them in memory only
+	DeleteQueuePath string
+	// OIDCIssuer is URL of OpenID Connect identity provider, empty disables login with it
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCRedirectURL is callback of login, by default it is derived from base url
+	OIDCRedirectURL string
+	// AuthKey is hex encoded key signing auth tokens
+	AuthKey string
+	// AuthKeyFile is JSON file of rotated signing keys, it overrides AuthKey
+	AuthKeyFile string
+	// AuthKeyGrace is period tokens signed by retired key are accepted,
+	// it must not be less than identity lifetime of tokens (a year)
+	AuthKeyGrace time.Duration
 }
 
 // config default values
@@ -33,6 +51,12 @@ const (
 	defaultDebugMode = false
 	// set https
 	defaultHTTPS = false
+	// retention period of deleted urls, purging is disabled by default
+	defaultDeletedRetention = 0
+	// durable queue of pending deletions
+	defaultDeleteQueuePath = "/tmp/short-url-delete-queue.json"
+	// retired signing key is accepted while identity of its tokens may be renewed
+	defaultAuthKeyGrace = 365 * 24 * time.Hour
 )
 
 // singleton
@@ -103,12 +127,86 @@ func WithEnableHTTPS(enable bool) Option {
 	}
 }
 
+// WithDeletedRetention sets retention period of deleted urls
+func WithDeletedRetention(retention time.Duration) Option {
+	return func(c *Config) {
+		if retention >= 0 {
+			c.DeletedRetention = retention
+		}
+	}
+}
+
+// WithDeleteQueuePath sets file of durable queue of pending deletions
+func WithDeleteQueuePath(path string) Option {
+	return func(c *Config) {
+		if path != "" {
+			c.DeleteQueuePath = path
+		}
+	}
+}
+
+// WithOIDC sets client registration at OpenID Connect identity provider
+func WithOIDC(issuer string, clientID string, clientSecret string, redirectURL string) Option {
+	return func(c *Config) {
+		if issuer != "" {
+			c.OIDCIssuer = issuer
+		}
+		if clientID != "" {
+			c.OIDCClientID = clientID
+		}
+		if clientSecret != "" {
+			c.OIDCClientSecret = clientSecret
+		}
+		if redirectURL != "" {
+			c.OIDCRedirectURL = redirectURL
+		}
+	}
+}
+
+// WithAuthKey sets hex encoded key signing auth tokens
+func WithAuthKey(key string) Option {
+	return func(c *Config) {
+		if key != "" {
+			c.AuthKey = key
+		}
+	}
+}
+
+// WithAuthKeyFile sets file of rotated signing keys
+func WithAuthKeyFile(path string) Option {
+	return func(c *Config) {
+		if path != "" {
+			c.AuthKeyFile = path
+		}
+	}
+}
+
+// WithAuthKeyGrace sets period tokens signed by retired key are accepted
+func WithAuthKeyGrace(grace time.Duration) Option {
+	return func(c *Config) {
+		if grace >= 0 {
+			c.AuthKeyGrace = grace
+		}
+	}
+}
+
 type configJSON struct {
 	ServerAddress   string `json:"server_address"`
 	BaseURL         string `json:"base_url"`
 	FileStoragePath string `json:"file_storage_path"`
 	DatabaseDSN     string `json:"database_dsn"`
 	EnableHTTPS     bool   `json:"enable_https"`
+	// DeletedRetention is duration string, e.g. "720h"
+	DeletedRetention string `json:"deleted_retention"`
+	DeleteQueuePath  string `json:"delete_queue_path"`
+	OIDCIssuer       string `json:"oidc_issuer"`
+	OIDCClientID     string `json:"oidc_client_id"`
+	OIDCClientSecret string `json:"oidc_client_secret"`
+	OIDCRedirectURL  string `json:"oidc_redirect_url"`
+	AuthKey          string `json:"auth_key"`
+	AuthKeyFile      string `json:"auth_key_file"`
+	// AuthKeyGrace is duration string, e.g. "8760h"
+	AuthKeyGrace string `json:"auth_key_grace"`
 }
 
 // FromFile loads config from file in JSON format
@@ -135,6 +233,20 @@ func FromFile(name string) Option {
 		WithStoragePath(cfg.FileStoragePath)(c)
 		WithDatabaseDSN(cfg.DatabaseDSN)(c)
 		WithEnableHTTPS(cfg.EnableHTTPS)(c)
+		if cfg.DeletedRetention != "" {
+			if d, err := time.ParseDuration(cfg.DeletedRetention); err == nil {
+				WithDeletedRetention(d)(c)
+			}
+		}
+		WithDeleteQueuePath(cfg.DeleteQueuePath)(c)
+		WithOIDC(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL)(c)
+		WithAuthKey(cfg.AuthKey)(c)
+		WithAuthKeyFile(cfg.AuthKeyFile)(c)
+		if cfg.AuthKeyGrace != "" {
+			if d, err := time.ParseDuration(cfg.AuthKeyGrace); err == nil {
+				WithAuthKeyGrace(d)(c)
+			}
+		}
 	}
 }
 
@@ -170,6 +282,27 @@ func FromEnv() Option {
 		if httpsEnv := os.Getenv("ENABLE_HTTPS"); httpsEnv == "true" {
 			WithEnableHTTPS(true)(c)
 		}
+		// sets retention period of deleted urls
+		if retentionEnv := os.Getenv("DELETED_RETENTION"); retentionEnv != "" {
+			if d, err := time.ParseDuration(retentionEnv); err == nil {
+				WithDeletedRetention(d)(c)
+			}
+		}
+		// sets durable queue of pending deletions
+		if queuePathEnv := os.Getenv("DELETE_QUEUE_PATH"); queuePathEnv != "" {
+			WithDeleteQueuePath(queuePathEnv)(c)
+		}
+		// sets OpenID Connect identity provider
+		WithOIDC(os.Getenv("OIDC_ISSUER"), os.Getenv("OIDC_CLIENT_ID"),
+			os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))(c)
+		// sets keys signing auth tokens
+		WithAuthKey(os.Getenv("AUTH_KEY"))(c)
+		WithAuthKeyFile(os.Getenv("AUTH_KEY_FILE"))(c)
+		if graceEnv := os.Getenv("AUTH_KEY_GRACE"); graceEnv != "" {
+			if d, err := time.ParseDuration(graceEnv); err == nil {
+				WithAuthKeyGrace(d)(c)
+			}
+		}
 	}
 }
 
@@ -183,6 +316,11 @@ func FromCommandLine(args *Config) Option {
 		WithDatabaseDSN(args.DataBaseDSN)(c)
 		WithDebugMode(args.DebugMode)(c)
 		WithEnableHTTPS(args.EnableHTTPS)(c)
+		WithDeletedRetention(args.DeletedRetention)(c)
+		WithDeleteQueuePath(args.DeleteQueuePath)(c)
+		WithOIDC(args.OIDCIssuer, args.OIDCClientID, "", args.OIDCRedirectURL)(c)
+		WithAuthKeyFile(args.AuthKeyFile)(c)
+		WithAuthKeyGrace(args.AuthKeyGrace)(c)
 	}
 }
 
@@ -195,6 +333,15 @@ func parseCommandLine(cfg *Config) {
 	flag.StringVar(&cfg.DataBaseDSN, "d", "", "database address")
 	flag.BoolVar(&cfg.DebugMode, "debug", false, "enable debug mode")
 	flag.BoolVar(&cfg.EnableHTTPS, "s", false, "enable https")
+	flag.DurationVar(&cfg.DeletedRetention, "retention", -1, "retention period of deleted urls, 0 disables purging")
+	flag.StringVar(&cfg.DeleteQueuePath, "q", "", "durable queue of pending deletions")
+	// client secret is not accepted from command line, so it is not visible in process list
+	flag.StringVar(&cfg.OIDCIssuer, "oidc-issuer", "", "OpenID Connect identity provider")
+	flag.StringVar(&cfg.OIDCClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.OIDCRedirectURL, "oidc-redirect-url", "", "OpenID Connect login callback")
+	// signing key is not accepted from command line too
+	flag.StringVar(&cfg.AuthKeyFile, "auth-key-file", "", "file of keys signing auth tokens")
+	flag.DurationVar(&cfg.AuthKeyGrace, "auth-key-grace", -1, "period tokens signed by retired key are accepted")
 	flag.StringVar(&cfg.ConfigPath, "config", "", "load config from file")
 	flag.StringVar(&cfg.ConfigPath, "c", "", "load config from file")
 
@@ -211,6 +358,10 @@ func New(opts ...Option) (*Config, error) {
 		StoragePath: defaultStoragePath,
 		DebugMode:   defaultDebugMode,
 		EnableHTTPS: defaultHTTPS,
+
+		DeletedRetention: defaultDeletedRetention,
+		DeleteQueuePath:  defaultDeleteQueuePath,
+		AuthKeyGrace:     defaultAuthKeyGrace,
 	}
 
 	for _, opt := range opts {
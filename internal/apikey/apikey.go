@@ -0,0 +1,214 @@
+// Package apikey implements user API keys authenticating programmatic clients.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+)
+
+// scopes of key
+const (
+	// ScopeRead allows safe requests (GET, HEAD, OPTIONS)
+	ScopeRead = "read"
+	// ScopeWrite allows requests changing links
+	ScopeWrite = "write"
+)
+
+// Scopes is list of all scopes
+var Scopes = []string{ScopeRead, ScopeWrite}
+
+// HeaderAPIKey is header carrying key, alternatively to Authorization: Bearer
+const HeaderAPIKey = "X-API-Key"
+
+// keyPrefix marks secrets issued by shortener, so they are recognizable in configs and logs
+const keyPrefix = "shr_"
+
+// displayLen is length of secret beginning shown in list of keys
+const displayLen = 12
+
+var (
+	// ErrInvalidKey is returned if key is unknown or revoked
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrExpired is returned if key is expired
+	ErrExpired = errors.New("API key is expired")
+)
+
+// Key is API key of user, the secret itself is not kept
+type Key = models.APIKey
+
+// hash returns hex encoded SHA-256 of secret,
+// secrets are random so slow password hash is not needed
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// newID returns random ID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newSecret returns random key secret
+func newSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return keyPrefix + hex.EncodeToString(b)
+}
+
+// Registry keeps API keys of users.
+// Keys are kept in storage and cached to verify requests without storage calls.
+type Registry struct {
+	store storage.APIKeyStorage
+	mu    sync.RWMutex
+	keys  map[string]Key
+	// byHash maps hash of secret to key ID
+	byHash map[string]string
+}
+
+// NewRegistry creates registry of keys loaded from store
+func NewRegistry(ctx context.Context, store storage.APIKeyStorage) (*Registry, error) {
+	stored, err := store.ListAPIKeysCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		store:  store,
+		keys:   make(map[string]Key),
+		byHash: make(map[string]string),
+	}
+	for _, key := range stored {
+		r.keys[key.ID] = key
+		r.byHash[key.Hash] = key.ID
+	}
+	return r, nil
+}
+
+// Create issues key of user and returns it with secret, the secret is not available later
+func (r *Registry) Create(ctx context.Context, key Key) (Key, string, error) {
+	secret := newSecret()
+
+	key.ID = newID()
+	key.Prefix = secret[:displayLen]
+	key.Hash = hash(secret)
+	key.CreatedAt = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.store.CreateAPIKeyCtx(ctx, key); err != nil {
+		return Key{}, "", err
+	}
+	r.keys[key.ID] = key
+	r.byHash[key.Hash] = key.ID
+	return key, secret, nil
+}
+
+// List returns keys of the user ordered by creation time
+func (r *Registry) List(userID string) []Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var keys []Key
+	for _, key := range r.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b Key) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys
+}
+
+// Revoke removes key of the user, it returns false if key is not found
+func (r *Registry) Revoke(ctx context.Context, userID string, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok || key.UserID != userID {
+		return false, nil
+	}
+	err := r.store.DeleteAPIKeyCtx(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrAPIKeyNotFound) {
+		return false, err
+	}
+	delete(r.keys, id)
+	delete(r.byHash, key.Hash)
+	return true, nil
+}
+
+// Verify returns key of secret
+func (r *Registry) Verify(secret string) (Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[r.byHash[hash(secret)]]
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+	if key.Expired(time.Now()) {
+		return Key{}, ErrExpired
+	}
+	return key, nil
+}
+
+// FromRequest returns key secret from Authorization: Bearer or X-API-Key header,
+// it is empty if request has no key
+func FromRequest(r *http.Request) string {
+	if secret := r.Header.Get(HeaderAPIKey); secret != "" {
+		return secret
+	}
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(secret)
+	}
+	return ""
+}
+
+type ctxKey struct{}
+
+// NewContext returns context of request authenticated by key
+func NewContext(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, ctxKey{}, key)
+}
+
+// FromContext returns key authenticating request
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(ctxKey{}).(Key)
+	return key, ok
+}
+
+// authToken resolves user of request authenticated by key
+type authToken struct {
+	client.AuthToken
+}
+
+// WrapAuthToken returns token resolving user ID of request from its API key,
+// requests without key are resolved by token
+func WrapAuthToken(token client.AuthToken) client.AuthToken {
+	return &authToken{AuthToken: token}
+}
+
+// GetUserID returns owner of API key or user ID from auth cookie
+func (a *authToken) GetUserID(r *http.Request) string {
+	if key, ok := FromContext(r.Context()); ok {
+		return key.UserID
+	}
+	return a.AuthToken.GetUserID(r)
+}
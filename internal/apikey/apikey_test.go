@@ -0,0 +1,141 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRegistry creates registry of keys kept in memory storage
+func newTestRegistry(t *testing.T) *Registry {
+	registry, err := NewRegistry(context.Background(), storage.NewMemStorage())
+	require.NoError(t, err)
+	return registry
+}
+
+// createKey issues key in registry
+func createKey(t *testing.T, registry *Registry, key Key) (Key, string) {
+	key, secret, err := registry.Create(context.Background(), key)
+	require.NoError(t, err)
+	return key, secret
+}
+
+func TestRegistry(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	key, secret := createKey(t, registry, Key{UserID: "user", Name: "ci", Scopes: []string{ScopeRead}})
+	require.True(t, strings.HasPrefix(secret, keyPrefix))
+	assert.Equal(t, secret[:displayLen], key.Prefix)
+	// secret is kept only hashed
+	assert.NotContains(t, key.Hash, secret)
+
+	got, err := registry.Verify(secret)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+	assert.Equal(t, "user", got.UserID)
+	assert.True(t, got.Allows(ScopeRead))
+	assert.False(t, got.Allows(ScopeWrite))
+
+	_, err = registry.Verify(secret + "0")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, expiredSecret := createKey(t, registry, Key{UserID: "user", ExpiresAt: time.Now().Add(-time.Minute)})
+	_, err = registry.Verify(expiredSecret)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	other, _ := createKey(t, registry, Key{UserID: "other"})
+	keys := registry.List("user")
+	require.Len(t, keys, 2)
+	assert.Equal(t, key.ID, keys[0].ID)
+	// key with empty scopes allows all
+	assert.True(t, keys[1].Allows(ScopeWrite))
+
+	ok, err := registry.Revoke(context.Background(), "user", other.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = registry.Revoke(context.Background(), "user", key.ID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, err = registry.Verify(secret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestRegistry_Load(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+
+	registry, err := NewRegistry(ctx, store)
+	require.NoError(t, err)
+	key, secret := createKey(t, registry, Key{UserID: "user", Name: "ci", Scopes: []string{ScopeRead}})
+	revoked, revokedSecret := createKey(t, registry, Key{UserID: "user"})
+	_, err = registry.Revoke(ctx, "user", revoked.ID)
+	require.NoError(t, err)
+
+	// only hash of secret is stored
+	stored, err := store.ListAPIKeysCtx(ctx)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.Equal(t, hash(secret), stored[0].Hash)
+	assert.NotContains(t, stored[0].Hash, secret)
+
+	// keys are kept after restart
+	registry, err = NewRegistry(ctx, store)
+	require.NoError(t, err)
+	got, err := registry.Verify(secret)
+	require.NoError(t, err)
+	assert.Equal(t, key, got)
+	_, err = registry.Verify(revokedSecret)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{name: "bearer", header: "Authorization", value: "Bearer shr_1", want: "shr_1"},
+		{name: "bearer_lower", header: "Authorization", value: "bearer shr_1", want: "shr_1"},
+		{name: "basic", header: "Authorization", value: "Basic dXNlcjpwYXNz"},
+		{name: "x_api_key", header: HeaderAPIKey, value: "shr_1", want: "shr_1"},
+		{name: "none"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+			assert.Equal(t, test.want, FromRequest(req))
+		})
+	}
+}
+
+func TestWrapAuthToken(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+	token := WrapAuthToken(auth)
+
+	cookieToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(cookieToken)
+	require.NoError(t, err)
+
+	// cookie user
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: cookieToken})
+	assert.Equal(t, userID, token.GetUserID(req))
+
+	// key owner is resolved to the same user
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(NewContext(req.Context(), Key{UserID: userID}))
+	assert.Equal(t, userID, token.GetUserID(req))
+}
@@ -1,76 +1,258 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
 )
 
-const authCookieName = "auth_shortener"
+// AuthCookieName is name of cookie with auth token
+const AuthCookieName = "auth_shortener"
+
+// token lifetimes
+const (
+	// sessionLifetime is lifetime of token, it is renewed after half of lifetime
+	sessionLifetime = 24 * time.Hour
+	// identityLifetime is period user keeps identity without visits, it is extended by renewal
+	identityLifetime = 365 * 24 * time.Hour
+)
+
+var (
+	// ErrRevoked is returned for tokens of revoked session or user
+	ErrRevoked = errors.New("token is revoked")
+	// ErrInvalidToken is returned if token to revoke is not verified
+	ErrInvalidToken = errors.New("invalid token")
+)
 
 // AuthToken is interface for client token authentication
 type AuthToken interface {
 	Create() (string, error)
+	CreateForUser(userID string) (string, error)
 	Verify(tokenString string) (string, error)
+	// Refresh checks token with expired session and returns renewed token
+	// if the session is past half of lifetime, renewed token keeps user ID
+	Refresh(tokenString string) (renewed string, userID string, err error)
+	// Revoke revokes session of token or all sessions of its user
+	Revoke(ctx context.Context, tokenString string, allSessions bool) error
 	GetUserID(r *http.Request) string
 }
 
+// claims are claims of auth token
+type claims struct {
+	UserID string `json:"user_id"`
+	// IdentityExpiresAt is time user ID is not renewed after, it is absent in legacy tokens
+	IdentityExpiresAt *jwt.NumericDate `json:"idexp,omitempty"`
+	// IssuedAtNano is issue time in nanoseconds, iat has seconds precision,
+	// so it does not tell tokens issued before and after revocation in the same second
+	IssuedAtNano int64 `json:"iatns,omitempty"`
+	// RegisteredClaims.ID is session of token, it is kept by renewal
+	jwt.RegisteredClaims
+}
+
 type authToken struct {
-	secretKey []byte
+	keys        *KeySet
+	revocations *revocations
+	// store keeps revocations, nil if they are kept in memory only
+	store storage.RevocationStorage
+	// now returns current time, it is replaced in tests
+	now func() time.Time
 }
 
-// NewAuthToken creates a new token
+// NewAuthToken creates a new token signed by single key
 func NewAuthToken(key []byte) AuthToken {
-	return &authToken{secretKey: key}
+	return NewAuthTokenWithKeys(&KeySet{keys: []SigningKey{NewSigningKey(key)}, now: time.Now})
+}
+
+// NewAuthTokenWithKeys creates a new token signed by keys of set
+func NewAuthTokenWithKeys(keys *KeySet) AuthToken {
+	return &authToken{keys: keys, revocations: newRevocations(), now: time.Now}
+}
+
+// NewAuthTokenWithStorage creates a new token signed by keys of set,
+// revocations are kept in store, so they survive restart
+func NewAuthTokenWithStorage(ctx context.Context, keys *KeySet, store storage.RevocationStorage) (AuthToken, error) {
+	a := &authToken{keys: keys, revocations: newRevocations(), store: store, now: time.Now}
+	revs, err := store.ListRevocationsCtx(ctx, a.now())
+	if err != nil {
+		return nil, err
+	}
+	a.revocations.load(revs)
+	return a, nil
 }
 
 // Create creates a new jwt token with client uuid
 func (a *authToken) Create() (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"user_id": uuid.New().String(),
-			"exp":     time.Now().Add(24 * time.Hour).Unix(),
-		})
+	return a.CreateForUser(uuid.New().String())
+}
 
-	return token.SignedString(a.secretKey)
+// CreateForUser creates a new jwt token of user, e.g. registered account
+func (a *authToken) CreateForUser(userID string) (string, error) {
+	return a.issue(userID, uuid.New().String())
 }
 
-// Verify checks token and return client uuid if the token is valid
-func (a *authToken) Verify(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+// issue signs token of user session
+func (a *authToken) issue(userID string, sid string) (string, error) {
+	now := a.now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		UserID:            userID,
+		IdentityExpiresAt: jwt.NewNumericDate(now.Add(identityLifetime)),
+		IssuedAtNano:      now.UnixNano(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sid,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(sessionLifetime)),
+		},
+	})
+
+	key := a.keys.Signing()
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Secret)
+}
+
+// parse checks signature of token and returns its claims
+func (a *authToken) parse(tokenString string, opts ...jwt.ParserOption) (*claims, error) {
+	c := &claims{}
+	opts = append(opts, jwt.WithTimeFunc(a.now))
+	token, err := jwt.ParseWithClaims(tokenString, c, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return a.secretKey, nil
-	})
+		// tokens issued before key rotation have no kid, they are checked by every accepted key
+		kid, _ := token.Header["kid"].(string)
+		secrets := a.keys.Accepted(kid)
+		if len(secrets) == 0 {
+			return nil, errors.New("unknown signing key")
+		}
+		set := jwt.VerificationKeySet{}
+		for _, secret := range secrets {
+			set.Keys = append(set.Keys, secret)
+		}
+		return set, nil
+	}, opts...)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", errors.New("token is not valid")
+		return nil, errors.New("token is not valid")
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", errors.New("can not get claims")
+	if c.UserID == "" {
+		return nil, errors.New("user_id is not exist")
 	}
 
-	uid, ok := claims["user_id"].(string)
-	if !ok {
-		return "", errors.New("user_id is not exist")
+	if a.revocations.revoked(c.UserID, c.ID, issuedAt(c), issuedAtPrecision(c)) {
+		return nil, ErrRevoked
 	}
 
-	return uid, nil
+	return c, nil
+}
+
+// issuedAt returns issue time of token, it is zero for legacy tokens
+func issuedAt(c *claims) time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	return c.IssuedAt.Time
+}
+
+// issuedAtPrecision returns precision of issue time of token
+func issuedAtPrecision(c *claims) time.Duration {
+	if c.IssuedAtNano != 0 {
+		return time.Nanosecond
+	}
+	return time.Second
+}
+
+// Verify checks token and return client uuid if the token is valid
+func (a *authToken) Verify(tokenString string) (string, error) {
+	c, err := a.parse(tokenString)
+	if err != nil {
+		return "", err
+	}
+	return c.UserID, nil
+}
+
+// Refresh checks token allowing expired session and renews it after half of lifetime.
+// Renewed is empty if token does not need renewal.
+func (a *authToken) Refresh(tokenString string) (string, string, error) {
+	c, err := a.parse(tokenString, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", "", err
+	}
+
+	now := a.now()
+	// identity of legacy token expires with session
+	idExp := c.IdentityExpiresAt
+	if idExp == nil {
+		idExp = c.ExpiresAt
+	}
+	if idExp != nil && now.After(idExp.Time) {
+		return "", "", errors.New("identity is expired")
+	}
+
+	if c.ExpiresAt != nil && now.Before(c.ExpiresAt.Time) &&
+		c.IssuedAt != nil && now.Sub(c.IssuedAt.Time) < sessionLifetime/2 {
+		return "", c.UserID, nil
+	}
+
+	sid := c.ID
+	if sid == "" {
+		sid = uuid.New().String()
+	}
+	renewed, err := a.issue(c.UserID, sid)
+	if err != nil {
+		return "", "", err
+	}
+	return renewed, c.UserID, nil
+}
+
+// Revoke revokes session of token or all sessions of its user, e.g. on logout.
+// Revocation is written to store before it takes effect.
+func (a *authToken) Revoke(ctx context.Context, tokenString string, allSessions bool) error {
+	c, err := a.parse(tokenString, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	// revocation time is rounded up to microseconds kept by db,
+	// so tokens issued before it are not accepted after restart
+	now := a.now().Truncate(time.Microsecond).Add(time.Microsecond)
+	rev := models.Revocation{UserID: c.UserID, Time: now, Until: now.Add(identityLifetime)}
+	if !allSessions && c.ID != "" {
+		rev.SessionID = c.ID
+		if c.IdentityExpiresAt != nil {
+			rev.Until = c.IdentityExpiresAt.Time
+		}
+	}
+	if a.store != nil {
+		if err := a.store.RevokeCtx(ctx, rev); err != nil {
+			return err
+		}
+	}
+
+	if rev.SessionID == "" {
+		a.revocations.revokeUser(c.UserID, now)
+		return nil
+	}
+	a.revocations.revokeSession(c.ID, rev.Until, now)
+	return nil
 }
 
 // GetUserID return user ID from auth cookie
 func (a *authToken) GetUserID(r *http.Request) string {
-	cookie, err := r.Cookie(authCookieName)
+	cookie, err := r.Cookie(AuthCookieName)
 	if err != nil {
 		return ""
 	}
@@ -82,3 +264,25 @@ func (a *authToken) GetUserID(r *http.Request) string {
 
 	return userID
 }
+
+// SetAuthCookie adds Set-Cookie header with token, cookie lives as long as user identity
+func SetAuthCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(identityLifetime),
+		HttpOnly: true,
+	})
+}
+
+// ClearAuthCookie adds Set-Cookie header removing auth cookie
+func ClearAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     AuthCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
@@ -1,7 +1,15 @@
 package client
 
 import (
+	"context"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_authToken_Verify(t *testing.T) {
@@ -54,9 +62,7 @@ func Test_authToken_Verify(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := &authToken{
-				secretKey: tt.fields.secretKey,
-			}
+			a := NewAuthToken(tt.fields.secretKey)
 			got, err := a.Verify(tt.args.tokenString)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
@@ -68,3 +74,209 @@ func Test_authToken_Verify(t *testing.T) {
 		})
 	}
 }
+
+func Test_authToken_Refresh(t *testing.T) {
+	start := time.Now()
+	a := NewAuthToken([]byte("secretkey")).(*authToken)
+	a.now = func() time.Time { return start }
+
+	token, err := a.CreateForUser("user")
+	require.NoError(t, err)
+
+	// legacy token without session and identity expiration
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "legacy",
+		"exp":     start.Add(time.Hour).Unix(),
+	})
+	legacyToken, err := legacy.SignedString([]byte("secretkey"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		token       string
+		now         time.Time
+		wantRenewed bool
+		wantUID     string
+		wantErr     bool
+	}{
+		{
+			name:    "fresh",
+			token:   token,
+			now:     start.Add(time.Hour),
+			wantUID: "user",
+		},
+		{
+			name:        "past_half_of_lifetime",
+			token:       token,
+			now:         start.Add(13 * time.Hour),
+			wantRenewed: true,
+			wantUID:     "user",
+		},
+		{
+			name:        "session_expired",
+			token:       token,
+			now:         start.Add(30 * 24 * time.Hour),
+			wantRenewed: true,
+			wantUID:     "user",
+		},
+		{
+			name:    "identity_expired",
+			token:   token,
+			now:     start.Add(identityLifetime + time.Hour),
+			wantErr: true,
+		},
+		{
+			name:        "legacy",
+			token:       legacyToken,
+			now:         start,
+			wantRenewed: true,
+			wantUID:     "legacy",
+		},
+		{
+			name:    "legacy_expired",
+			token:   legacyToken,
+			now:     start.Add(2 * time.Hour),
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			token:   "invalid",
+			now:     start,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.now = func() time.Time { return tt.now }
+
+			renewed, uid, err := a.Refresh(tt.token)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUID, uid)
+			if !tt.wantRenewed {
+				assert.Empty(t, renewed)
+				return
+			}
+
+			// renewed token keeps user ID and is valid for the whole session
+			require.NotEmpty(t, renewed)
+			a.now = func() time.Time { return tt.now.Add(sessionLifetime - time.Minute) }
+			got, err := a.Verify(renewed)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUID, got)
+		})
+	}
+}
+
+func Test_authToken_Revoke(t *testing.T) {
+	now := time.Now()
+	a := NewAuthToken([]byte("secretkey")).(*authToken)
+	a.now = func() time.Time { return now }
+
+	first, err := a.CreateForUser("user")
+	require.NoError(t, err)
+	second, err := a.CreateForUser("user")
+	require.NoError(t, err)
+
+	a.now = func() time.Time { return now.Add(13 * time.Hour) }
+	renewed, _, err := a.Refresh(first)
+	require.NoError(t, err)
+	require.NotEmpty(t, renewed)
+
+	// session is revoked with its renewed tokens
+	require.NoError(t, a.Revoke(context.Background(), first, false))
+	_, err = a.Verify(first)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = a.Verify(renewed)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, _, err = a.Refresh(first)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = a.Verify(second)
+	assert.NoError(t, err)
+
+	// all sessions of user are revoked, new login is accepted
+	require.NoError(t, a.Revoke(context.Background(), second, true))
+	_, err = a.Verify(second)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	a.now = func() time.Time { return now.Add(14 * time.Hour) }
+	third, err := a.CreateForUser("user")
+	require.NoError(t, err)
+	_, err = a.Verify(third)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, a.Revoke(context.Background(), "invalid", false), ErrInvalidToken)
+}
+
+func Test_authToken_RevokeRelogin(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, int(100*time.Millisecond), time.UTC)
+	a := NewAuthToken([]byte("secretkey")).(*authToken)
+	a.now = func() time.Time { return now }
+
+	before, err := a.CreateForUser("user")
+	require.NoError(t, err)
+	a.now = func() time.Time { return now.Add(time.Millisecond) }
+	require.NoError(t, a.Revoke(context.Background(), before, true))
+
+	// login in the same second after logout of all sessions
+	a.now = func() time.Time { return now.Add(2 * time.Millisecond) }
+	after, err := a.CreateForUser("user")
+	require.NoError(t, err)
+
+	_, err = a.Verify(before)
+	assert.ErrorIs(t, err, ErrRevoked)
+	uid, err := a.Verify(after)
+	require.NoError(t, err)
+	assert.Equal(t, "user", uid)
+
+	// legacy token of the same second has no precise issue time, so it is revoked
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user",
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour).Unix(),
+	})
+	legacyStr, err := legacy.SignedString([]byte("secretkey"))
+	require.NoError(t, err)
+	_, err = a.Verify(legacyStr)
+	assert.ErrorIs(t, err, ErrRevoked)
+}
+
+func Test_authToken_RevokeStorage(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	keys := &KeySet{keys: []SigningKey{NewSigningKey([]byte("secretkey"))}, now: time.Now}
+
+	token, err := NewAuthTokenWithStorage(ctx, keys, store)
+	require.NoError(t, err)
+	session, err := token.CreateForUser("user")
+	require.NoError(t, err)
+	other, err := token.CreateForUser("user")
+	require.NoError(t, err)
+	all, err := token.CreateForUser("other")
+	require.NoError(t, err)
+	require.NoError(t, token.Revoke(ctx, session, false))
+	require.NoError(t, token.Revoke(ctx, all, true))
+
+	// revocations are kept after restart
+	token, err = NewAuthTokenWithStorage(ctx, keys, store)
+	require.NoError(t, err)
+	_, err = token.Verify(session)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = token.Verify(all)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, err = token.Verify(other)
+	assert.NoError(t, err)
+}
+
+func TestSetAuthCookie(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetAuthCookie(w, "token")
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	// cookie outlives session, so expired session is renewed for the same user
+	assert.True(t, cookies[0].Expires.After(time.Now().Add(sessionLifetime)))
+}
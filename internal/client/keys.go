@@ -0,0 +1,175 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// minKeyLen is minimal length of signing secret in bytes
+const minKeyLen = 16
+
+// SigningKey is secret of token signature
+type SigningKey struct {
+	// ID is put to kid header of tokens signed by the key
+	ID     string
+	Secret []byte
+	// ActiveFrom is time the key starts signing tokens, previous key is retired then.
+	// Key with zero time is active from the beginning.
+	ActiveFrom time.Time
+}
+
+// keyID returns ID of key derived from its secret
+func keyID(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:4])
+}
+
+// NewSigningKey returns key with ID derived from secret, it is active from the beginning
+func NewSigningKey(secret []byte) SigningKey {
+	return SigningKey{ID: keyID(secret), Secret: secret}
+}
+
+// KeySet is set of signing keys rotated by schedule.
+// Key with the latest ActiveFrom in the past signs tokens. Tokens are verified by it,
+// keys scheduled in the future and keys retired less than grace period ago.
+type KeySet struct {
+	mu sync.RWMutex
+	// keys are sorted by ActiveFrom
+	keys  []SigningKey
+	grace time.Duration
+	// now returns current time, it is replaced in tests
+	now func() time.Time
+}
+
+// NewKeySet creates key set, grace is period retired key is accepted.
+// Token with expired session is renewed while identity of its user is not expired,
+// so grace must not be less than identity lifetime.
+func NewKeySet(keys []SigningKey, grace time.Duration) (*KeySet, error) {
+	if grace < identityLifetime {
+		return nil, fmt.Errorf("grace period %s of retired signing keys is less than identity lifetime %s", grace, identityLifetime)
+	}
+	ks := &KeySet{grace: grace, now: time.Now}
+	if err := ks.Replace(keys); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Replace replaces keys of set, e.g. after key file is changed
+func (ks *KeySet) Replace(keys []SigningKey) error {
+	if len(keys) == 0 {
+		return errors.New("no signing keys")
+	}
+	ids := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if k.ID == "" {
+			return errors.New("signing key has no ID")
+		}
+		if _, ok := ids[k.ID]; ok {
+			return fmt.Errorf("duplicate signing key %q", k.ID)
+		}
+		ids[k.ID] = struct{}{}
+		if len(k.Secret) < minKeyLen {
+			return fmt.Errorf("signing key %q is shorter than %d bytes", k.ID, minKeyLen)
+		}
+	}
+
+	sorted := slices.Clone(keys)
+	slices.SortStableFunc(sorted, func(a, b SigningKey) int {
+		return a.ActiveFrom.Compare(b.ActiveFrom)
+	})
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = sorted
+	return nil
+}
+
+// current returns index of signing key, the earliest key signs if all keys are scheduled
+func (ks *KeySet) current(now time.Time) int {
+	cur := 0
+	for i, k := range ks.keys {
+		if k.ActiveFrom.After(now) {
+			break
+		}
+		cur = i
+	}
+	return cur
+}
+
+// Signing returns key signing new tokens
+func (ks *KeySet) Signing() SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.keys[ks.current(ks.now())]
+}
+
+// Accepted returns keys tokens are verified by, key ID is empty for tokens issued without it
+func (ks *KeySet) Accepted(kid string) [][]byte {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := ks.now()
+	cur := ks.current(now)
+
+	var secrets [][]byte
+	for i, k := range ks.keys {
+		// key is retired when the next key becomes active
+		if i < cur && now.Sub(ks.keys[i+1].ActiveFrom) > ks.grace {
+			continue
+		}
+		if kid == "" || kid == k.ID {
+			secrets = append(secrets, k.Secret)
+		}
+	}
+	return secrets
+}
+
+// keyFile is format of key file
+type keyFile struct {
+	Keys []struct {
+		ID string `json:"id"`
+		// Secret is hex encoded
+		Secret     string    `json:"secret"`
+		ActiveFrom time.Time `json:"active_from"`
+	} `json:"keys"`
+}
+
+// LoadKeyFile reads signing keys from JSON file
+//
+//	{
+//	  "keys": [
+//	    { "id": "2024-05", "secret": "f53ac685bbceebd75043e6be2e06ee07" },
+//	    { "id": "2024-06", "secret": "9c2e41d0a7b3f65e8d1c0b9a8f7e6d5c", "active_from": "2024-06-01T00:00:00Z" }
+//	  ]
+//	}
+func LoadKeyFile(name string) ([]SigningKey, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse key file: %w", err)
+	}
+
+	keys := make([]SigningKey, 0, len(f.Keys))
+	for _, k := range f.Keys {
+		secret, err := hex.DecodeString(k.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("secret of key %q is not hex: %w", k.ID, err)
+		}
+		keys = append(keys, SigningKey{ID: k.ID, Secret: secret, ActiveFrom: k.ActiveFrom})
+	}
+	return keys, nil
+}
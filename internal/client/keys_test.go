@@ -0,0 +1,214 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeySet(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+
+	tests := []struct {
+		name    string
+		keys    []SigningKey
+		grace   time.Duration
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			keys: []SigningKey{{ID: "a", Secret: secret}, {ID: "b", Secret: secret}},
+		},
+		{
+			name:    "no_keys",
+			wantErr: true,
+		},
+		{
+			name:    "no_id",
+			keys:    []SigningKey{{Secret: secret}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate_id",
+			keys:    []SigningKey{{ID: "a", Secret: secret}, {ID: "a", Secret: secret}},
+			wantErr: true,
+		},
+		{
+			name:    "short_secret",
+			keys:    []SigningKey{{ID: "a", Secret: []byte("short")}},
+			wantErr: true,
+		},
+		{
+			name:    "short_grace",
+			keys:    []SigningKey{{ID: "a", Secret: secret}, {ID: "b", Secret: secret}},
+			grace:   24 * time.Hour,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grace := tt.grace
+			if grace == 0 {
+				grace = identityLifetime
+			}
+			_, err := NewKeySet(tt.keys, grace)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	oldKey := SigningKey{ID: "old", Secret: []byte("old-secret-0123456789")}
+	newKey := SigningKey{ID: "new", Secret: []byte("new-secret-0123456789"), ActiveFrom: start}
+
+	ks, err := NewKeySet([]SigningKey{newKey, oldKey}, identityLifetime)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantSigning string
+		wantOld     bool
+		wantNew     bool
+	}{
+		{
+			name:        "before_rotation",
+			now:         start.Add(-time.Minute),
+			wantSigning: "old",
+			wantOld:     true,
+			wantNew:     true,
+		},
+		{
+			name:        "grace_period",
+			now:         start.Add(identityLifetime - time.Minute),
+			wantSigning: "new",
+			wantOld:     true,
+			wantNew:     true,
+		},
+		{
+			name:        "old_key_retired",
+			now:         start.Add(identityLifetime + time.Hour),
+			wantSigning: "new",
+			wantOld:     false,
+			wantNew:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks.now = func() time.Time { return tt.now }
+
+			assert.Equal(t, tt.wantSigning, ks.Signing().ID)
+			assert.Equal(t, tt.wantOld, len(ks.Accepted("old")) == 1)
+			assert.Equal(t, tt.wantNew, len(ks.Accepted("new")) == 1)
+			assert.Empty(t, ks.Accepted("unknown"))
+		})
+	}
+}
+
+func TestAuthToken_KeyRotation(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+	oldKey := SigningKey{ID: "old", Secret: []byte("old-secret-0123456789")}
+	newKey := SigningKey{ID: "new", Secret: []byte("new-secret-0123456789"), ActiveFrom: start}
+
+	ks, err := NewKeySet([]SigningKey{oldKey, newKey}, identityLifetime)
+	require.NoError(t, err)
+	token := NewAuthTokenWithKeys(ks)
+
+	oldToken, err := token.CreateForUser("user")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(oldToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "old", parsed.Header["kid"])
+
+	// token without kid issued by legacy single key
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "legacy"})
+	legacyToken, err := legacy.SignedString(oldKey.Secret)
+	require.NoError(t, err)
+
+	// new key becomes active
+	ks.now = func() time.Time { return start.Add(time.Minute) }
+	newToken, err := token.CreateForUser("user")
+	require.NoError(t, err)
+	parsed, _, err = jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+
+	for _, s := range []string{oldToken, newToken} {
+		uid, err := token.Verify(s)
+		assert.NoError(t, err)
+		assert.Equal(t, "user", uid)
+	}
+	uid, err := token.Verify(legacyToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", uid)
+
+	// grace period is over
+	ks.now = func() time.Time { return start.Add(identityLifetime + time.Hour) }
+	_, err = token.Verify(oldToken)
+	assert.Error(t, err)
+	_, err = token.Verify(legacyToken)
+	assert.Error(t, err)
+	_, err = token.Verify(newToken)
+	assert.NoError(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		want    []SigningKey
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			content: `{"keys": [
+				{"id": "a", "secret": "f53ac685bbceebd75043e6be2e06ee07"},
+				{"id": "b", "secret": "9c2e41d0a7b3f65e8d1c0b9a8f7e6d5c", "active_from": "2024-06-01T00:00:00Z"}
+			]}`,
+			want: []SigningKey{
+				{ID: "a", Secret: []byte{0xf5, 0x3a, 0xc6, 0x85, 0xbb, 0xce, 0xeb, 0xd7, 0x50, 0x43, 0xe6, 0xbe, 0x2e, 0x06, 0xee, 0x07}},
+				{ID: "b", Secret: []byte{0x9c, 0x2e, 0x41, 0xd0, 0xa7, 0xb3, 0xf6, 0x5e, 0x8d, 0x1c, 0x0b, 0x9a, 0x8f, 0x7e, 0x6d, 0x5c},
+					ActiveFrom: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:    "invalid_json",
+			content: `{"keys": [`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid_hex",
+			content: `{"keys": [{"id": "a", "secret": "not hex"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name+".json")
+			require.NoError(t, os.WriteFile(name, []byte(tt.content), 0600))
+
+			got, err := LoadKeyFile(name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := LoadKeyFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/models"
+)
+
+// revocations is list of revoked sessions and users, tokens of them are not accepted
+type revocations struct {
+	mu sync.Mutex
+	// sessions maps revoked session to time its tokens expire anyway
+	sessions map[string]time.Time
+	// users maps user to time tokens issued before are revoked
+	users map[string]time.Time
+}
+
+func newRevocations() *revocations {
+	return &revocations{
+		sessions: make(map[string]time.Time),
+		users:    make(map[string]time.Time),
+	}
+}
+
+// load adds revocations read from storage
+func (rv *revocations) load(revs []models.Revocation) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	for _, rev := range revs {
+		if rev.SessionID != "" {
+			rv.sessions[rev.SessionID] = rev.Until
+			continue
+		}
+		if at, ok := rv.users[rev.UserID]; !ok || rev.Time.After(at) {
+			rv.users[rev.UserID] = rev.Time
+		}
+	}
+}
+
+// prune removes revocations of tokens expired anyway
+func (rv *revocations) prune(now time.Time) {
+	for sid, until := range rv.sessions {
+		if now.After(until) {
+			delete(rv.sessions, sid)
+		}
+	}
+	for uid, at := range rv.users {
+		if now.Sub(at) > identityLifetime {
+			delete(rv.users, uid)
+		}
+	}
+}
+
+// revokeSession revokes session, until is time its tokens expire anyway
+func (rv *revocations) revokeSession(sid string, until time.Time, now time.Time) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	rv.prune(now)
+	rv.sessions[sid] = until
+}
+
+// revokeUser revokes tokens of user issued at or before now
+func (rv *revocations) revokeUser(uid string, now time.Time) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	rv.prune(now)
+	rv.users[uid] = now
+}
+
+// revoked reports whether token of session is revoked,
+// precision is precision of issue time, token issued in the same unit as revocation of its user is revoked
+func (rv *revocations) revoked(uid string, sid string, issuedAt time.Time, precision time.Duration) bool {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+
+	if _, ok := rv.sessions[sid]; ok && sid != "" {
+		return true
+	}
+	at, ok := rv.users[uid]
+	return ok && !issuedAt.After(at.Truncate(precision))
+}
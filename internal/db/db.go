@@ -19,25 +19,120 @@ func OpenCtx(ctx context.Context, dsn string) (*DataBase, error) {
 		return nil, err
 	}
 
-	qu := `
-		CREATE TABLE IF NOT EXISTS urls(
+	// schema queries are applied in order on every start,
+	// so each of them must be idempotent
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS urls(
 			id SERIAL PRIMARY KEY,
 			userid TEXT NOT NULL,
 			alias TEXT NOT NULL,
-			url TEXT NOT NULL UNIQUE,
-			deleted BOOLEAN NOT NULL DEFAULT FALSE);
-		`
-
-	// create table if not exist
-	stmt, err := db.PrepareContext(ctx, qu)
-	if err != nil {
-		return nil, err
+			url TEXT NOT NULL,
+			deleted BOOLEAN NOT NULL DEFAULT FALSE);`,
+		`ALTER TABLE urls
+			ADD COLUMN IF NOT EXISTS utm_source TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_medium TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_campaign TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS utm_content TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules JSONB NOT NULL DEFAULT '[]';`,
+		`ALTER TABLE urls
+			ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]',
+			ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0;`,
+		`CREATE TABLE IF NOT EXISTS variant_clicks(
+			alias TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY(alias, variant));`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;`,
+		`CREATE TABLE IF NOT EXISTS url_history(
+			id BIGSERIAL PRIMARY KEY,
+			alias TEXT NOT NULL,
+			action TEXT NOT NULL,
+			userid TEXT NOT NULL,
+			url TEXT NOT NULL,
+			prev_url TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+		`CREATE INDEX IF NOT EXISTS url_history_alias_idx ON url_history(alias, id);`,
+		// deleted url does not prevent shortening the same url again
+		`ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_url_key;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS urls_url_active_idx ON urls(url) WHERE NOT deleted;`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+		// urls deleted before deleted_at column are retained from now
+		`UPDATE urls SET deleted_at=now() WHERE deleted AND deleted_at IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls(deleted_at) WHERE deleted;`,
+		`ALTER TABLE urls
+			ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			ADD COLUMN IF NOT EXISTS domain TEXT;`,
+		// domain of urls shortened before domain column
+		`UPDATE urls SET domain=lower(substring(url from '^[^:]+://(?:[^@/]*@)?([^/:?#]+)'))
+			WHERE domain IS NULL;`,
+		// indexes of user urls listing
+		`CREATE INDEX IF NOT EXISTS urls_user_created_idx ON urls(userid, created_at DESC, alias DESC);`,
+		`CREATE INDEX IF NOT EXISTS urls_user_clicks_idx ON urls(userid, clicks DESC, alias DESC);`,
+		`CREATE INDEX IF NOT EXISTS urls_user_domain_idx ON urls(userid, domain);`,
+		`ALTER TABLE urls
+			ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';`,
+		// words of title, note and destination for full-text search,
+		// punctuation is replaced to split url into words
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', regexp_replace(title, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+			setweight(to_tsvector('simple', regexp_replace(note, '[^[:alnum:]]+', ' ', 'g')), 'B') ||
+			setweight(to_tsvector('simple', regexp_replace(url, '[^[:alnum:]]+', ' ', 'g')), 'C')) STORED;`,
+		`CREATE INDEX IF NOT EXISTS urls_search_idx ON urls USING GIN(search);`,
+		`ALTER TABLE urls ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';`,
+		`CREATE INDEX IF NOT EXISTS urls_tags_idx ON urls USING GIN(tags);`,
+		// custom aliases are imported, so alias must be checked by db
+		`CREATE UNIQUE INDEX IF NOT EXISTS urls_alias_idx ON urls(alias);`,
+		// registered accounts, id is user id of account's urls
+		`CREATE TABLE IF NOT EXISTS users(
+			id TEXT PRIMARY KEY,
+			login TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+		// webhooks of users and events not delivered to them
+		`CREATE TABLE IF NOT EXISTS webhooks(
+			id TEXT PRIMARY KEY,
+			userid TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+		`CREATE TABLE IF NOT EXISTS webhook_dead_letters(
+			id BIGSERIAL PRIMARY KEY,
+			hook_id TEXT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+			event_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			alias TEXT NOT NULL,
+			url TEXT NOT NULL DEFAULT '',
+			event_time TIMESTAMPTZ NOT NULL,
+			attempts INT NOT NULL,
+			last_error TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now());`,
+		`CREATE INDEX IF NOT EXISTS webhook_dead_letters_hook_idx ON webhook_dead_letters(hook_id, id);`,
+		// API keys of users, only hashes of secrets are stored
+		`CREATE TABLE IF NOT EXISTS api_keys(
+			id TEXT PRIMARY KEY,
+			userid TEXT NOT NULL,
+			name TEXT NOT NULL DEFAULT '',
+			prefix TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			scopes JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			expires_at TIMESTAMPTZ);`,
+		// revoked sessions and users, id is session or user prefixed by kind of revocation
+		`CREATE TABLE IF NOT EXISTS revocations(
+			id TEXT PRIMARY KEY,
+			session_id TEXT NOT NULL DEFAULT '',
+			userid TEXT NOT NULL,
+			revoked_at TIMESTAMPTZ NOT NULL,
+			until TIMESTAMPTZ NOT NULL);`,
 	}
-	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx)
-	if err != nil {
-		return nil, err
+	// create tables if not exist
+	for _, qu := range schema {
+		if _, err := db.ExecContext(ctx, qu); err != nil {
+			return nil, err
+		}
 	}
 
 	return &DataBase{DB: db}, nil
@@ -0,0 +1,268 @@
+// Package events implements in-process publish/subscribe bus of link domain events.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// event types
+const (
+	TypeCreated = "created"
+	TypeUpdated = "updated"
+	TypeDeleted = "deleted"
+	TypeClicked = "clicked"
+)
+
+// Event is change of link
+type Event struct {
+	// Seq is number of event assigned by bus, it grows with every published event
+	Seq    uint64
+	Type   string
+	UserID string
+	Alias  string
+	// URL is destination of link, it is empty for deleted link
+	URL string
+	// Clicks is total number of link clicks for clicked event
+	Clicks int64
+	// First is set for the first click of link
+	First bool
+	Time  time.Time
+}
+
+// Policy is behaviour of publishing when buffer of subscriber is full
+type Policy int
+
+// back-pressure policies
+const (
+	// Block makes publisher wait until subscriber receives event
+	Block Policy = iota
+	// DropNewest drops published event
+	DropNewest
+	// DropOldest drops the oldest buffered event to keep the published one
+	DropOldest
+)
+
+// defaultBuffer is buffer size of subscriber if it is not set
+const defaultBuffer = 100
+
+// Options are parameters of subscription
+type Options struct {
+	// Buffer is number of events buffered for subscriber
+	Buffer int
+	Policy Policy
+	// Filter selects events delivered to subscriber, all events are delivered if nil
+	Filter func(Event) bool
+}
+
+// Subscription receives events published to bus
+type Subscription struct {
+	name    string
+	bus     *Bus
+	opts    Options
+	ch      chan Event
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// C returns channel of events, it is closed when subscription is cancelled or bus is closed
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns number of events dropped because buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe cancels subscription and closes its channel
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+}
+
+// send delivers event according to back-pressure policy
+func (s *Subscription) send(ev Event) {
+	if s.opts.Filter != nil && !s.opts.Filter(ev) {
+		return
+	}
+
+	switch s.opts.Policy {
+	case Block:
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		case <-s.bus.closing:
+		}
+	case DropNewest:
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped.Add(1)
+			logger.Log.Debug("event is dropped", zap.String("subscriber", s.name), zap.Uint64("seq", ev.Seq))
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- ev:
+				return
+			default:
+			}
+			select {
+			case old := <-s.ch:
+				s.dropped.Add(1)
+				logger.Log.Debug("event is dropped", zap.String("subscriber", s.name), zap.Uint64("seq", old.Seq))
+			default:
+			}
+		}
+	}
+}
+
+// BusOption sets parameter of bus
+type BusOption func(*Bus)
+
+// WithHistory keeps size last events, so subscriber may resume after the event it received
+func WithHistory(size int) BusOption {
+	return func(b *Bus) {
+		if size > 0 {
+			b.historySize = size
+		}
+	}
+}
+
+// Bus delivers published events to subscribers
+type Bus struct {
+	// mu is held while event is sent, so subscribers receive events in order of Seq
+	// and channels are not closed during sending
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	seq    uint64
+	// closing releases blocked publisher on Close
+	closing   chan struct{}
+	closeOnce sync.Once
+	// history is the last published events, the oldest goes first
+	history     []Event
+	historySize int
+}
+
+// NewBus creates bus without subscribers
+func NewBus(opts ...BusOption) *Bus {
+	b := &Bus{
+		subs:    make(map[*Subscription]struct{}),
+		closing: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscribe adds subscriber, name identifies it in logs
+func (b *Bus) Subscribe(name string, opts Options) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe(name, opts)
+}
+
+// SubscribeSince adds subscriber and returns events of history published after seq,
+// so no event is missed or received twice. Returned flag is false if events after seq
+// are not in history anymore or seq is unknown, e.g. it was issued before restart.
+func (b *Bus) SubscribeSince(name string, opts Options, seq uint64) (*Subscription, []Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []Event
+	for _, ev := range b.history {
+		if ev.Seq > seq && (opts.Filter == nil || opts.Filter(ev)) {
+			replay = append(replay, ev)
+		}
+	}
+	complete := seq <= b.seq && seq >= b.seq-uint64(len(b.history))
+	return b.subscribe(name, opts), replay, complete
+}
+
+// subscribe adds subscriber, mu must be held
+func (b *Bus) subscribe(name string, opts Options) *Subscription {
+	if opts.Buffer <= 0 {
+		opts.Buffer = defaultBuffer
+	}
+	sub := &Subscription{
+		name: name,
+		bus:  b,
+		opts: opts,
+		ch:   make(chan Event, opts.Buffer),
+		done: make(chan struct{}),
+	}
+
+	if b.closed {
+		sub.once.Do(func() {
+			close(sub.done)
+			close(sub.ch)
+		})
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish assigns sequence number and time to event and sends it to subscribers.
+// It waits for subscribers with Block policy having full buffer.
+func (b *Bus) Publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.seq++
+	ev.Seq = b.seq
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, ev)
+	}
+	for sub := range b.subs {
+		sub.send(ev)
+	}
+}
+
+// remove cancels subscription, blocked publisher is released before channel is closed
+func (b *Bus) remove(sub *Subscription) {
+	sub.once.Do(func() {
+		close(sub.done)
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.subs, sub)
+		close(sub.ch)
+	})
+}
+
+// Close stops publishing and closes channels of all subscribers,
+// events buffered before are still received
+func (b *Bus) Close() {
+	b.closeOnce.Do(func() { close(b.closing) })
+
+	b.mu.Lock()
+	b.closed = true
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
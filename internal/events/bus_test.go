@@ -0,0 +1,166 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// aliases returns aliases of events buffered by subscription
+func aliases(sub *Subscription) []string {
+	res := []string{}
+	for {
+		select {
+		case ev, ok := <-sub.C():
+			if !ok {
+				return res
+			}
+			res = append(res, ev.Alias)
+		default:
+			return res
+		}
+	}
+}
+
+func TestBus_Policies(t *testing.T) {
+	bus := NewBus()
+	newest := bus.Subscribe("newest", Options{Buffer: 2, Policy: DropNewest})
+	oldest := bus.Subscribe("oldest", Options{Buffer: 2, Policy: DropOldest})
+	filtered := bus.Subscribe("filtered", Options{Filter: func(ev Event) bool { return ev.UserID == "other" }})
+
+	for _, alias := range []string{"a", "b", "c"} {
+		bus.Publish(Event{Type: TypeCreated, UserID: "user", Alias: alias})
+	}
+
+	assert.Equal(t, []string{"a", "b"}, aliases(newest))
+	assert.Equal(t, uint64(1), newest.Dropped())
+	assert.Equal(t, []string{"b", "c"}, aliases(oldest))
+	assert.Equal(t, uint64(1), oldest.Dropped())
+	assert.Equal(t, []string{}, aliases(filtered))
+}
+
+func TestBus_Block(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe("block", Options{Buffer: 1, Policy: Block})
+
+	bus.Publish(Event{Alias: "a"})
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Alias: "b"})
+		close(published)
+	}()
+
+	// publisher waits for subscriber
+	select {
+	case <-published:
+		t.Fatal("publish is not blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+	ev := <-sub.C()
+	assert.Equal(t, uint64(1), ev.Seq)
+	<-published
+	ev = <-sub.C()
+	assert.Equal(t, uint64(2), ev.Seq)
+	assert.Zero(t, sub.Dropped())
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	bus := NewBus()
+	stuck := bus.Subscribe("stuck", Options{Buffer: 1, Policy: Block})
+	sub := bus.Subscribe("sub", Options{Buffer: 10})
+
+	bus.Publish(Event{Alias: "a"})
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Alias: "b"})
+		close(published)
+	}()
+
+	// cancelled subscriber releases blocked publisher
+	time.Sleep(10 * time.Millisecond)
+	stuck.Unsubscribe()
+	<-published
+
+	bus.Close()
+	bus.Publish(Event{Alias: "c"})
+	assert.Equal(t, []string{"a", "b"}, aliases(sub))
+	_, ok := <-sub.C()
+	assert.False(t, ok)
+
+	// subscription after close is cancelled
+	late := bus.Subscribe("late", Options{})
+	_, ok = <-late.C()
+	assert.False(t, ok)
+	late.Unsubscribe()
+}
+
+func TestBus_CloseBlocked(t *testing.T) {
+	bus := NewBus()
+	bus.Subscribe("stuck", Options{Buffer: 1, Policy: Block})
+	bus.Publish(Event{Alias: "a"})
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(Event{Alias: "b"})
+		close(published)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		bus.Close()
+		close(closed)
+	}()
+	require.Eventually(t, func() bool {
+		select {
+		case <-closed:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	<-published
+}
+
+func TestBus_SubscribeSince(t *testing.T) {
+	bus := NewBus(WithHistory(3))
+	for _, alias := range []string{"a", "b", "c", "d"} {
+		bus.Publish(Event{Alias: alias, UserID: "user"})
+	}
+	bus.Publish(Event{Alias: "e", UserID: "other"})
+
+	tests := []struct {
+		name         string
+		seq          uint64
+		wantAliases  []string
+		wantComplete bool
+	}{
+		{name: "in_history", seq: 3, wantAliases: []string{"d"}, wantComplete: true},
+		{name: "last", seq: 5, wantComplete: true},
+		{name: "evicted", seq: 1, wantAliases: []string{"c", "d"}},
+		{name: "unknown", seq: 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub, replay, complete := bus.SubscribeSince(test.name, Options{
+				Filter: func(ev Event) bool { return ev.UserID == "user" },
+			}, test.seq)
+			defer sub.Unsubscribe()
+
+			var got []string
+			for _, ev := range replay {
+				got = append(got, ev.Alias)
+			}
+			assert.Equal(t, test.wantAliases, got)
+			assert.Equal(t, test.wantComplete, complete)
+		})
+	}
+
+	// events published after subscription are received by channel
+	sub, _, _ := bus.SubscribeSince("next", Options{}, 5)
+	bus.Publish(Event{Alias: "f"})
+	bus.Close()
+	assert.Equal(t, []string{"f"}, aliases(sub))
+}
@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+)
+
+// Storage is URL storage publishing events of changed links to bus
+type Storage struct {
+	storage.URLStorage
+	bus *Bus
+}
+
+// NewStorage wraps st to publish events to bus
+func NewStorage(st storage.URLStorage, bus *Bus) *Storage {
+	return &Storage{URLStorage: st, bus: bus}
+}
+
+// publish publishes event about the link
+func (s *Storage) publish(eventType string, url models.ShrURL) {
+	s.bus.Publish(Event{Type: eventType, UserID: url.UserID, Alias: url.Alias, URL: url.URL})
+}
+
+// StoreURLCtx stores url and publishes created event
+func (s *Storage) StoreURLCtx(ctx context.Context, url models.ShrURL) error {
+	if err := s.URLStorage.StoreURLCtx(ctx, url); err != nil {
+		return err
+	}
+	s.publish(TypeCreated, url)
+	return nil
+}
+
+// StoreBatchURLCtx stores urls and publishes created event for each stored url
+func (s *Storage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error) {
+	res, err := s.URLStorage.StoreBatchURLCtx(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range res {
+		if r.Status == models.BatchCreated {
+			s.publish(TypeCreated, urls[i])
+		}
+	}
+	return res, nil
+}
+
+// UpdateURLCtx updates url and publishes updated event
+func (s *Storage) UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error) {
+	upd, err := s.URLStorage.UpdateURLCtx(ctx, url, version)
+	if err != nil {
+		return upd, err
+	}
+	s.publish(TypeUpdated, upd)
+	return upd, nil
+}
+
+// DeleteUserURLsCtx deletes urls and publishes deleted event for each deleted url
+func (s *Storage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	failed, err := s.URLStorage.DeleteUserURLsCtx(ctx, userID, aliases)
+	if err != nil {
+		return nil, err
+	}
+	for _, alias := range aliases {
+		if _, ok := failed[alias]; !ok {
+			s.publish(TypeDeleted, models.ShrURL{Alias: alias, UserID: userID})
+		}
+	}
+	return failed, nil
+}
+
+// RegisterClickCtx counts click and publishes clicked event to owner of link with total number of clicks
+func (s *Storage) RegisterClickCtx(ctx context.Context, alias string, variant string) (models.Click, error) {
+	click, err := s.URLStorage.RegisterClickCtx(ctx, alias, variant)
+	if err != nil {
+		return click, err
+	}
+	s.bus.Publish(Event{Type: TypeClicked, UserID: click.UserID, Alias: alias, URL: click.URL, Clicks: click.Clicks, First: click.First})
+	return click, nil
+}
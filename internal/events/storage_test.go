@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe("test", Options{Buffer: 100})
+	st := NewStorage(storage.NewMemStorage(), bus)
+
+	ctx := context.Background()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: "user"}))
+	res, err := st.StoreBatchURLCtx(ctx, []models.ShrURL{
+		{Alias: "RTfd56hn", URL: "https://ya.ru/", UserID: "user"},
+		{Alias: "EwHXdJfB", URL: "https://go.dev/", UserID: "user"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.BatchExists, res[1].Status)
+	_, err = st.UpdateURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/doc", UserID: "user"}, 1)
+	require.NoError(t, err)
+	for range 2 {
+		_, err = st.RegisterClickCtx(ctx, "RTfd56hn", "")
+		require.NoError(t, err)
+	}
+	_, err = st.DeleteUserURLsCtx(ctx, "user", []string{"6qxTVvsy", "unknown"})
+	require.NoError(t, err)
+	bus.Close()
+
+	type event struct {
+		seq    uint64
+		typ    string
+		alias  string
+		url    string
+		clicks int64
+		first  bool
+	}
+	var got []event
+	for ev := range sub.C() {
+		assert.Equal(t, "user", ev.UserID)
+		assert.False(t, ev.Time.IsZero())
+		got = append(got, event{seq: ev.Seq, typ: ev.Type, alias: ev.Alias, url: ev.URL, clicks: ev.Clicks, first: ev.First})
+	}
+	assert.Equal(t, []event{
+		{seq: 1, typ: TypeCreated, alias: "6qxTVvsy", url: "https://go.dev/"},
+		{seq: 2, typ: TypeCreated, alias: "RTfd56hn", url: "https://ya.ru/"},
+		{seq: 3, typ: TypeUpdated, alias: "6qxTVvsy", url: "https://go.dev/doc"},
+		{seq: 4, typ: TypeClicked, alias: "RTfd56hn", url: "https://ya.ru/", clicks: 1, first: true},
+		{seq: 5, typ: TypeClicked, alias: "RTfd56hn", url: "https://ya.ru/", clicks: 2},
+		{seq: 6, typ: TypeDeleted, alias: "6qxTVvsy"},
+	}, got)
+}
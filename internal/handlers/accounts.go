@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// account limits
+const (
+	maxLoginLen    = 64
+	minPasswordLen = 8
+	// bcrypt uses only the first 72 bytes of password
+	maxPasswordLen = 72
+)
+
+// passwordCost is bcrypt cost of password hashes
+var passwordCost = bcrypt.DefaultCost
+
+// dummyHash is compared with password of unknown login,
+// so response time does not reveal whether login exists
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), passwordCost)
+	return hash
+})
+
+// APIAccountReq represents request to register or login
+type APIAccountReq struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Merge transfers links of current anonymous user to the account
+	Merge bool `json:"merge,omitempty"`
+}
+
+// APIAccount represents logged in account
+type APIAccount struct {
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+	// Merged is number of links transferred from anonymous user
+	Merged int `json:"merged,omitempty"`
+}
+
+// decodeAccountReq decodes and validates request, it writes error if request is invalid
+func decodeAccountReq(w http.ResponseWriter, r *http.Request) (APIAccountReq, bool) {
+	var req APIAccountReq
+
+	if keyAuthenticated(w, r) {
+		return req, false
+	}
+
+	logger.Log.Debug("check Content-Type")
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+		if !strings.Contains(st, "application/json") {
+			msg := "Content-Type is not application/json"
+			logger.Log.Debug(msg, zap.String("is", ct))
+			http.Error(w, msg, http.StatusUnsupportedMediaType)
+			return req, false
+		}
+	}
+
+	logger.Log.Debug("decode request")
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return req, false
+	}
+	defer r.Body.Close()
+
+	// logins are case-insensitive
+	req.Login = strings.ToLower(strings.TrimSpace(req.Login))
+	if req.Login == "" || utf8.RuneCountInString(req.Login) > maxLoginLen {
+		http.Error(w, "invalid login", http.StatusBadRequest)
+		return req, false
+	}
+	if req.Password == "" || len(req.Password) > maxPasswordLen {
+		http.Error(w, "invalid password", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// loginAccount issues auth cookie of account and transfers links of anonymous user if requested.
+// Links of another registered account are not transferred.
+func loginAccount(w http.ResponseWriter, r *http.Request, users storage.UserStorage, store storage.URLStorage,
+	token client.AuthToken, user models.User, merge bool, status int) {
+	resp := APIAccount{UserID: user.ID, Login: user.Login}
+
+	// extract user ID from request cookie
+	if uid := token.GetUserID(r); merge && uid != "" && uid != user.ID {
+		_, err := users.GetUserCtx(r.Context(), uid)
+		switch {
+		case errors.Is(err, storage.ErrAccountNotFound):
+			resp.Merged, err = store.TransferUserURLsCtx(r.Context(), uid, user.ID)
+			if err != nil {
+				logger.Log.Error("cannot transfer urls", zap.Error(err))
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+		case err != nil:
+			logger.Log.Error("cannot get account", zap.Error(err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		default:
+			logger.Log.Debug("current user is registered, urls are not merged", zap.String("uid", uid))
+		}
+	}
+
+	tokenString, err := token.CreateForUser(user.ID)
+	if err != nil {
+		logger.Log.Error("can not create token", zap.Error(err))
+		http.Error(w, "can not create token", http.StatusInternalServerError)
+		return
+	}
+	client.SetAuthCookie(w, tokenString)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("cannot encode JSON body", zap.Error(err))
+		return
+	}
+}
+
+// RegisterHandler creates account and logs in (route POST /api/user/register).
+// Links created anonymously are transferred to the account if merge is set.
+//
+// Request
+//
+//	POST /api/user/register HTTP/1.1
+//	Content-Type: application/json
+//
+//	{ "login": "alice", "password": "correct horse", "merge": true }
+//
+// Response
+//
+//	HTTP/1.1 201 Created
+//	Content-Type: application/json
+//	Set-Cookie: auth_shortener=...
+//
+//	{ "user_id": "3f9a0c1b-8c5e-4d1a-9b7e-2f6d5c4b3a21", "login": "alice", "merged": 2 }
+func RegisterHandler(users storage.UserStorage, store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeAccountReq(w, r)
+		if !ok {
+			return
+		}
+		if len(req.Password) < minPasswordLen {
+			http.Error(w, "password is too short", http.StatusBadRequest)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), passwordCost)
+		if err != nil {
+			logger.Log.Error("cannot hash password", zap.Error(err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		user := models.User{
+			ID:           uuid.New().String(),
+			Login:        req.Login,
+			PasswordHash: string(hash),
+			CreatedAt:    time.Now(),
+		}
+		if err := users.CreateUserCtx(r.Context(), user); err != nil {
+			if errors.Is(err, storage.ErrLoginExists) {
+				http.Error(w, "login exists", http.StatusConflict)
+				return
+			}
+			logger.Log.Error("cannot create account", zap.Error(err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		loginAccount(w, r, users, store, token, user, req.Merge, http.StatusCreated)
+	}
+}
+
+// LoginHandler logs in account by login and password (route POST /api/user/login).
+// Links created anonymously are transferred to the account if merge is set.
+//
+// Request
+//
+//	POST /api/user/login HTTP/1.1
+//	Content-Type: application/json
+//
+//	{ "login": "alice", "password": "correct horse" }
+//
+// Response
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//	Set-Cookie: auth_shortener=...
+//
+//	{ "user_id": "3f9a0c1b-8c5e-4d1a-9b7e-2f6d5c4b3a21", "login": "alice" }
+func LoginHandler(users storage.UserStorage, store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeAccountReq(w, r)
+		if !ok {
+			return
+		}
+
+		user, err := users.GetUserByLoginCtx(r.Context(), req.Login)
+		if err != nil && !errors.Is(err, storage.ErrAccountNotFound) {
+			logger.Log.Error("cannot get account", zap.Error(err))
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		hash := []byte(user.PasswordHash)
+		if err != nil {
+			hash = dummyHash()
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || user.ID == "" {
+			http.Error(w, "invalid login or password", http.StatusUnauthorized)
+			return
+		}
+
+		loginAccount(w, r, users, store, token, user, req.Merge, http.StatusOK)
+	}
+}
+
+// revokeSession revokes session of request cookie and removes the cookie
+func revokeSession(w http.ResponseWriter, r *http.Request, token client.AuthToken, allSessions bool) {
+	if keyAuthenticated(w, r) {
+		return
+	}
+
+	cookie, err := r.Cookie(client.AuthCookieName)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := token.Revoke(r.Context(), cookie.Value, allSessions); err != nil {
+		if errors.Is(err, client.ErrInvalidToken) {
+			logger.Log.Debug("cannot revoke token", zap.Error(err))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logger.Log.Error("store token revocation", zap.Error(err))
+		http.Error(w, "can't revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	client.ClearAuthCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutHandler revokes session of current cookie (route POST /api/user/logout).
+// Anonymous user loses links created in the session.
+//
+// Request
+//
+//	POST /api/user/logout HTTP/1.1
+//
+// Response
+//
+//	HTTP/1.1 204 No Content
+//	Set-Cookie: auth_shortener=; Max-Age=0
+func LogoutHandler(token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revokeSession(w, r, token, false)
+	}
+}
+
+// RevokeSessionsHandler revokes all sessions of current user, e.g. if cookie is stolen
+// (route DELETE /api/user/sessions).
+//
+// Request
+//
+//	DELETE /api/user/sessions HTTP/1.1
+//
+// Response
+//
+//	HTTP/1.1 204 No Content
+//	Set-Cookie: auth_shortener=; Max-Age=0
+func RevokeSessionsHandler(token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		revokeSession(w, r, token, true)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountHandlers(t *testing.T) {
+	cost := passwordCost
+	passwordCost = bcrypt.MinCost
+	defer func() { passwordCost = cost }()
+
+	ctx := context.Background()
+	auth := client.NewAuthToken([]byte("secretkey"))
+	st := storage.NewMemStorage()
+
+	router := chi.NewRouter()
+	router.Post("/api/user/register", RegisterHandler(st, st, auth))
+	router.Post("/api/user/login", LoginHandler(st, st, auth))
+
+	// anonymous returns token and user ID of new anonymous user with link
+	anonymous := func(alias string) (string, string) {
+		token, err := auth.Create()
+		require.NoError(t, err)
+		uid, err := auth.Verify(token)
+		require.NoError(t, err)
+		require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: alias, URL: "https://go.dev/" + alias, UserID: uid}))
+		return token, uid
+	}
+
+	// do returns response and user ID of issued cookie
+	do := func(target string, body string, token string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: token})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "auth_shortener" {
+				uid, err := auth.Verify(c.Value)
+				require.NoError(t, err)
+				return w, uid
+			}
+		}
+		return w, ""
+	}
+
+	aliases := func(uid string) []string {
+		urls, _ := st.GetUserURLsCtx(ctx, uid)
+		var res []string
+		for _, url := range urls {
+			res = append(res, url.Alias)
+		}
+		return res
+	}
+
+	anonToken, anonID := anonymous("6qxTVvsy")
+
+	w, accountID := do("/api/user/register", `{"login":" Alice ","password":"correct horse","merge":true}`, anonToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var account APIAccount
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&account))
+	assert.Equal(t, "alice", account.Login)
+	assert.Equal(t, 1, account.Merged)
+	// cookie is issued for account
+	assert.Equal(t, account.UserID, accountID)
+	assert.Equal(t, []string{"6qxTVvsy"}, aliases(accountID))
+	assert.Empty(t, aliases(anonID))
+
+	bobToken := ""
+	t.Run("register", func(t *testing.T) {
+		w, _ := do("/api/user/register", `{"login":"alice","password":"another password"}`, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		w, _ = do("/api/user/register", `{"login":"bob","password":"short"}`, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = do("/api/user/register", `{"login":"","password":"correct horse"}`, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w, _ = do("/api/user/register", `{`, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w, bobID := do("/api/user/register", `{"login":"bob","password":"bob password"}`, "")
+		require.Equal(t, http.StatusCreated, w.Code)
+		var err error
+		bobToken, err = auth.CreateForUser(bobID)
+		require.NoError(t, err)
+		require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://bob.example.com/", UserID: bobID}))
+	})
+
+	t.Run("login", func(t *testing.T) {
+		w, uid := do("/api/user/login", `{"login":"alice","password":"wrong password"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, uid)
+		w, _ = do("/api/user/login", `{"login":"carol","password":"correct horse"}`, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// links are not merged without request
+		token, anonID := anonymous("EwHXdJfB")
+		w, uid = do("/api/user/login", `{"login":"ALICE","password":"correct horse"}`, token)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, accountID, uid)
+		assert.Equal(t, []string{"EwHXdJfB"}, aliases(anonID))
+
+		w, _ = do("/api/user/login", `{"login":"alice","password":"correct horse","merge":true}`, token)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"6qxTVvsy", "EwHXdJfB"}, aliases(accountID))
+	})
+
+	t.Run("merge_account", func(t *testing.T) {
+		// links of another account are not merged
+		w, uid := do("/api/user/login", `{"login":"alice","password":"correct horse","merge":true}`, bobToken)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, accountID, uid)
+		assert.Equal(t, []string{"6qxTVvsy", "EwHXdJfB"}, aliases(accountID))
+	})
+}
+
+func TestSessionHandlers(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	router := chi.NewRouter()
+	router.Post("/api/user/logout", LogoutHandler(auth))
+	router.Delete("/api/user/sessions", RevokeSessionsHandler(auth))
+
+	do := func(method string, target string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: token})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	create := func(uid string) string {
+		token, err := auth.CreateForUser(uid)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("logout", func(t *testing.T) {
+		token := create("user-1")
+		other := create("user-1")
+
+		w := do(http.MethodPost, "/api/user/logout", token)
+		require.Equal(t, http.StatusNoContent, w.Code)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "auth_shortener", cookies[0].Name)
+		assert.Negative(t, cookies[0].MaxAge)
+
+		_, err := auth.Verify(token)
+		assert.ErrorIs(t, err, client.ErrRevoked)
+		// another session of user is not revoked
+		_, err = auth.Verify(other)
+		assert.NoError(t, err)
+	})
+
+	t.Run("revoke_all_sessions", func(t *testing.T) {
+		token := create("user-2")
+		other := create("user-2")
+
+		w := do(http.MethodDelete, "/api/user/sessions", token)
+		require.Equal(t, http.StatusNoContent, w.Code)
+
+		for _, s := range []string{token, other} {
+			_, err := auth.Verify(s)
+			assert.ErrorIs(t, err, client.ErrRevoked)
+		}
+	})
+
+	t.Run("no_cookie", func(t *testing.T) {
+		w := do(http.MethodPost, "/api/user/logout", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("invalid_token", func(t *testing.T) {
+		w := do(http.MethodPost, "/api/user/logout", "invalid")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/apikey"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// APIKeyReq represents request to create API key
+type APIKeyReq struct {
+	Name string `json:"name"`
+	// Scopes is allowed scopes, empty means all scopes
+	Scopes []string `json:"scopes,omitempty"`
+	// ExpiresAt is expiration time, key does not expire if it is not set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKey represents API key of user
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Prefix is beginning of key identifying it
+	Prefix string   `json:"prefix"`
+	Scopes []string `json:"scopes,omitempty"`
+	// Key is returned only on creation
+	Key       string     `json:"key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// toAPIKey converts key to response, secret is not included
+func toAPIKey(key apikey.Key) APIKey {
+	resp := APIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+	if !key.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &key.ExpiresAt
+	}
+	return resp
+}
+
+// validateAPIKeyReq returns error message if API key request is invalid
+func validateAPIKeyReq(req APIKeyReq) string {
+	for _, s := range req.Scopes {
+		if !slices.Contains(apikey.Scopes, s) {
+			return "unknown scope " + s
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return "expiration time is in the past"
+	}
+	return ""
+}
+
+// keyAuthenticated writes error if request is authenticated by API key,
+// keys are managed only by cookie authenticated user
+func keyAuthenticated(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := apikey.FromContext(r.Context()); ok {
+		http.Error(w, "API keys cannot be managed with API key", http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+// CreateAPIKeyHandler creates API key of user (route POST /api/user/keys).
+// Key authenticates requests in Authorization: Bearer or X-API-Key header as the user,
+// it is shown only in this response.
+//
+// Request
+//
+//	POST /api/user/keys HTTP/1.1
+//	Content-Type: application/json
+//
+//	{ "name": "ci", "scopes": ["read"], "expires_at": "2025-01-01T00:00:00Z" }
+//
+// Response
+//
+//	HTTP/1.1 201 Created
+//	Content-Type: application/json
+//
+//	{ "id": "5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "name": "ci", "prefix": "shr_3f9a0c1b", "scopes": ["read"],
+//	  "key": "shr_3f9a0c1b...", "created_at": "2024-05-01T10:00:00Z", "expires_at": "2025-01-01T00:00:00Z" }
+func CreateAPIKeyHandler(registry *apikey.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keyAuthenticated(w, r) {
+			return
+		}
+
+		logger.Log.Debug("check Content-Type")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			if !strings.Contains(st, "application/json") {
+				msg := "Content-Type is not application/json"
+				logger.Log.Debug(msg, zap.String("is", ct))
+				http.Error(w, msg, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		var req APIKeyReq
+
+		logger.Log.Debug("decode request")
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if msg := validateAPIKeyReq(req); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		key := apikey.Key{
+			UserID: uid,
+			Name:   req.Name,
+			Scopes: req.Scopes,
+		}
+		if req.ExpiresAt != nil {
+			key.ExpiresAt = *req.ExpiresAt
+		}
+		key, secret, err := registry.Create(r.Context(), key)
+		if err != nil {
+			logger.Log.Error("store API key", zap.Error(err))
+			http.Error(w, "can't create API key", http.StatusInternalServerError)
+			return
+		}
+
+		resp := toAPIKey(key)
+		resp.Key = secret
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// GetAPIKeysHandler returns API keys of user (route GET /api/user/keys)
+func GetAPIKeysHandler(registry *apikey.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keyAuthenticated(w, r) {
+			return
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		resp := []APIKey{}
+		for _, key := range registry.List(uid) {
+			resp = append(resp, toAPIKey(key))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// RevokeAPIKeyHandler revokes API key of user (route DELETE /api/user/keys/{id})
+func RevokeAPIKeyHandler(registry *apikey.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keyAuthenticated(w, r) {
+			return
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		ok, err := registry.Revoke(r.Context(), uid, chi.URLParam(r, "id"))
+		if err != nil {
+			logger.Log.Error("remove API key from storage", zap.Error(err))
+			http.Error(w, "can't revoke API key", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
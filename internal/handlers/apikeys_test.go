@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/apikey"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/middleware"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyHandlers(t *testing.T) {
+	auth := apikey.WrapAuthToken(client.NewAuthToken([]byte("secretkey")))
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	registry, err := apikey.NewRegistry(context.Background(), storage.NewMemStorage())
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.APIKey(registry, next)
+	})
+	router.Post("/api/user/keys", CreateAPIKeyHandler(registry, auth))
+	router.Get("/api/user/keys", GetAPIKeysHandler(registry, auth))
+	router.Delete("/api/user/keys/{id}", RevokeAPIKeyHandler(registry, auth))
+	router.Get("/api/user/whoami", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(auth.GetUserID(r)))
+	})
+
+	do := func(method string, target string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if strings.HasPrefix(token, "shr_") {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: token})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("bad_requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/keys", `{"scopes":["admin"]}`, userToken).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/keys", `{"expires_at":"2000-01-01T00:00:00Z"}`, userToken).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/keys", `{`, userToken).Code)
+	})
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := do(http.MethodPost, "/api/user/keys",
+		`{"name":"ci","scopes":["read"],"expires_at":"`+expiresAt.Format(time.RFC3339)+`"}`, userToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created APIKey
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.NotEmpty(t, created.Key)
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, []string{apikey.ScopeRead}, created.Scopes)
+	require.NotNil(t, created.ExpiresAt)
+	assert.True(t, expiresAt.Equal(*created.ExpiresAt))
+
+	t.Run("authenticate", func(t *testing.T) {
+		// key resolves to user ID of cookie
+		w := do(http.MethodGet, "/api/user/whoami", "", created.Key)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, userID, w.Body.String())
+
+		// keys are not managed with key
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/keys", "", created.Key).Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := do(http.MethodGet, "/api/user/keys", "", userToken)
+		require.Equal(t, http.StatusOK, w.Code)
+		var keys []APIKey
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&keys))
+		require.Len(t, keys, 1)
+		assert.Equal(t, created.ID, keys[0].ID)
+		assert.Equal(t, created.Prefix, keys[0].Prefix)
+		// key is shown only on creation
+		assert.Empty(t, keys[0].Key)
+
+		w = do(http.MethodGet, "/api/user/keys", "", otherToken)
+		assert.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", otherToken).Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", userToken).Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/user/whoami", "", created.Key).Code)
+	})
+}
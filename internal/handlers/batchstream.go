@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ndjsonContentType is content type of newline delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// batchChunkSize is number of urls stored at once by streaming batch
+const batchChunkSize = 1000
+
+// BatchStreamError is the last line of streaming batch response if the batch is interrupted
+type BatchStreamError struct {
+	Error string `json:"error"`
+}
+
+// postBatchStream shortens urls of NDJSON request by chunks.
+// Responses of chunk are written and flushed as soon as the chunk is stored,
+// so neither request nor response is kept in memory entirely.
+//
+// Request
+//
+//	POST /api/shorten/batch HTTP/1.1
+//	Content-Type: application/x-ndjson
+//
+//	{"correlation_id":"1","original_url":"https://practicum.yandex.ru/"}
+//	{"correlation_id":"2","original_url":"https://go.dev/"}
+//
+// Response
+//
+//	HTTP/1.1 201 Created
+//	Content-Type: application/x-ndjson
+//
+//	{"correlation_id":"1","short_url":"http://localhost:8080/EwHXdJfB","status":"created"}
+//	{"correlation_id":"2","short_url":"http://localhost:8080/6qxTVvsy","status":"exists"}
+func postBatchStream(w http.ResponseWriter, r *http.Request, store storage.URLStorage, baseURL string) {
+	defer r.Body.Close()
+
+	rc := http.NewResponseController(w)
+	// HTTP/1.x server stops reading request after response is started unless full duplex is enabled
+	if err := rc.EnableFullDuplex(); err != nil {
+		logger.Log.Debug("full duplex is not supported", zap.Error(err))
+	}
+
+	dec := json.NewDecoder(r.Body)
+	enc := json.NewEncoder(w)
+	chunk := make([]BatchRequest, 0, batchChunkSize)
+	started := false
+
+	// fail reports error before response is started by status, after that by the last line
+	fail := func(status int, msg string) {
+		if !started {
+			http.Error(w, msg, status)
+			return
+		}
+		if err := enc.Encode(BatchStreamError{Error: msg}); err != nil {
+			logger.Log.Debug("cannot encode JSON body", zap.Error(err))
+		}
+	}
+
+	// flush stores chunk and writes its responses
+	flush := func() error {
+		batchResp, err := storeBatch(r.Context(), store, baseURL, chunk)
+		if err != nil {
+			return err
+		}
+
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", ndjsonContentType)
+			w.WriteHeader(http.StatusCreated)
+		}
+		for _, resp := range batchResp {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+
+		if err := rc.Flush(); err != nil {
+			logger.Log.Debug("cannot flush response", zap.Error(err))
+		}
+		return nil
+	}
+
+	for {
+		var breq BatchRequest
+		err := dec.Decode(&breq)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.Log.Debug("cannot decode JSON line", zap.Error(err))
+			// urls decoded before invalid line are stored
+			if len(chunk) > 0 {
+				if err := flush(); err != nil {
+					logger.Log.Error("can't save batch urls", zap.Error(err))
+				}
+			}
+			fail(http.StatusBadRequest, "bad request")
+			return
+		}
+		chunk = append(chunk, breq)
+		if len(chunk) < batchChunkSize {
+			continue
+		}
+		if err := flush(); err != nil {
+			logger.Log.Error("can't save batch urls", zap.Error(err))
+			fail(http.StatusInternalServerError, "can't save batch urls")
+			return
+		}
+	}
+
+	if len(chunk) == 0 && !started {
+		logger.Log.Debug("batch request is empty")
+		http.Error(w, "batch request is empty", http.StatusBadRequest)
+		return
+	}
+	if len(chunk) > 0 {
+		if err := flush(); err != nil {
+			logger.Log.Error("can't save batch urls", zap.Error(err))
+			fail(http.StatusInternalServerError, "can't save batch urls")
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostBatchHandler_Stream(t *testing.T) {
+	st := storage.NewMemStorage()
+	handler := PostBatchHandler(st, "http://localhost:8080/")
+
+	post := func(body string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result()
+	}
+
+	// readLines decodes response lines
+	readLines := func(res *http.Response) ([]BatchResponse, []BatchStreamError) {
+		var resps []BatchResponse
+		var errs []BatchStreamError
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), `"error"`) {
+				var e BatchStreamError
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+				errs = append(errs, e)
+				continue
+			}
+			var r BatchResponse
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+			resps = append(resps, r)
+		}
+		return resps, errs
+	}
+
+	t.Run("chunks", func(t *testing.T) {
+		// more than two chunks with duplicated url
+		n := 2*batchChunkSize + 10
+		var body strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&body, `{"correlation_id":"%d","original_url":"https://practicum.yandex.ru/%d"}`+"\n", i, i)
+		}
+		fmt.Fprintf(&body, `{"correlation_id":"dup","original_url":"https://practicum.yandex.ru/0"}`)
+
+		res := post(body.String())
+		defer res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+
+		resps, errs := readLines(res)
+		assert.Empty(t, errs)
+		require.Len(t, resps, n+1)
+		assert.Equal(t, "0", resps[0].CorrelationID)
+		assert.Equal(t, "dup", resps[n].CorrelationID)
+		// the same url gets the same short url
+		assert.Equal(t, resps[0].ShortURL, resps[n].ShortURL)
+		assert.Equal(t, "created", resps[0].Status)
+		assert.Equal(t, "exists", resps[n].Status)
+	})
+
+	t.Run("invalid_line", func(t *testing.T) {
+		res := post(`{"correlation_id":"1","original_url":"https://go.dev/"}` + "\n{bad\n")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+
+		resps, errs := readLines(res)
+		require.Len(t, resps, 1)
+		assert.Equal(t, "1", resps[0].CorrelationID)
+		assert.Equal(t, []BatchStreamError{{Error: "bad request"}}, errs)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		res := post("")
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
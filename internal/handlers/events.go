@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/events"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// sseHeartbeat is interval of comments keeping idle stream open
+var sseHeartbeat = 15 * time.Second
+
+// sseBuffer is number of events buffered for slow client, the oldest are dropped
+const sseBuffer = 100
+
+// sseEventReset tells client that events were missed and its state must be reloaded
+const sseEventReset = "reset"
+
+// APIEvent represents data of link event
+type APIEvent struct {
+	Alias       string `json:"alias"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url,omitempty"`
+	// Clicks is total number of clicks for clicked event
+	Clicks int64     `json:"clicks,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// userEventTypes is event types streamed to user
+var userEventTypes = map[string]bool{
+	events.TypeCreated: true,
+	events.TypeDeleted: true,
+	events.TypeClicked: true,
+}
+
+// writeSSE writes event in text/event-stream format
+func writeSSE(w http.ResponseWriter, id uint64, event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// GetUserEventsHandler streams creations, deletions and clicks of user's links
+// as Server-Sent Events (route GET /api/user/events).
+// Event ID is sequence number, client reconnecting with Last-Event-ID header
+// receives events it missed. If they are not available anymore, "reset" event is sent
+// and client should reload its links. Stream is closed when done is closed.
+//
+// Request
+//
+//	GET /api/user/events HTTP/1.1
+//	Accept: text/event-stream
+//	Last-Event-ID: 41
+//
+// Response
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: text/event-stream
+//
+//	id: 42
+//	event: clicked
+//	data: {"alias":"6qxTVvsy","short_url":"http://localhost:8080/6qxTVvsy","original_url":"https://go.dev/","clicks":3,"time":"2024-05-01T10:00:00Z"}
+func GetUserEventsHandler(bus *events.Bus, baseURL string, token client.AuthToken, done <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		opts := events.Options{
+			Buffer: sseBuffer,
+			Policy: events.DropOldest,
+			Filter: func(ev events.Event) bool {
+				return ev.UserID == uid && userEventTypes[ev.Type]
+			},
+		}
+
+		var sub *events.Subscription
+		var replay []events.Event
+		complete := true
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			seq, err := strconv.ParseUint(lastID, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			sub, replay, complete = bus.SubscribeSince("sse", opts, seq)
+		} else {
+			sub = bus.Subscribe("sse", opts)
+		}
+		defer sub.Unsubscribe()
+
+		rc := http.NewResponseController(w)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		// disable buffering of reverse proxy
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		send := func(ev events.Event) error {
+			return writeSSE(w, ev.Seq, ev.Type, APIEvent{
+				Alias:       ev.Alias,
+				ShortURL:    baseURL + "/" + ev.Alias,
+				OriginalURL: ev.URL,
+				Clicks:      ev.Clicks,
+				Time:        ev.Time,
+			})
+		}
+
+		if !complete {
+			if err := writeSSE(w, 0, sseEventReset, struct{}{}); err != nil {
+				return
+			}
+		}
+		for _, ev := range replay {
+			if err := send(ev); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			logger.Log.Debug("cannot flush events", zap.Error(err))
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+
+		var dropped uint64
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-done:
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case ev, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				// client was too slow and missed events
+				if n := sub.Dropped(); n != dropped {
+					dropped = n
+					if err := writeSSE(w, 0, sseEventReset, struct{}{}); err != nil {
+						return
+					}
+				}
+				if err := send(ev); err != nil {
+					logger.Log.Debug("cannot write event", zap.Error(err))
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
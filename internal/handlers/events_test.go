@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/events"
+	"github.com/rookgm/shortener/internal/middleware"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseMessage is event read from stream
+type sseMessage struct {
+	id    string
+	event string
+	data  string
+}
+
+// readSSE reads the next event or comment from stream
+func readSSE(t *testing.T, r *bufio.Reader) sseMessage {
+	t.Helper()
+
+	var msg sseMessage
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return msg
+		case strings.HasPrefix(line, ":"):
+			msg.event = "comment"
+			msg.data = strings.TrimSpace(strings.TrimPrefix(line, ":"))
+		case strings.HasPrefix(line, "id: "):
+			msg.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			msg.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			msg.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestGetUserEventsHandler(t *testing.T) {
+	heartbeat := sseHeartbeat
+	sseHeartbeat = 50 * time.Millisecond
+	defer func() { sseHeartbeat = heartbeat }()
+
+	auth := client.NewAuthToken([]byte("secretkey"))
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	bus := events.NewBus(events.WithHistory(10))
+	st := events.NewStorage(storage.NewMemStorage(), bus)
+	done := make(chan struct{})
+
+	router := chi.NewRouter()
+	router.Use(middleware.GzipMiddleware)
+	router.Get("/api/user/events", GetUserEventsHandler(bus, "http://localhost:8080", auth, done))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	connect := func(t *testing.T, lastID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/user/events", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	ctx := context.Background()
+	resp, r := connect(t, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	// links of other users are not streamed
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://example.com/", UserID: "other"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: userID}))
+	_, err = st.RegisterClickCtx(ctx, "6qxTVvsy", "")
+	require.NoError(t, err)
+
+	created := readSSE(t, r)
+	assert.Equal(t, "2", created.id)
+	assert.Equal(t, events.TypeCreated, created.event)
+	var ev APIEvent
+	require.NoError(t, json.Unmarshal([]byte(created.data), &ev))
+	assert.Equal(t, "6qxTVvsy", ev.Alias)
+	assert.Equal(t, "http://localhost:8080/6qxTVvsy", ev.ShortURL)
+	assert.Equal(t, "https://go.dev/", ev.OriginalURL)
+
+	clicked := readSSE(t, r)
+	assert.Equal(t, "3", clicked.id)
+	assert.Equal(t, events.TypeClicked, clicked.event)
+	require.NoError(t, json.Unmarshal([]byte(clicked.data), &ev))
+	assert.Equal(t, int64(1), ev.Clicks)
+
+	// idle stream receives heartbeats
+	assert.Equal(t, sseMessage{event: "comment", data: "heartbeat"}, readSSE(t, r))
+	resp.Body.Close()
+
+	t.Run("resume", func(t *testing.T) {
+		_, err := st.DeleteUserURLsCtx(ctx, userID, []string{"6qxTVvsy"})
+		require.NoError(t, err)
+
+		resp, r := connect(t, created.id)
+		defer resp.Body.Close()
+		assert.Equal(t, "3", readSSE(t, r).id)
+		deleted := readSSE(t, r)
+		assert.Equal(t, "4", deleted.id)
+		assert.Equal(t, events.TypeDeleted, deleted.event)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		// sequence number is unknown to bus, e.g. it was issued before restart
+		resp, r := connect(t, "100")
+		defer resp.Body.Close()
+		assert.Equal(t, sseEventReset, readSSE(t, r).event)
+	})
+
+	t.Run("invalid_last_event_id", func(t *testing.T) {
+		resp, _ := connect(t, "abc")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		resp, r := connect(t, "")
+		defer resp.Body.Close()
+		close(done)
+		// stream is finished
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				break
+			}
+		}
+	})
+}
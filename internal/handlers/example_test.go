@@ -86,7 +86,8 @@ func ExampleAPIShortenHandler() {
 		Alias: "EwHXdJfB",
 		URL:   "https://practicum.yandex.ru/test",
 	})
-	handler := APIShortenHandler(st, "http://localhost:8080")
+	auth := client.NewAuthToken([]byte("secretkey"))
+	handler := APIShortenHandler(st, "http://localhost:8080", auth)
 	orig := APIShortenReq{URL: "https://practicum.yandex.ru/test"}
 	body, _ := json.Marshal(orig)
 	request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// export formats
+const (
+	exportCSV    = "csv"
+	exportJSON   = "json"
+	exportNDJSON = "ndjson"
+)
+
+// ExportURL represents exported user's url
+type ExportURL struct {
+	Alias       string    `json:"alias"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	Created     time.Time `json:"created"`
+	Deleted     bool      `json:"deleted"`
+	Clicks      int64     `json:"clicks"`
+}
+
+// urlExporter writes exported urls in some format
+type urlExporter interface {
+	begin() error
+	write(u ExportURL) error
+	end() error
+}
+
+// csvExporter writes urls as CSV with header row
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) begin() error {
+	return e.w.Write([]string{"alias", "short_url", "original_url", "created", "deleted", "clicks"})
+}
+
+func (e *csvExporter) write(u ExportURL) error {
+	return e.w.Write([]string{u.Alias, u.ShortURL, u.OriginalURL, u.Created.Format(time.RFC3339),
+		strconv.FormatBool(u.Deleted), strconv.FormatInt(u.Clicks, 10)})
+}
+
+func (e *csvExporter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExporter writes urls as JSON array
+type jsonExporter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func (e *jsonExporter) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonExporter) write(u ExportURL) error {
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	return e.enc.Encode(u)
+}
+
+func (e *jsonExporter) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ndjsonExporter writes urls as JSON objects separated by newline
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExporter) begin() error { return nil }
+
+func (e *ndjsonExporter) write(u ExportURL) error { return e.enc.Encode(u) }
+
+func (e *ndjsonExporter) end() error { return nil }
+
+// newURLExporter returns exporter of the format with its content type
+func newURLExporter(format string, w io.Writer) (urlExporter, string, bool) {
+	switch format {
+	case exportCSV:
+		return &csvExporter{w: csv.NewWriter(w)}, "text/csv", true
+	case "", exportJSON:
+		return &jsonExporter{w: w, enc: json.NewEncoder(w)}, "application/json", true
+	case exportNDJSON:
+		return &ndjsonExporter{enc: json.NewEncoder(w)}, "application/x-ndjson", true
+	}
+	return nil, "", false
+}
+
+// ExportUserUrlsHandler streams all urls of the user including deleted ones
+// (route /api/user/urls/export?format=csv|json|ndjson), json is default format.
+// Urls are written while they are read from storage.
+func ExportUserUrlsHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		exp, contentType, ok := newURLExporter(format, w)
+		if !ok {
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+			return
+		}
+		if format == "" {
+			format = exportJSON
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		// response is started by the first url, so storage error before it is reported by status
+		started := false
+		start := func() error {
+			started = true
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+			w.WriteHeader(http.StatusOK)
+			return exp.begin()
+		}
+
+		err := store.WalkUserURLsCtx(r.Context(), uid, func(uurl models.ShrURL) error {
+			if !started {
+				if err := start(); err != nil {
+					return err
+				}
+			}
+			shortURL, err := url.JoinPath(baseURL, uurl.Alias)
+			if err != nil {
+				return err
+			}
+			return exp.write(ExportURL{
+				Alias:       uurl.Alias,
+				ShortURL:    shortURL,
+				OriginalURL: uurl.URL,
+				Created:     uurl.CreatedAt,
+				Deleted:     uurl.Deleted,
+				Clicks:      uurl.Clicks,
+			})
+		})
+		if err != nil {
+			logger.Log.Error("export user urls", zap.String("id", uid), zap.Error(err))
+			if !started {
+				http.Error(w, "can't export user urls", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if !started {
+			if err := start(); err != nil {
+				logger.Log.Error("export user urls", zap.String("id", uid), zap.Error(err))
+				return
+			}
+		}
+		if err := exp.end(); err != nil {
+			logger.Log.Error("export user urls", zap.String("id", uid), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportUserUrlsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "EwHXdJfB", URL: "https://practicum.yandex.ru/", UserID: userID}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: userID}))
+	_, err = st.RegisterClickCtx(ctx, "6qxTVvsy", "")
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(ctx, userID, []string{"EwHXdJfB"})
+	require.NoError(t, err)
+
+	want := []ExportURL{
+		{Alias: "EwHXdJfB", ShortURL: "http://localhost:8080/EwHXdJfB", OriginalURL: "https://practicum.yandex.ru/", Deleted: true},
+		{Alias: "6qxTVvsy", ShortURL: "http://localhost:8080/6qxTVvsy", OriginalURL: "https://go.dev/", Clicks: 1},
+	}
+
+	export := func(handler http.HandlerFunc, format string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format="+format, nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result()
+	}
+
+	// check compares exported urls without creation time
+	check := func(t *testing.T, got []ExportURL) {
+		require.Len(t, got, len(want))
+		for i := range got {
+			assert.False(t, got[i].Created.IsZero(), "creation time is zero")
+			got[i].Created = want[i].Created
+		}
+		assert.Equal(t, want, got)
+	}
+
+	handler := ExportUserUrlsHandler(st, "http://localhost:8080/", auth)
+
+	t.Run("json", func(t *testing.T) {
+		res := export(handler, "json")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+		var got []ExportURL
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		check(t, got)
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		res := export(handler, "ndjson")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got []ExportURL
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			var u ExportURL
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &u))
+			got = append(got, u)
+		}
+		check(t, got)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		res := export(handler, "csv")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, `attachment; filename="urls.csv"`, res.Header.Get("Content-Disposition"))
+
+		rows, err := csv.NewReader(res.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		assert.Equal(t, []string{"alias", "short_url", "original_url", "created", "deleted", "clicks"}, rows[0])
+		assert.Equal(t, []string{"6qxTVvsy", "http://localhost:8080/6qxTVvsy", "https://go.dev/"}, rows[2][:3])
+		assert.Equal(t, []string{"false", "1"}, rows[2][4:])
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		res := export(ExportUserUrlsHandler(storage.NewMemStorage(), "http://localhost:8080/", auth), "json")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got []ExportURL
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		assert.Empty(t, got)
+	})
+
+	t.Run("unsupported_format", func(t *testing.T) {
+		res := export(handler, "xml")
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("storage_error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		storeMock := storage.NewMockURLStorage(ctrl)
+		storeMock.EXPECT().WalkUserURLsCtx(gomock.Any(), userID, gomock.Any()).Return(errors.New("db is down"))
+
+		res := export(ExportUserUrlsHandler(storeMock, "http://localhost:8080/", auth), "csv")
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	})
+}
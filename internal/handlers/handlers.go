@@ -2,8 +2,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/rookgm/shortener/internal/client"
 	"io"
 	"net/http"
@@ -14,6 +16,7 @@ import (
 	"github.com/rookgm/shortener/internal/db"
 	"github.com/rookgm/shortener/internal/logger"
 	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/platform"
 	"github.com/rookgm/shortener/internal/random"
 	"github.com/rookgm/shortener/internal/storage"
 	"go.uber.org/zap"
@@ -84,6 +87,9 @@ func PostHandler(store storage.URLStorage, baseURL string, token client.AuthToke
 
 // GetHandler function accepts short URL from path /{id}, id is shortened url.
 // if success returns 307 code and original URL at Location header.
+// If the link has a rule for client platform, the rule target is used instead.
+// If the link has A/B variants, weighted variant is picked and kept in cookie.
+// UTM parameters of the link are appended to the target. Redirect is counted as click.
 //
 // Request
 // GET /EwHXdJfB HTTP/1.1
@@ -109,7 +115,19 @@ func GetHandler(store storage.URLStorage) http.HandlerFunc {
 			return
 		}
 
-		http.Redirect(w, r, rurl.URL, http.StatusTemporaryRedirect)
+		target, variant := redirectTarget(w, r, rurl)
+
+		if _, err := store.RegisterClickCtx(r.Context(), alias, variant); err != nil {
+			logger.Log.Error("cannot register click", zap.String("alias", alias), zap.Error(err))
+		}
+
+		if utmTarget, err := applyUTM(target, rurl.UTM); err != nil {
+			logger.Log.Debug("cannot apply utm", zap.String("alias", alias), zap.Error(err))
+		} else {
+			target = utmTarget
+		}
+
+		http.Redirect(w, r, target, http.StatusTemporaryRedirect)
 	}
 }
 
@@ -117,15 +135,167 @@ func GetHandler(store storage.URLStorage) http.HandlerFunc {
 type APIShortenReq struct {
 	// URL is original URL.
 	URL string `json:"url"`
+	// UTM is utm parameters appended to original URL on redirect.
+	UTM *APIUTM `json:"utm,omitempty"`
+	// Rules is platform specific redirect targets.
+	Rules []APIRule `json:"rules,omitempty"`
+	// Variants is weighted destinations of A/B split.
+	Variants []APIVariant `json:"variants,omitempty"`
+	// Title and Note describe the link, they are used in search.
+	Title string `json:"title,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+// hasOptions reports whether request sets options of the link besides URL
+func (req APIShortenReq) hasOptions() bool {
+	return !req.UTM.toModel().IsEmpty() || len(req.Rules) > 0 || len(req.Variants) > 0 ||
+		req.Title != "" || req.Note != ""
+}
+
+// APIVariant represents A/B split destination in JSON format.
+type APIVariant struct {
+	// Name identifies the variant in cookie and click analytics.
+	Name string `json:"name"`
+	// URL is destination of the variant.
+	URL string `json:"url"`
+	// Weight is relative share of visitors, must be positive.
+	Weight int `json:"weight"`
+}
+
+// variantsToModel validates variants and converts them to model
+func variantsToModel(variants []APIVariant) ([]models.Variant, error) {
+	var res []models.Variant
+	seen := make(map[string]struct{})
+	for _, v := range variants {
+		if v.Name == "" {
+			return nil, errors.New("empty variant name")
+		}
+		if _, ok := seen[v.Name]; ok {
+			return nil, fmt.Errorf("duplicate variant %q", v.Name)
+		}
+		seen[v.Name] = struct{}{}
+		if v.URL == "" {
+			return nil, fmt.Errorf("empty url for variant %q", v.Name)
+		}
+		if v.Weight <= 0 {
+			return nil, fmt.Errorf("weight of variant %q must be positive", v.Name)
+		}
+		res = append(res, models.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	return res, nil
+}
+
+// variantsFromModel converts variants from model
+func variantsFromModel(variants []models.Variant) []APIVariant {
+	var res []APIVariant
+	for _, v := range variants {
+		res = append(res, APIVariant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	return res
+}
+
+// maximum length of link description in bytes, it keeps records of file storage in limits of line
+const (
+	maxTitleLen = 256
+	maxNoteLen  = 4096
+)
+
+// validateDescription returns error message if title or note of link is too long, it is empty if they are valid
+func validateDescription(title string, note string) string {
+	if len(title) > maxTitleLen {
+		return fmt.Sprintf("title is longer than %d bytes", maxTitleLen)
+	}
+	if len(note) > maxNoteLen {
+		return fmt.Sprintf("note is longer than %d bytes", maxNoteLen)
+	}
+	return ""
+}
+
+// APIRule represents platform redirect rule in JSON format.
+type APIRule struct {
+	// Platform is one of ios, android, windows, macos, linux.
+	Platform string `json:"platform"`
+	// URL is redirect target for the platform.
+	URL string `json:"url"`
+}
+
+// rulesToModel validates rules and converts them to model
+func rulesToModel(rules []APIRule) ([]models.RedirectRule, error) {
+	var res []models.RedirectRule
+	seen := make(map[string]struct{})
+	for _, r := range rules {
+		if !platform.IsKnown(r.Platform) {
+			return nil, fmt.Errorf("unknown platform %q", r.Platform)
+		}
+		if _, ok := seen[r.Platform]; ok {
+			return nil, fmt.Errorf("duplicate rule for platform %q", r.Platform)
+		}
+		seen[r.Platform] = struct{}{}
+		if r.URL == "" {
+			return nil, fmt.Errorf("empty url for platform %q", r.Platform)
+		}
+		res = append(res, models.RedirectRule{Platform: r.Platform, URL: r.URL})
+	}
+	return res, nil
+}
+
+// rulesFromModel converts rules from model
+func rulesFromModel(rules []models.RedirectRule) []APIRule {
+	var res []APIRule
+	for _, r := range rules {
+		res = append(res, APIRule{Platform: r.Platform, URL: r.URL})
+	}
+	return res
+}
+
+// APIUTM represents utm parameters of the link in JSON format.
+type APIUTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// toModel converts utm parameters to model
+func (u *APIUTM) toModel() models.UTM {
+	if u == nil {
+		return models.UTM{}
+	}
+	return models.UTM{
+		Source:   u.Source,
+		Medium:   u.Medium,
+		Campaign: u.Campaign,
+		Content:  u.Content,
+	}
+}
+
+// utmFromModel converts utm parameters from model, returns nil if parameters are empty
+func utmFromModel(u models.UTM) *APIUTM {
+	if u.IsEmpty() {
+		return nil
+	}
+	return &APIUTM{
+		Source:   u.Source,
+		Medium:   u.Medium,
+		Campaign: u.Campaign,
+		Content:  u.Content,
+	}
 }
 
 // APIShortenResp represents response in JSON format.
 type APIShortenResp struct {
 	// Result is shortened URL.
 	Result string `json:"result"`
+	// Error explains why options of request are not applied to already shortened URL.
+	Error string `json:"error,omitempty"`
 }
 
 // APIShortenHandler accepts JSON {"url":"<some_url>"} and returns {"result":"<short_url>"}
+// Optional "utm" object sets utm parameters appended to the URL on redirect.
+// Optional "rules" array sets redirect targets for client platforms.
+// Optional "variants" array sets weighted destinations of A/B split.
+// If the URL is already shortened, 409 Conflict returns the existing link unchanged,
+// options of such request are rejected with "error" telling to change them by PATCH.
 //
 // Request
 //
@@ -133,7 +303,11 @@ type APIShortenResp struct {
 //	Host: localhost:8080
 //	Content-Type: application/json
 //
-//	{ "url": "https://practicum.yandex.ru" }
+//	{ "url": "https://practicum.yandex.ru", "utm": { "source": "flyer", "campaign": "spring" } }
+//
+//	{ "url": "https://example.com/app", "rules": [{ "platform": "ios", "url": "https://apps.apple.com/app/id1" }] }
+//
+//	{ "url": "https://example.com/", "variants": [{ "name": "a", "url": "https://example.com/a", "weight": 1 }] }
 //
 // Response
 //
@@ -142,7 +316,12 @@ type APIShortenResp struct {
 //	Content-Length: 30
 //
 //	{ "result": "http://localhost:8080/EwHXdJfB" }
-func APIShortenHandler(store storage.URLStorage, baseURL string) http.HandlerFunc {
+//
+//	HTTP/1.1 409 Conflict
+//	Content-Type: application/json
+//
+//	{ "result": "http://localhost:8080/EwHXdJfB", "error": "url is already shortened, options are not applied, use PATCH /api/user/urls/EwHXdJfB to change them" }
+func APIShortenHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Debug("check Content-Type")
 		if ct := r.Header.Get("Content-Type"); ct != "" {
@@ -165,9 +344,34 @@ func APIShortenHandler(store storage.URLStorage, baseURL string) http.HandlerFun
 		}
 		defer r.Body.Close()
 
+		if msg := validateDescription(req.Title, req.Note); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		rules, err := rulesToModel(req.Rules)
+		if err != nil {
+			logger.Log.Debug("invalid redirect rules", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		variants, err := variantsToModel(req.Variants)
+		if err != nil {
+			logger.Log.Debug("invalid variants", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		iurl := models.ShrURL{
-			Alias: random.RandString(6),
-			URL:   req.URL,
+			Alias:    random.RandString(6),
+			URL:      req.URL,
+			UserID:   token.GetUserID(r),
+			UTM:      req.UTM.toModel(),
+			Rules:    rules,
+			Variants: variants,
+			Title:    req.Title,
+			Note:     req.Note,
 		}
 
 		statusCode := http.StatusCreated
@@ -192,13 +396,15 @@ func APIShortenHandler(store storage.URLStorage, baseURL string) http.HandlerFun
 		w.WriteHeader(statusCode)
 
 		var resp APIShortenResp
-		var err error
 
 		resp.Result, err = url.JoinPath(baseURL, iurl.Alias)
 		if err != nil {
 			logger.Log.Debug("join url path", zap.Error(err))
 			w.WriteHeader(http.StatusInternalServerError)
 		}
+		if statusCode == http.StatusConflict && req.hasOptions() {
+			resp.Error = "url is already shortened, options are not applied, use PATCH /api/user/urls/" + iurl.Alias + " to change them"
+		}
 
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			logger.Log.Debug("cannot encode JSON body", zap.Error(err))
@@ -231,15 +437,69 @@ type BatchRequest struct {
 type BatchResponse struct {
 	// CorrelationID is string id from request.
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	// ShortURL is set if URL is created or already exists.
+	ShortURL string `json:"short_url,omitempty"`
+	// Status is "created", "exists" or "rejected".
+	Status string `json:"status"`
+	// Error explains why URL is rejected.
+	Error string `json:"error,omitempty"`
 }
 
-// PostBatchHandler performs batch processing of original URLs and returns shortened URLs
+// storeBatch stores URLs of batch and returns response for every request in the same order
+func storeBatch(ctx context.Context, store storage.URLStorage, baseURL string, batchReq []BatchRequest) ([]BatchResponse, error) {
+	batchResp := make([]BatchResponse, len(batchReq))
+
+	var batchURL []models.ShrURL
+	// indexes of requests of stored urls
+	var stored []int
+	for i, breq := range batchReq {
+		batchResp[i].CorrelationID = breq.CorrelationID
+		if breq.OriginalURL == "" {
+			batchResp[i].Status = models.BatchRejected
+			batchResp[i].Error = "empty url"
+			continue
+		}
+		batchURL = append(batchURL, models.ShrURL{
+			Alias: random.RandString(6),
+			URL:   breq.OriginalURL,
+		})
+		stored = append(stored, i)
+	}
+	if len(batchURL) == 0 {
+		return batchResp, nil
+	}
+
+	results, err := store.StoreBatchURLCtx(ctx, batchURL)
+	if err != nil {
+		return nil, err
+	}
+
+	for j, res := range results {
+		resp := &batchResp[stored[j]]
+		resp.Status = res.Status
+		resp.Error = res.Reason
+		if res.Alias == "" {
+			continue
+		}
+		if resp.ShortURL, err = url.JoinPath(baseURL, res.Alias); err != nil {
+			return nil, err
+		}
+	}
+	return batchResp, nil
+}
+
+// PostBatchHandler performs batch processing of original URLs and returns shortened URLs.
+// Batch in application/x-ndjson is processed by chunks and responses are streamed.
 func PostBatchHandler(store storage.URLStorage, baseURL string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Debug("check Content-Type")
 		if ct := r.Header.Get("Content-Type"); ct != "" {
 			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			// very large batches are streamed as newline delimited JSON
+			if st == ndjsonContentType {
+				postBatchStream(w, r, store, baseURL)
+				return
+			}
 			if !strings.Contains(st, "application/json") {
 				msg := "Content-Type is not application/json"
 				logger.Log.Debug(msg, zap.String("is", ct))
@@ -249,7 +509,6 @@ func PostBatchHandler(store storage.URLStorage, baseURL string) http.HandlerFunc
 		}
 
 		var batchReq []BatchRequest
-		var batchResp []BatchResponse
 
 		logger.Log.Debug("decode batch request")
 		if err := json.NewDecoder(r.Body).Decode(&batchReq); err != nil {
@@ -265,41 +524,14 @@ func PostBatchHandler(store storage.URLStorage, baseURL string) http.HandlerFunc
 			return
 		}
 
-		var batchURL []models.ShrURL
-		// prepare batch urls
-		for _, breq := range batchReq {
-			iurl := models.ShrURL{
-				Alias: random.RandString(6),
-				URL:   breq.OriginalURL,
-			}
-			batchURL = append(batchURL, iurl)
-		}
-
-		if err := store.StoreBatchURLCtx(r.Context(), batchURL); err != nil {
-			logger.Log.Debug("can't save batch urls")
+		// every request gets response with its outcome
+		batchResp, err := storeBatch(r.Context(), store, baseURL, batchReq)
+		if err != nil {
+			logger.Log.Debug("can't save batch urls", zap.Error(err))
 			http.Error(w, "can't save batch urls", http.StatusBadRequest)
 			return
 		}
 
-		// forming batch result
-		for _, breq := range batchReq {
-			rurl, err := store.GetAliasCtx(r.Context(), breq.OriginalURL)
-			if err != nil {
-				continue
-			}
-
-			surl, err := url.JoinPath(baseURL, rurl.Alias)
-			if err != nil {
-				logger.Log.Debug("join url path", zap.Error(err))
-				continue
-			}
-
-			batchResp = append(batchResp, BatchResponse{
-				CorrelationID: breq.CorrelationID,
-				ShortURL:      surl,
-			})
-		}
-
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 
@@ -23,6 +23,7 @@ func TestGetHandler(t *testing.T) {
 
 	fileName := "storage_test.json"
 	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".clicks")
 
 	st := storage.NewFileStorage(fileName)
 
@@ -34,6 +35,31 @@ func TestGetHandler(t *testing.T) {
 	err := st.StoreURLCtx(context.Background(), url)
 	require.NoError(t, err)
 
+	utmURL := models.ShrURL{
+		Alias: "Kd8sPq2m",
+		URL:   "https://practicum.yandex.ru/courses?utm_source=old&lang=ru",
+		UTM: models.UTM{
+			Source:   "flyer",
+			Campaign: "spring",
+		},
+	}
+
+	err = st.StoreURLCtx(context.Background(), utmURL)
+	require.NoError(t, err)
+
+	appURL := models.ShrURL{
+		Alias: "Tq3rLm9x",
+		URL:   "https://example.com/app",
+		Rules: []models.RedirectRule{
+			{Platform: "ios", URL: "https://apps.apple.com/app/id1"},
+			{Platform: "android", URL: "https://play.google.com/store/apps/details?id=com.example"},
+		},
+		UTM: models.UTM{Source: "qr"},
+	}
+
+	err = st.StoreURLCtx(context.Background(), appURL)
+	require.NoError(t, err)
+
 	type want struct {
 		code        int
 		response    string
@@ -41,9 +67,10 @@ func TestGetHandler(t *testing.T) {
 	}
 
 	tests := []struct {
-		name   string
-		target string
-		want   want
+		name      string
+		target    string
+		userAgent string
+		want      want
 	}{
 		{
 			name:   "positive_test",
@@ -53,6 +80,41 @@ func TestGetHandler(t *testing.T) {
 				response: "https://practicum.yandex.ru/",
 			},
 		},
+		{
+			name:   "utm_parameters",
+			target: "/" + utmURL.Alias,
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://practicum.yandex.ru/courses?lang=ru&utm_campaign=spring&utm_source=flyer",
+			},
+		},
+		{
+			name:      "ios_rule",
+			target:    "/" + appURL.Alias,
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)",
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://apps.apple.com/app/id1?utm_source=qr",
+			},
+		},
+		{
+			name:      "android_rule",
+			target:    "/" + appURL.Alias,
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8)",
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://play.google.com/store/apps/details?id=com.example&utm_source=qr",
+			},
+		},
+		{
+			name:      "default_target",
+			target:    "/" + appURL.Alias,
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://example.com/app?utm_source=qr",
+			},
+		},
 		{
 			name:   "outside_alias",
 			target: "/",
@@ -69,6 +131,7 @@ func TestGetHandler(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			request.Header.Set("User-Agent", test.userAgent)
 			w := httptest.NewRecorder()
 
 			router.ServeHTTP(w, request)
@@ -82,6 +145,64 @@ func TestGetHandler(t *testing.T) {
 	}
 }
 
+func TestGetHandler_Variants(t *testing.T) {
+	st := storage.NewMemStorage()
+
+	url := models.ShrURL{
+		Alias: "Vb7nQw1z",
+		URL:   "https://example.com/",
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+			{Name: "b", URL: "https://example.com/b", Weight: 0},
+		},
+	}
+
+	err := st.StoreURLCtx(context.Background(), url)
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/{id}", GetHandler(st))
+
+	// new visitor gets the only weighted variant and the cookie keeping it
+	request := httptest.NewRequest(http.MethodGet, "/"+url.Alias, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "https://example.com/a", res.Header.Get("Location"))
+
+	var cookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == variantCookiePrefix+url.Alias {
+			cookie = c
+		}
+	}
+	require.NotNil(t, cookie, "variant cookie is not set")
+	assert.Equal(t, "a", cookie.Value)
+
+	// returning visitor keeps the variant from cookie
+	request = httptest.NewRequest(http.MethodGet, "/"+url.Alias, nil)
+	request.AddCookie(&http.Cookie{Name: variantCookiePrefix + url.Alias, Value: "b"})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	res = w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+	assert.Equal(t, "https://example.com/b", res.Header.Get("Location"))
+
+	// served variants are counted
+	got, err := st.GetURLCtx(context.Background(), url.Alias)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.Clicks)
+	assert.Equal(t, int64(1), got.Variants[0].Clicks)
+	assert.Equal(t, int64(1), got.Variants[1].Clicks)
+}
+
 func TestPostHandler(t *testing.T) {
 
 	st := storage.NewMemStorage()
@@ -186,6 +307,26 @@ func TestApiShortenHandler(t *testing.T) {
 				body:        APIShortenResp{Result: "http://localhost:8080/"},
 			},
 		},
+		{
+			name:        "conflict",
+			contentType: "application/json",
+			body:        APIShortenReq{URL: "https://practicum.yandex.ru/"},
+			want: want{
+				code:        http.StatusConflict,
+				contentType: "application/json",
+				body:        APIShortenResp{Result: "http://localhost:8080/"},
+			},
+		},
+		{
+			name:        "conflict_with_options",
+			contentType: "application/json",
+			body:        APIShortenReq{URL: "https://practicum.yandex.ru/", Title: "Practicum"},
+			want: want{
+				code:        http.StatusConflict,
+				contentType: "application/json",
+				body:        APIShortenResp{Result: "http://localhost:8080/", Error: "use PATCH /api/user/urls/"},
+			},
+		},
 		{
 			name:        "unsupported_media_type",
 			contentType: "text/plain",
@@ -194,9 +335,34 @@ func TestApiShortenHandler(t *testing.T) {
 				contentType: "text/plain",
 			},
 		},
+		{
+			name:        "unknown_platform_rule",
+			contentType: "application/json",
+			body: APIShortenReq{
+				URL:   "https://example.com/app",
+				Rules: []APIRule{{Platform: "symbian", URL: "https://example.com/nokia"}},
+			},
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "text/plain",
+			},
+		},
+		{
+			name:        "note_too_long",
+			contentType: "application/json",
+			body: APIShortenReq{
+				URL:  "https://example.com/long",
+				Note: strings.Repeat("n", maxNoteLen+1),
+			},
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "text/plain",
+			},
+		},
 	}
 
-	handler := APIShortenHandler(st, "http://localhost:8080")
+	auth := client.NewAuthToken([]byte("secretkey"))
+	handler := APIShortenHandler(st, "http://localhost:8080", auth)
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -225,10 +391,50 @@ func TestApiShortenHandler(t *testing.T) {
 			json.Unmarshal(resBody, &resp)
 
 			assert.True(t, strings.HasPrefix(resp.Result, test.want.body.Result), "body result is not equal")
+			if test.want.body.Error == "" {
+				assert.Empty(t, resp.Error)
+			} else {
+				assert.Contains(t, resp.Error, test.want.body.Error)
+			}
 		})
 	}
 }
 
+func TestPostBatchHandler(t *testing.T) {
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(context.Background(), models.ShrURL{Alias: "EwHXdJfB", URL: "https://practicum.yandex.ru/"}))
+
+	handler := PostBatchHandler(st, "http://localhost:8080/")
+
+	body := `[
+		{"correlation_id": "1", "original_url": "https://go.dev/"},
+		{"correlation_id": "2", "original_url": "https://practicum.yandex.ru/"},
+		{"correlation_id": "3", "original_url": ""}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var got []BatchResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+	// every request is reported
+	require.Len(t, got, 3)
+
+	assert.Equal(t, "1", got[0].CorrelationID)
+	assert.Equal(t, models.BatchCreated, got[0].Status)
+	assert.True(t, strings.HasPrefix(got[0].ShortURL, "http://localhost:8080/"), got[0].ShortURL)
+	assert.Equal(t, []BatchResponse{
+		{CorrelationID: "2", ShortURL: "http://localhost:8080/EwHXdJfB", Status: models.BatchExists},
+		{CorrelationID: "3", Status: models.BatchRejected, Error: "empty url"},
+	}, got[1:])
+}
+
 func BenchmarkPostHandler(b *testing.B) {
 	memst := storage.NewMemStorage()
 
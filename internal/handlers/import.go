@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/random"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// import limits
+const (
+	maxImportSize = 32 << 20
+	maxImportRows = 100000
+)
+
+// aliasRetries is number of attempts to generate unused alias
+const aliasRetries = 3
+
+// aliasPattern is pattern of custom alias
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// reservedAliases are first segments of service routes
+var reservedAliases = map[string]bool{"api": true, "ping": true, "debug": true}
+
+// importRow is url to import from line of CSV file
+type importRow struct {
+	line  int
+	url   string
+	alias string
+}
+
+// parseImportCSV reads rows of url and optional alias.
+// If the first row contains "url" column, it is header defining columns order.
+func parseImportCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	urlCol, aliasCol := 0, 1
+	var rows []importRow
+
+	for first := true; ; first = false {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first {
+			headerURL, headerAlias := -1, -1
+			for i, f := range rec {
+				switch strings.ToLower(strings.TrimSpace(f)) {
+				case "url":
+					headerURL = i
+				case "alias":
+					headerAlias = i
+				}
+			}
+			if headerURL >= 0 {
+				urlCol, aliasCol = headerURL, headerAlias
+				continue
+			}
+		}
+
+		row := importRow{line: line}
+		if urlCol < len(rec) {
+			row.url = strings.TrimSpace(rec[urlCol])
+		}
+		if aliasCol >= 0 && aliasCol < len(rec) {
+			row.alias = strings.TrimSpace(rec[aliasCol])
+		}
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return nil, fmt.Errorf("more than %d rows", maxImportRows)
+		}
+	}
+	return rows, nil
+}
+
+// validateImportRow returns error message if url or alias of the row is invalid
+func validateImportRow(row importRow) string {
+	u, err := url.ParseRequestURI(row.url)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "invalid url"
+	}
+	if row.alias != "" && (!aliasPattern.MatchString(row.alias) || reservedAliases[strings.ToLower(row.alias)]) {
+		return "invalid alias"
+	}
+	return ""
+}
+
+// importURL stores url of the row, alias is generated if it is not set
+func importURL(ctx context.Context, store storage.URLStorage, uid string, row importRow) string {
+	if msg := validateImportRow(row); msg != "" {
+		return msg
+	}
+
+	shrURL := models.ShrURL{Alias: row.alias, URL: row.url, UserID: uid}
+
+	var err error
+	for i := 0; i < aliasRetries; i++ {
+		if row.alias == "" {
+			shrURL.Alias = random.RandString(6)
+		}
+		err = store.StoreURLCtx(ctx, shrURL)
+		if !errors.Is(err, storage.ErrAliasExists) || row.alias != "" {
+			break
+		}
+	}
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, storage.ErrAliasExists):
+		return "alias exists"
+	case errors.Is(err, storage.ErrURLExists):
+		if existing, err := store.GetAliasCtx(ctx, row.url); err == nil {
+			return "url exists with alias " + existing.Alias
+		}
+		return "url exists"
+	default:
+		logger.Log.Error("import url", zap.Int("line", row.line), zap.Error(err))
+		return "can't store url"
+	}
+}
+
+// runImport stores rows and reports progress of the job
+func runImport(ctx context.Context, store storage.URLStorage, registry *jobs.Registry, jobID string, uid string, rows []importRow) {
+	registry.Start(jobID)
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			registry.Finish(jobID, err)
+			return
+		}
+		if msg := importURL(ctx, store, uid, row); msg != "" {
+			registry.Progress(jobID, &jobs.ItemError{Item: row.line, Key: row.url, Error: msg})
+			continue
+		}
+		registry.Progress(jobID, nil)
+	}
+	registry.Finish(jobID, nil)
+	logger.Log.Debug("import is finished", zap.String("job", jobID), zap.Int("rows", len(rows)))
+}
+
+// JobRunner runs background jobs outliving requests
+type JobRunner interface {
+	Go(fn func(ctx context.Context)) error
+}
+
+// ImportUserUrlsHandler imports urls of the user from CSV file (route POST /api/user/urls/import).
+// The file is sent as text/csv body or as "file" field of multipart form.
+// Each row contains url and optional custom alias, rows are stored by asynchronous job,
+// its progress and row errors are returned by /api/user/jobs/{id}.
+//
+// Request
+//
+//	POST /api/user/urls/import HTTP/1.1
+//	Content-Type: text/csv
+//
+//	url,alias
+//	https://practicum.yandex.ru/,practicum
+//	https://go.dev/,
+//
+// Response
+//
+//	HTTP/1.1 202 Accepted
+//	Location: /api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a
+//
+//	{ "job_id": "5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "status_url": "/api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a" }
+func ImportUserUrlsHandler(store storage.URLStorage, registry *jobs.Registry, token client.AuthToken, runner JobRunner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		defer r.Body.Close()
+
+		var body io.Reader
+		ct := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+		switch ct {
+		case "text/csv", "":
+			body = r.Body
+		case "multipart/form-data":
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				logger.Log.Debug("cannot get uploaded file", zap.Error(err))
+				http.Error(w, "file is required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			body = file
+		default:
+			msg := "Content-Type is not text/csv"
+			logger.Log.Debug(msg, zap.String("is", ct))
+			http.Error(w, msg, http.StatusUnsupportedMediaType)
+			return
+		}
+
+		rows, err := parseImportCSV(body)
+		if err != nil {
+			logger.Log.Debug("cannot parse CSV", zap.Error(err))
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "file is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid CSV: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(rows) == 0 {
+			http.Error(w, "no urls to import", http.StatusBadRequest)
+			return
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		jobID := registry.Create(uid, jobs.KindImport, len(rows))
+		// job outlives the request, it is waited for on shutdown
+		err = runner.Go(func(ctx context.Context) {
+			runImport(ctx, store, registry, jobID, uid, rows)
+		})
+		if err != nil {
+			logger.Log.Error("cannot run import", zap.Error(err))
+			registry.Finish(jobID, err)
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJobAccepted(w, jobID)
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/rookgm/shortener/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []importRow
+		wantErr bool
+	}{
+		{
+			name: "header",
+			csv:  "alias,url\npracticum,https://practicum.yandex.ru/\n,https://go.dev/\n",
+			want: []importRow{
+				{line: 2, url: "https://practicum.yandex.ru/", alias: "practicum"},
+				{line: 3, url: "https://go.dev/"},
+			},
+		},
+		{
+			name: "header_without_alias",
+			csv:  "URL\nhttps://go.dev/\n",
+			want: []importRow{{line: 2, url: "https://go.dev/"}},
+		},
+		{
+			name: "no_header",
+			csv:  "https://practicum.yandex.ru/, practicum\nhttps://go.dev/\n",
+			want: []importRow{
+				{line: 1, url: "https://practicum.yandex.ru/", alias: "practicum"},
+				{line: 2, url: "https://go.dev/"},
+			},
+		},
+		{
+			name:    "bad_quotes",
+			csv:     "\"https://go.dev/\nhttps://ya.ru\"\"x",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImportCSV(strings.NewReader(tt.csv))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestImportUserUrlsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(context.Background(), models.ShrURL{Alias: "taken", URL: "https://yandex.ru/", UserID: "other"}))
+
+	registry := jobs.NewRegistry()
+	importer := worker.NewGroup()
+	router := chi.NewRouter()
+	router.Post("/api/user/urls/import", ImportUserUrlsHandler(st, registry, auth, importer))
+	router.Get("/api/user/jobs/{id}", GetJobHandler(registry, auth))
+
+	do := func(req *http.Request, token string) *httptest.ResponseRecorder {
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: token})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// getJob polls job until it is finished
+	getJob := func(location string) APIJob {
+		var job APIJob
+		require.Eventually(t, func() bool {
+			w := do(httptest.NewRequest(http.MethodGet, location, nil), userToken)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			return job.FinishedAt != nil
+		}, time.Second, 10*time.Millisecond)
+		return job
+	}
+
+	t.Run("csv_body", func(t *testing.T) {
+		body := "url,alias\n" +
+			"https://practicum.yandex.ru/,practicum\n" +
+			"https://go.dev/,\n" +
+			"https://go.dev/,golang\n" +
+			"not a url,bad\n" +
+			"https://ya.ru/,api\n" +
+			"https://ya.ru/,taken\n"
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		w := do(req, userToken)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		var accepted APIJobAccepted
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&accepted))
+		location := w.Header().Get("Location")
+		assert.Equal(t, "/api/user/jobs/"+accepted.JobID, location)
+		assert.Equal(t, location, accepted.StatusURL)
+
+		job := getJob(location)
+		assert.Equal(t, jobs.KindImport, job.Kind)
+		assert.Equal(t, jobs.StatusPartial, job.Status)
+		assert.Equal(t, 6, job.Total)
+		assert.Equal(t, 6, job.Processed)
+		assert.Equal(t, 4, job.Failed)
+		require.Len(t, job.Errors, 4)
+		assert.Equal(t, APIJobError{Item: 4, Key: "https://go.dev/", Error: job.Errors[0].Error}, job.Errors[0])
+		assert.True(t, strings.HasPrefix(job.Errors[0].Error, "url exists with alias "), job.Errors[0].Error)
+		assert.Equal(t, []APIJobError{
+			{Item: 5, Key: "not a url", Error: "invalid url"},
+			{Item: 6, Key: "https://ya.ru/", Error: "invalid alias"},
+			{Item: 7, Key: "https://ya.ru/", Error: "alias exists"},
+		}, job.Errors[1:])
+
+		v, err := st.GetURLCtx(context.Background(), "practicum")
+		require.NoError(t, err)
+		assert.Equal(t, userID, v.UserID)
+
+		// job is not shown to another user
+		w = do(httptest.NewRequest(http.MethodGet, location, nil), otherToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, err := mw.CreateFormFile("file", "links.csv")
+		require.NoError(t, err)
+		_, err = fw.Write([]byte("https://practicum.yandex.ru/learn\n"))
+		require.NoError(t, err)
+		require.NoError(t, mw.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := do(req, userToken)
+		require.Equal(t, http.StatusAccepted, w.Code)
+
+		job := getJob(w.Header().Get("Location"))
+		assert.Equal(t, jobs.StatusDone, job.Status)
+		assert.Equal(t, 1, job.Processed)
+		assert.Zero(t, job.Failed)
+	})
+
+	t.Run("bad_requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", strings.NewReader("url\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		assert.Equal(t, http.StatusBadRequest, do(req, userToken).Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/api/user/urls/import", strings.NewReader(`["https://go.dev/"]`))
+		req.Header.Set("Content-Type", "application/json")
+		assert.Equal(t, http.StatusUnsupportedMediaType, do(req, userToken).Code)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		require.NoError(t, importer.Shutdown(context.Background()))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", strings.NewReader("https://go.dev/learn\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		assert.Equal(t, http.StatusServiceUnavailable, do(req, userToken).Code)
+	})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// jobsPath is path of job status, job ID is appended to it
+const jobsPath = "/api/user/jobs/"
+
+// APIJob represents state of asynchronous job
+type APIJob struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	// Total is number of items to process
+	Total     int           `json:"total"`
+	Processed int           `json:"processed"`
+	Failed    int           `json:"failed"`
+	Errors    []APIJobError `json:"errors,omitempty"`
+	// Error is error stopped the whole job
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// APIJobError represents error of one item of job
+type APIJobError struct {
+	// Item is number of item, for import it is line of CSV file
+	Item  int    `json:"item"`
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error"`
+}
+
+// APIJobAccepted represents response on starting asynchronous job
+type APIJobAccepted struct {
+	JobID string `json:"job_id"`
+	// StatusURL is path to poll the job status
+	StatusURL string `json:"status_url"`
+}
+
+// writeJobAccepted writes 202 Accepted response referencing the job status
+func writeJobAccepted(w http.ResponseWriter, jobID string) {
+	statusURL := jobsPath + jobID
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", statusURL)
+	w.WriteHeader(http.StatusAccepted)
+
+	if err := json.NewEncoder(w).Encode(APIJobAccepted{JobID: jobID, StatusURL: statusURL}); err != nil {
+		logger.Log.Error("cannot encode JSON body", zap.Error(err))
+		return
+	}
+}
+
+// GetJobHandler returns progress of user's job (route /api/user/jobs/{id}),
+// jobs of other users are not found
+func GetJobHandler(registry *jobs.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		job, ok := registry.Get(chi.URLParam(r, "id"))
+		if !ok || uid == "" || job.UserID != uid {
+			http.Error(w, "job not found", http.StatusNotFound)
+			return
+		}
+
+		resp := APIJob{
+			ID:        job.ID,
+			Kind:      job.Kind,
+			Status:    job.Status,
+			Total:     job.Total,
+			Processed: job.Processed,
+			Failed:    job.Failed,
+			Error:     job.Err,
+			CreatedAt: job.CreatedAt,
+		}
+		if !job.FinishedAt.IsZero() {
+			resp.FinishedAt = &job.FinishedAt
+		}
+		for _, e := range job.Errors {
+			resp.Errors = append(resp.Errors, APIJobError{Item: e.Item, Key: e.Key, Error: e.Error})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/oidc"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// cookies of login at identity provider
+const (
+	oidcStateCookie = "oidc_state"
+	oidcMergeCookie = "oidc_merge"
+	oidcCookiePath  = "/api/user/oidc"
+	oidcCookieTTL   = 10 * time.Minute
+)
+
+// setOIDCCookie sets cookie of login, it is sent back on redirect from identity provider
+func setOIDCCookie(w http.ResponseWriter, name string, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		// redirect from identity provider is cross-site navigation
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCLoginHandler redirects user to identity provider (route GET /api/user/oidc/login).
+// Links created anonymously are transferred to the account after login if merge=true is set.
+//
+// Request
+//
+//	GET /api/user/oidc/login?merge=true HTTP/1.1
+//
+// Response
+//
+//	HTTP/1.1 302 Found
+//	Location: https://sso.example.com/authorize?response_type=code&client_id=shortener&...
+func OIDCLoginHandler(provider *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authURL, state, err := provider.Start(r.Context())
+		if err != nil {
+			logger.Log.Error("cannot start oidc login", zap.Error(err))
+			http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		// state binds callback to the browser started login
+		setOIDCCookie(w, oidcStateCookie, state, oidcCookieTTL)
+		if r.URL.Query().Get("merge") == "true" {
+			setOIDCCookie(w, oidcMergeCookie, "true", oidcCookieTTL)
+		}
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler finishes login at identity provider and issues auth cookie of its user
+// (route GET /api/user/oidc/callback). User ID is derived from issuer and subject of ID token,
+// so the user gets the same links on every login.
+//
+// Request
+//
+//	GET /api/user/oidc/callback?code=SplxlOBeZQQYbYS6WxSbIA&state=af0ifjsldkj HTTP/1.1
+//
+// Response
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//	Set-Cookie: auth_shortener=...
+//
+//	{ "user_id": "8e1b7f0c-3a5d-5c2e-9f4b-6d7a8c9e0f1a", "login": "jane@example.com", "merged": 2 }
+func OIDCCallbackHandler(provider *oidc.Provider, users storage.UserStorage, store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			logger.Log.Debug("oidc login is rejected", zap.String("error", e), zap.String("description", q.Get("error_description")))
+			http.Error(w, "login is rejected by identity provider", http.StatusUnauthorized)
+			return
+		}
+
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || cookie.Value == "" || cookie.Value != q.Get("state") {
+			http.Error(w, "invalid login state", http.StatusBadRequest)
+			return
+		}
+		merge := false
+		if c, err := r.Cookie(oidcMergeCookie); err == nil {
+			merge = c.Value == "true"
+		}
+		setOIDCCookie(w, oidcStateCookie, "", -1)
+		setOIDCCookie(w, oidcMergeCookie, "", -1)
+
+		claims, err := provider.Finish(r.Context(), q.Get("state"), q.Get("code"))
+		switch {
+		case errors.Is(err, oidc.ErrUnknownState):
+			http.Error(w, "login is expired", http.StatusBadRequest)
+			return
+		case errors.Is(err, oidc.ErrInvalidToken):
+			logger.Log.Warn("invalid oidc ID token", zap.Error(err))
+			http.Error(w, "invalid ID token", http.StatusUnauthorized)
+			return
+		case err != nil:
+			logger.Log.Error("cannot finish oidc login", zap.Error(err))
+			http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+
+		login := claims.Email
+		if login == "" {
+			login = claims.Subject
+		}
+		user := models.User{ID: claims.UserID(), Login: login}
+		loginAccount(w, r, users, store, token, user, merge, http.StatusOK)
+	}
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/oidc"
+	"github.com/rookgm/shortener/internal/oidc/oidctest"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCHandlers(t *testing.T) {
+	idp := oidctest.NewIdP("shortener", "client secret")
+	defer idp.Close()
+
+	ctx := context.Background()
+	auth := client.NewAuthToken([]byte("secretkey"))
+	st := storage.NewMemStorage()
+
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "shortener",
+		ClientSecret: "client secret",
+		RedirectURL:  srv.URL + "/api/user/oidc/callback",
+	})
+	router := chi.NewRouter()
+	router.Get("/api/user/oidc/login", OIDCLoginHandler(provider))
+	router.Get("/api/user/oidc/callback", OIDCCallbackHandler(provider, st, st, auth))
+	srv.Config.Handler = router
+
+	srvURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	wantUserID := oidc.Claims{Issuer: idp.Issuer(), Subject: idp.Subject}.UserID()
+
+	// login follows redirects through identity provider and returns response of callback
+	login := func(t *testing.T, query string, token string) (*http.Response, APIAccount, string) {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		if token != "" {
+			jar.SetCookies(srvURL, []*http.Cookie{{Name: "auth_shortener", Value: token}})
+		}
+		httpClient := &http.Client{Jar: jar}
+
+		resp, err := httpClient.Get(srv.URL + "/api/user/oidc/login" + query)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var account APIAccount
+		var uid string
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&account))
+			for _, c := range jar.Cookies(srvURL) {
+				if c.Name == "auth_shortener" {
+					uid, err = auth.Verify(c.Value)
+					require.NoError(t, err)
+				}
+			}
+		}
+		return resp, account, uid
+	}
+
+	anonToken, err := auth.Create()
+	require.NoError(t, err)
+	anonID, err := auth.Verify(anonToken)
+	require.NoError(t, err)
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: anonID}))
+
+	resp, account, uid := login(t, "?merge=true", anonToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, wantUserID, account.UserID)
+	assert.Equal(t, idp.Email, account.Login)
+	assert.Equal(t, 1, account.Merged)
+	// auth cookie is issued for user of identity provider
+	assert.Equal(t, wantUserID, uid)
+	urls, err := st.GetUserURLsCtx(ctx, wantUserID)
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "6qxTVvsy", urls[0].Alias)
+
+	t.Run("same_user", func(t *testing.T) {
+		resp, account, uid := login(t, "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, wantUserID, account.UserID)
+		assert.Equal(t, wantUserID, uid)
+		assert.Zero(t, account.Merged)
+	})
+
+	t.Run("invalid_token", func(t *testing.T) {
+		idp.Modify = func(claims jwt.MapClaims) { claims["aud"] = "other" }
+		defer func() { idp.Modify = nil }()
+
+		resp, _, _ := login(t, "", "")
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("state_mismatch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/oidc/callback?code=code&state=forged", nil)
+		req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "other"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/oidc/callback?error=access_denied&state=s", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
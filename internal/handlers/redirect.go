@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/platform"
+)
+
+// variantCookiePrefix is prefix of cookie keeping A/B variant served to the visitor
+const variantCookiePrefix = "shortener_ab_"
+
+// variantCookieMaxAge is lifetime of A/B variant cookie
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+// redirectTarget returns redirect target of the link and name of served variant.
+// Platform rule has the highest priority, then A/B variant, then original URL.
+func redirectTarget(w http.ResponseWriter, r *http.Request, link models.ShrURL) (string, string) {
+	if target, ok := platformTarget(r, link); ok {
+		return target, ""
+	}
+	if v, ok := variantTarget(w, r, link); ok {
+		return v.URL, v.Name
+	}
+	return link.URL, ""
+}
+
+// platformTarget returns redirect target matching client platform
+func platformTarget(r *http.Request, link models.ShrURL) (string, bool) {
+	if len(link.Rules) == 0 {
+		return "", false
+	}
+	p := platform.Detect(r.UserAgent())
+	for _, rule := range link.Rules {
+		if rule.Platform == p {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
+
+// variantTarget picks weighted A/B variant of the link for the visitor.
+// The variant is kept in cookie, so the visitor gets the same variant next time.
+func variantTarget(w http.ResponseWriter, r *http.Request, link models.ShrURL) (models.Variant, bool) {
+	if len(link.Variants) == 0 {
+		return models.Variant{}, false
+	}
+
+	cookieName := variantCookiePrefix + link.Alias
+
+	// sticky variant
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		for _, v := range link.Variants {
+			if v.Name == cookie.Value {
+				return v, true
+			}
+		}
+	}
+
+	total := 0
+	for _, v := range link.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return models.Variant{}, false
+	}
+
+	picked := link.Variants[len(link.Variants)-1]
+	n := rand.Intn(total)
+	for _, v := range link.Variants {
+		if n < v.Weight {
+			picked = v
+			break
+		}
+		n -= v.Weight
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    picked.Name,
+		Path:     "/" + link.Alias,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+	})
+
+	return picked, true
+}
+
+// applyUTM appends utm parameters to raw url.
+// Parameters set in the link override the same parameters of the url.
+func applyUTM(rawURL string, utm models.UTM) (string, error) {
+	if utm.IsEmpty() {
+		return rawURL, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	for k, v := range map[string]string{
+		"utm_source":   utm.Source,
+		"utm_medium":   utm.Medium,
+		"utm_campaign": utm.Campaign,
+		"utm_content":  utm.Content,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// maxTagLength is maximum length of tag in characters
+const maxTagLength = 64
+
+// APITagCount represents number of user's urls having the tag
+type APITagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// normalizeTags trims and lowercases tags, so "Project " and "project" are the same tag
+func normalizeTags(tags []string) ([]string, error) {
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			return nil, errors.New("empty tag")
+		}
+		if utf8.RuneCountInString(t) > maxTagLength {
+			return nil, errors.New("tag is too long")
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// updateURLTagsHandler changes tags of user's url by tags from request body
+func updateURLTagsHandler(store storage.URLStorage, token client.AuthToken, remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Log.Debug("check Content-Type")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			if !strings.Contains(st, "application/json") {
+				msg := "Content-Type is not application/json"
+				logger.Log.Debug(msg, zap.String("is", ct))
+				http.Error(w, msg, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		var reqTags []string
+
+		logger.Log.Debug("decode request")
+		if err := json.NewDecoder(r.Body).Decode(&reqTags); err != nil {
+			logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		tags, err := normalizeTags(reqTags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		alias := chi.URLParam(r, "alias")
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		var add, del []string
+		if remove {
+			del = tags
+		} else {
+			add = tags
+		}
+
+		res, err := store.UpdateURLTagsCtx(r.Context(), uid, alias, add, del)
+		if err != nil {
+			logger.Log.Debug("cannot update url tags", zap.String("alias", alias), zap.Error(err))
+			switch {
+			case errors.Is(err, storage.ErrURLNotFound):
+				http.Error(w, "url not found", http.StatusNotFound)
+			case errors.Is(err, storage.ErrNotOwner):
+				http.Error(w, "forbidden", http.StatusForbidden)
+			case errors.Is(err, storage.ErrURLDeleted):
+				http.Error(w, "url is deleted", http.StatusGone)
+			default:
+				http.Error(w, "can't update url tags", http.StatusInternalServerError)
+			}
+			return
+		}
+		if res == nil {
+			res = []string{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// PutURLTagsHandler adds tags to user's url (route PUT /api/user/urls/{alias}/tags).
+// Request body is JSON array of tags, response is all tags of the url.
+func PutURLTagsHandler(store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return updateURLTagsHandler(store, token, false)
+}
+
+// DeleteURLTagsHandler removes tags from user's url (route DELETE /api/user/urls/{alias}/tags).
+// Request body is JSON array of tags, response is remaining tags of the url.
+func DeleteURLTagsHandler(store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return updateURLTagsHandler(store, token, true)
+}
+
+// GetUserTagsHandler returns tags of user's urls with number of urls (route /api/user/tags)
+func GetUserTagsHandler(store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		tags, err := store.GetUserTagsCtx(r.Context(), uid)
+		if err != nil {
+			logger.Log.Error("get user tags from storage", zap.Error(err))
+			http.Error(w, "can't get user tags", http.StatusInternalServerError)
+			return
+		}
+
+		resp := make([]APITagCount, 0, len(tags))
+		for _, tc := range tags {
+			resp = append(resp, APITagCount{Tag: tc.Tag, Count: tc.Count})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
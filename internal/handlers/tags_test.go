@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLTagsHandlers(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	ownerToken, err := auth.Create()
+	require.NoError(t, err)
+	ownerID, err := auth.Verify(ownerToken)
+	require.NoError(t, err)
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "EwHXdJfB", URL: "https://practicum.yandex.ru/", UserID: ownerID}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: ownerID}))
+
+	router := chi.NewRouter()
+	router.Put("/api/user/urls/{alias}/tags", PutURLTagsHandler(st, auth))
+	router.Delete("/api/user/urls/{alias}/tags", DeleteURLTagsHandler(st, auth))
+	router.Get("/api/user/tags", GetUserTagsHandler(st, auth))
+
+	tests := []struct {
+		name       string
+		method     string
+		alias      string
+		token      string
+		body       string
+		wantStatus int
+		want       []string
+	}{
+		{
+			name:       "add_tags",
+			method:     http.MethodPut,
+			alias:      "EwHXdJfB",
+			token:      ownerToken,
+			body:       `["Study ", "go"]`,
+			wantStatus: http.StatusOK,
+			want:       []string{"go", "study"},
+		},
+		{
+			name:       "add_tags_to_another_url",
+			method:     http.MethodPut,
+			alias:      "6qxTVvsy",
+			token:      ownerToken,
+			body:       `["go"]`,
+			wantStatus: http.StatusOK,
+			want:       []string{"go"},
+		},
+		{
+			name:       "remove_tag",
+			method:     http.MethodDelete,
+			alias:      "EwHXdJfB",
+			token:      ownerToken,
+			body:       `["study", "unknown"]`,
+			wantStatus: http.StatusOK,
+			want:       []string{"go"},
+		},
+		{
+			name:       "empty_tag",
+			method:     http.MethodPut,
+			alias:      "EwHXdJfB",
+			token:      ownerToken,
+			body:       `[" "]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "another_user",
+			method:     http.MethodPut,
+			alias:      "EwHXdJfB",
+			token:      otherToken,
+			body:       `["mine"]`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unknown_url",
+			method:     http.MethodDelete,
+			alias:      "unknown",
+			token:      ownerToken,
+			body:       `["go"]`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/user/urls/"+tt.alias+"/tags", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: tt.token})
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got []string
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("tag_counts", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/tags", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: ownerToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var got []APITagCount
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		assert.Equal(t, []APITagCount{{Tag: "go", Count: 2}}, got)
+	})
+}
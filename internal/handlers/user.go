@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/jobs"
 	"github.com/rookgm/shortener/internal/logger"
 	"github.com/rookgm/shortener/internal/models"
 	"github.com/rookgm/shortener/internal/storage"
@@ -17,52 +22,208 @@ import (
 type UserURL struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	// Deleted is set only for deleted urls listed on request
+	Deleted bool `json:"is_deleted,omitempty"`
 }
 
-// GetUserUrlsHandler returns all urls to the user (route /api/user/urls)
+// user urls listing query parameters
+const (
+	listParamLimit         = "limit"
+	listParamCursor        = "cursor"
+	listParamSort          = "sort"
+	listParamDomain        = "domain"
+	listParamCreatedAfter  = "created_after"
+	listParamCreatedBefore = "created_before"
+	listParamDeleted       = "deleted"
+	listParamTag           = "tag"
+)
+
+// maxListLimit is maximum page size of user urls listing
+const maxListLimit = 1000
+
+// parseUserURLsQuery parses listing options from request query.
+// It returns false if the request has no listing options.
+func parseUserURLsQuery(r *http.Request, uid string) (models.UserURLsQuery, bool, error) {
+	params := r.URL.Query()
+	q := models.UserURLsQuery{UserID: uid, SortBy: models.SortByCreated}
+
+	found := false
+	for _, p := range []string{listParamLimit, listParamCursor, listParamSort, listParamDomain,
+		listParamCreatedAfter, listParamCreatedBefore, listParamDeleted, listParamTag} {
+		if params.Has(p) {
+			found = true
+		}
+	}
+	if !found {
+		return q, false, nil
+	}
+
+	var err error
+	if v := params.Get(listParamLimit); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return q, true, errors.New("invalid limit")
+		}
+	}
+	switch v := params.Get(listParamSort); v {
+	case "", models.SortByCreated:
+	case models.SortByClicks:
+		q.SortBy = v
+	default:
+		return q, true, errors.New("invalid sort")
+	}
+	q.Cursor = params.Get(listParamCursor)
+	q.Domain = params.Get(listParamDomain)
+	q.Tag = strings.ToLower(strings.TrimSpace(params.Get(listParamTag)))
+	if v := params.Get(listParamCreatedAfter); v != "" {
+		if q.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, true, errors.New("invalid created_after")
+		}
+	}
+	if v := params.Get(listParamCreatedBefore); v != "" {
+		if q.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, true, errors.New("invalid created_before")
+		}
+	}
+	if v := params.Get(listParamDeleted); v != "" {
+		if q.IncludeDeleted, err = strconv.ParseBool(v); err != nil {
+			return q, true, errors.New("invalid deleted")
+		}
+	}
+	return q, true, nil
+}
+
+// nextPageLink returns value of Link header pointing to the next page of listing with params
+func nextPageLink(r *http.Request, params url.Values, cursor string) string {
+	params.Set(listParamCursor, cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+	return "<" + next.String() + `>; rel="next"`
+}
+
+// listUserURLs writes page of user urls, the next page is referenced by Link header
+// and its cursor is returned in X-Next-Cursor header.
+// Listing without options in query returns no content if the user has no urls.
+func listUserURLs(w http.ResponseWriter, r *http.Request, store storage.URLStorage, baseURL string, q models.UserURLsQuery, paged bool) {
+	uurls, cursor, err := store.ListUserURLsCtx(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		logger.Log.Error("list user urls from storage", zap.Error(err))
+		http.Error(w, "can't get user urls", http.StatusInternalServerError)
+		return
+	}
+
+	userURLResp := make([]UserURL, 0, len(uurls))
+	for _, uurl := range uurls {
+		urlPath, err := url.JoinPath(baseURL, uurl.Alias)
+		if err != nil {
+			logger.Log.Error("join url path", zap.Error(err))
+			continue
+		}
+		userURLResp = append(userURLResp, UserURL{
+			ShortURL:    urlPath,
+			OriginalURL: uurl.URL,
+			Deleted:     uurl.Deleted,
+		})
+	}
+
+	if !paged && len(userURLResp) == 0 {
+		logger.Log.Debug("user urls does not exist", zap.String("id", q.UserID))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if cursor != "" {
+		params := r.URL.Query()
+		if !paged {
+			// the next pages keep options of default listing
+			params.Set(listParamLimit, strconv.Itoa(q.Limit))
+			params.Set(listParamDeleted, strconv.FormatBool(q.IncludeDeleted))
+		}
+		w.Header().Set("Link", nextPageLink(r, params, cursor))
+		w.Header().Set("X-Next-Cursor", cursor)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(userURLResp); err != nil {
+		logger.Log.Error("cannot encode JSON body", zap.Error(err))
+		return
+	}
+}
+
+// defaultUserURLsLimit is page size of listing without options, deleted urls are listed too
+const defaultUserURLsLimit = maxListLimit
+
+// GetUserUrlsHandler returns urls of the user by pages (route /api/user/urls).
+// Listing options may be set in query:
+// limit, cursor, sort (created or clicks), domain, tag, created_after, created_before (RFC 3339), deleted.
+// Without options the newest defaultUserURLsLimit urls are returned including deleted ones.
+// The next page is referenced by Link header, its cursor is returned in X-Next-Cursor header.
 func GetUserUrlsHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// extract user ID from request cookie
 		uid := token.GetUserID(r)
-		// get all user urls by id from storage
-		logger.Log.Debug("trying get user urls", zap.String("id", uid))
-		uurls, err := store.GetUserURLsCtx(r.Context(), uid)
+
+		q, paged, err := parseUserURLsQuery(r, uid)
 		if err != nil {
-			logger.Log.Error("get user urls from storage", zap.Error(err))
-			http.Error(w, "can't get user urls", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		status := http.StatusOK
+		if !paged {
+			q.Limit = defaultUserURLsLimit
+			q.IncludeDeleted = true
+		}
 
-		w.Header().Set("Content-Type", "application/json")
+		logger.Log.Debug("trying get user urls", zap.String("id", uid))
+		listUserURLs(w, r, store, baseURL, q, paged)
+	}
+}
 
-		// user url is not exist
-		if len(uurls) == 0 {
-			logger.Log.Warn("user urls does not exist", zap.String("id", uid))
-			status = http.StatusNoContent
-			http.Error(w, "can't get user urls", http.StatusNoContent)
-			return
-		}
+// SearchUserUrlsHandler returns user urls found by words of destination, title or note
+// (route /api/user/urls/search?q=). Words of the query are matched as prefixes,
+// the best matches go first.
+func SearchUserUrlsHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
 
-		w.WriteHeader(status)
+		limit := 0
+		if v := r.URL.Query().Get(listParamLimit); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 1 || limit > maxListLimit {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
 
-		// output user urls
-		var userURLResp []UserURL
+		uurls, err := store.SearchUserURLsCtx(r.Context(), uid, r.URL.Query().Get("q"), limit)
+		if err != nil {
+			if errors.Is(err, storage.ErrEmptyQuery) {
+				http.Error(w, "empty search query", http.StatusBadRequest)
+				return
+			}
+			logger.Log.Error("search user urls in storage", zap.Error(err))
+			http.Error(w, "can't search user urls", http.StatusInternalServerError)
+			return
+		}
 
-		// prepare user urls
+		userURLResp := make([]UserURL, 0, len(uurls))
 		for _, uurl := range uurls {
 			urlPath, err := url.JoinPath(baseURL, uurl.Alias)
 			if err != nil {
 				logger.Log.Error("join url path", zap.Error(err))
 				continue
 			}
-			res := UserURL{
-				ShortURL:    urlPath,
-				OriginalURL: uurl.URL,
-			}
-			userURLResp = append(userURLResp, res)
+			userURLResp = append(userURLResp, UserURL{ShortURL: urlPath, OriginalURL: uurl.URL})
 		}
-		// encode user urls to json and put it to response
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
 		if err := json.NewEncoder(w).Encode(userURLResp); err != nil {
 			logger.Log.Error("cannot encode JSON body", zap.Error(err))
 			return
@@ -70,8 +231,379 @@ func GetUserUrlsHandler(store storage.URLStorage, baseURL string, token client.A
 	}
 }
 
-// DeleteUserUrlsHandler deletes user urls
-func DeleteUserUrlsHandler(store storage.URLStorage, token client.AuthToken, fanInCh chan<- models.UserDeleteTask) http.HandlerFunc {
+// getOwnedURL returns url by alias from path if it belongs to the user.
+// Otherwise, it writes error response and returns false.
+func getOwnedURL(w http.ResponseWriter, r *http.Request, store storage.URLStorage, token client.AuthToken) (models.ShrURL, bool) {
+	alias := chi.URLParam(r, "alias")
+	// extract user ID from request cookie
+	uid := token.GetUserID(r)
+
+	uurl, err := store.GetURLCtx(r.Context(), alias)
+	if err != nil {
+		if errors.Is(err, storage.ErrURLNotFound) {
+			http.Error(w, "url not found", http.StatusNotFound)
+			return models.ShrURL{}, false
+		}
+		logger.Log.Error("get url from storage", zap.Error(err))
+		http.Error(w, "can't get url", http.StatusInternalServerError)
+		return models.ShrURL{}, false
+	}
+
+	if uid == "" || uurl.UserID != uid {
+		logger.Log.Debug("url belongs to another user", zap.String("alias", alias), zap.String("id", uid))
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return models.ShrURL{}, false
+	}
+
+	return uurl, true
+}
+
+// URLStats represents click statistics of user's url
+type URLStats struct {
+	ShortURL string         `json:"short_url"`
+	Clicks   int64          `json:"clicks"`
+	Variants []VariantStats `json:"variants,omitempty"`
+}
+
+// VariantStats represents click statistics of A/B variant
+type VariantStats struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks"`
+}
+
+// GetUserURLStatsHandler returns click statistics of user's url (route /api/user/urls/{alias}/stats).
+// Clicks are counted per A/B variant, so variants conversion can be compared.
+func GetUserURLStatsHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uurl, ok := getOwnedURL(w, r, store, token)
+		if !ok {
+			return
+		}
+
+		var err error
+
+		stats := URLStats{Clicks: uurl.Clicks}
+		stats.ShortURL, err = url.JoinPath(baseURL, uurl.Alias)
+		if err != nil {
+			logger.Log.Error("join url path", zap.Error(err))
+			http.Error(w, "can't get url", http.StatusInternalServerError)
+			return
+		}
+		for _, v := range uurl.Variants {
+			stats.Variants = append(stats.Variants, VariantStats{
+				Name:   v.Name,
+				URL:    v.URL,
+				Weight: v.Weight,
+				Clicks: v.Clicks,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// UserURLInfo represents user's url with its options
+type UserURLInfo struct {
+	ShortURL    string       `json:"short_url"`
+	OriginalURL string       `json:"original_url"`
+	UTM         *APIUTM      `json:"utm,omitempty"`
+	Rules       []APIRule    `json:"rules,omitempty"`
+	Variants    []APIVariant `json:"variants,omitempty"`
+	Title       string       `json:"title,omitempty"`
+	Note        string       `json:"note,omitempty"`
+	Tags        []string     `json:"tags,omitempty"`
+	Version     int64        `json:"version"`
+}
+
+// APIURLPatch represents changes of user's url in JSON format.
+// Omitted fields are left unchanged, empty arrays remove rules or variants.
+type APIURLPatch struct {
+	URL      *string       `json:"url,omitempty"`
+	UTM      *APIUTM       `json:"utm,omitempty"`
+	Rules    *[]APIRule    `json:"rules,omitempty"`
+	Variants *[]APIVariant `json:"variants,omitempty"`
+	Title    *string       `json:"title,omitempty"`
+	Note     *string       `json:"note,omitempty"`
+	// Version is expected version of the url, it may be passed in If-Match header instead.
+	Version *int64 `json:"version,omitempty"`
+}
+
+// etag returns entity tag of url version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// writeUserURLInfo writes url with its options and ETag header
+func writeUserURLInfo(w http.ResponseWriter, baseURL string, uurl models.ShrURL) {
+	shortURL, err := url.JoinPath(baseURL, uurl.Alias)
+	if err != nil {
+		logger.Log.Error("join url path", zap.Error(err))
+		http.Error(w, "can't get url", http.StatusInternalServerError)
+		return
+	}
+
+	info := UserURLInfo{
+		ShortURL:    shortURL,
+		OriginalURL: uurl.URL,
+		UTM:         utmFromModel(uurl.UTM),
+		Rules:       rulesFromModel(uurl.Rules),
+		Variants:    variantsFromModel(uurl.Variants),
+		Title:       uurl.Title,
+		Note:        uurl.Note,
+		Tags:        uurl.Tags,
+		Version:     uurl.Version,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(uurl.Version))
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		logger.Log.Error("cannot encode JSON body", zap.Error(err))
+		return
+	}
+}
+
+// GetUserURLHandler returns user's url with its options and version in ETag header
+// (route GET /api/user/urls/{alias})
+func GetUserURLHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uurl, ok := getOwnedURL(w, r, store, token)
+		if !ok {
+			return
+		}
+		writeUserURLInfo(w, baseURL, uurl)
+	}
+}
+
+// PatchUserURLHandler changes destination and options of user's url (route PATCH /api/user/urls/{alias}).
+// The expected version is passed in If-Match header (ETag of GET response) or in "version" field,
+// if the url was changed since that version 412 Precondition Failed is returned.
+//
+// Request
+//
+//	PATCH /api/user/urls/EwHXdJfB HTTP/1.1
+//	Content-Type: application/json
+//	If-Match: "3"
+//
+//	{ "url": "https://practicum.yandex.ru/new", "utm": { "campaign": "autumn" } }
+//
+// Response
+//
+//	HTTP/1.1 200 OK
+//	Content-Type: application/json
+//	ETag: "4"
+func PatchUserURLHandler(store storage.URLStorage, baseURL string, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Log.Debug("check Content-Type")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			if !strings.Contains(st, "application/json") {
+				msg := "Content-Type is not application/json"
+				logger.Log.Debug(msg, zap.String("is", ct))
+				http.Error(w, msg, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		var patch APIURLPatch
+
+		logger.Log.Debug("decode request")
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		uurl, ok := getOwnedURL(w, r, store, token)
+		if !ok {
+			return
+		}
+
+		// expected version
+		var version int64
+		switch ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); {
+		case ifMatch == "*":
+			version = uurl.Version
+		case ifMatch != "":
+			v, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid If-Match header", http.StatusBadRequest)
+				return
+			}
+			version = v
+		case patch.Version != nil:
+			version = *patch.Version
+		default:
+			http.Error(w, "url version is required", http.StatusPreconditionRequired)
+			return
+		}
+
+		if patch.URL != nil {
+			if *patch.URL == "" {
+				http.Error(w, "empty url", http.StatusBadRequest)
+				return
+			}
+			uurl.URL = *patch.URL
+		}
+		if patch.UTM != nil {
+			uurl.UTM = patch.UTM.toModel()
+		}
+		if patch.Rules != nil {
+			rules, err := rulesToModel(*patch.Rules)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			uurl.Rules = rules
+		}
+		if patch.Variants != nil {
+			variants, err := variantsToModel(*patch.Variants)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			uurl.Variants = variants
+		}
+		if patch.Title != nil {
+			uurl.Title = *patch.Title
+		}
+		if patch.Note != nil {
+			uurl.Note = *patch.Note
+		}
+		if msg := validateDescription(uurl.Title, uurl.Note); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		updated, err := store.UpdateURLCtx(r.Context(), uurl, version)
+		if err != nil {
+			logger.Log.Debug("cannot update url", zap.String("alias", uurl.Alias), zap.Error(err))
+			switch {
+			case errors.Is(err, storage.ErrVersionMismatch):
+				http.Error(w, "url was changed by another request", http.StatusPreconditionFailed)
+			case errors.Is(err, storage.ErrURLExists):
+				http.Error(w, "url is already shortened", http.StatusConflict)
+			case errors.Is(err, storage.ErrURLDeleted):
+				http.Error(w, "url is deleted", http.StatusGone)
+			case errors.Is(err, storage.ErrNotOwner):
+				http.Error(w, "forbidden", http.StatusForbidden)
+			case errors.Is(err, storage.ErrURLNotFound):
+				http.Error(w, "url not found", http.StatusNotFound)
+			default:
+				http.Error(w, "can't update url", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeUserURLInfo(w, baseURL, updated)
+	}
+}
+
+// URLHistoryEvent represents change of user's url
+type URLHistoryEvent struct {
+	Action string `json:"action"`
+	// UserID is user performed the action
+	UserID      string    `json:"user_id"`
+	OriginalURL string    `json:"original_url"`
+	PrevURL     string    `json:"prev_url,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// GetUserURLHistoryHandler returns create, update, delete and restore events of user's url
+// in the order they happened (route /api/user/urls/{alias}/history),
+// history of purged url is returned to its last owner
+func GetUserURLHistoryHandler(store storage.URLStorage, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := chi.URLParam(r, "alias")
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		events, err := store.GetURLHistoryCtx(r.Context(), alias)
+		// url may be created before history was recorded
+		if err != nil && !errors.Is(err, storage.ErrURLNotFound) {
+			logger.Log.Error("get url history from storage", zap.Error(err))
+			http.Error(w, "can't get url history", http.StatusInternalServerError)
+			return
+		}
+
+		var owner string
+		uurl, err := store.GetURLCtx(r.Context(), alias)
+		switch {
+		case err == nil:
+			owner = uurl.UserID
+		case errors.Is(err, storage.ErrURLNotFound):
+			// history of purged url is kept, it belongs to user of the last event
+			if len(events) == 0 {
+				http.Error(w, "url not found", http.StatusNotFound)
+				return
+			}
+			owner = events[len(events)-1].UserID
+		default:
+			logger.Log.Error("get url from storage", zap.Error(err))
+			http.Error(w, "can't get url", http.StatusInternalServerError)
+			return
+		}
+
+		if uid == "" || owner != uid {
+			logger.Log.Debug("url belongs to another user", zap.String("alias", alias), zap.String("id", uid))
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		history := make([]URLHistoryEvent, 0, len(events))
+		for _, e := range events {
+			history = append(history, URLHistoryEvent{
+				Action:      e.Action,
+				UserID:      e.UserID,
+				OriginalURL: e.URL,
+				PrevURL:     e.PrevURL,
+				Time:        e.Time,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(history); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// DeleteQueue queues asynchronous deletion of user urls
+type DeleteQueue interface {
+	Push(task models.UserDeleteTask) error
+}
+
+// DeleteUserUrlsHandler deletes user urls (route DELETE /api/user/urls).
+// Deletion is asynchronous, its progress and errors of aliases are returned by /api/user/jobs/{id}.
+//
+// Request
+//
+//	DELETE /api/user/urls HTTP/1.1
+//	Content-Type: application/json
+//
+//	["6qxTVvsy", "RTfd56hn"]
+//
+// Response
+//
+//	HTTP/1.1 202 Accepted
+//	Content-Type: application/json
+//	Location: /api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a
+//
+//	{ "job_id": "5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "status_url": "/api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a" }
+func DeleteUserUrlsHandler(registry *jobs.Registry, token client.AuthToken, queue DeleteQueue) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger.Log.Debug("check Content-Type")
 		if ct := r.Header.Get("Content-Type"); ct != "" {
@@ -96,12 +628,87 @@ func DeleteUserUrlsHandler(store storage.URLStorage, token client.AuthToken, fan
 		// extract user ID from request cookie
 		uid := token.GetUserID(r)
 
+		jobID := registry.Create(uid, jobs.KindDelete, len(aliasToDelete))
+
 		// pass user aliases to delete worker
-		fanInCh <- models.UserDeleteTask{
+		err := queue.Push(models.UserDeleteTask{
 			UID:     uid,
 			Aliases: aliasToDelete,
+			JobID:   jobID,
+		})
+		if err != nil {
+			logger.Log.Error("cannot queue deletion", zap.Error(err))
+			registry.Finish(jobID, err)
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJobAccepted(w, jobID)
+	}
+}
+
+// RestoreQueue queues asynchronous restoring of user urls
+type RestoreQueue interface {
+	Push(task models.UserRestoreTask) error
+}
+
+// RestoreUserUrlsHandler restores deleted user urls (route POST /api/user/urls/restore).
+// Like deletion, restoring is asynchronous, only urls owned by the user are restored.
+//
+// Request
+//
+//	POST /api/user/urls/restore HTTP/1.1
+//	Content-Type: application/json
+//
+//	["6qxTVvsy", "RTfd56hn"]
+//
+// Response
+//
+//	HTTP/1.1 202 Accepted
+//	Content-Type: application/json
+//	Location: /api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a
+//
+//	{ "job_id": "5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "status_url": "/api/user/jobs/5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a" }
+func RestoreUserUrlsHandler(registry *jobs.Registry, token client.AuthToken, queue RestoreQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Log.Debug("check Content-Type")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			if !strings.Contains(st, "application/json") {
+				msg := "Content-Type is not application/json"
+				logger.Log.Debug(msg, zap.String("is", ct))
+				http.Error(w, msg, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		var aliasToRestore []string
+
+		logger.Log.Debug("decode request")
+		if err := json.NewDecoder(r.Body).Decode(&aliasToRestore); err != nil {
+			logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		jobID := registry.Create(uid, jobs.KindRestore, len(aliasToRestore))
+
+		// pass user aliases to restore worker
+		err := queue.Push(models.UserRestoreTask{
+			UID:     uid,
+			Aliases: aliasToRestore,
+			JobID:   jobID,
+		})
+		if err != nil {
+			logger.Log.Error("cannot queue restoring", zap.Error(err))
+			registry.Finish(jobID, err)
+			http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+			return
 		}
 
-		w.WriteHeader(http.StatusAccepted)
+		writeJobAccepted(w, jobID)
 	}
 }
@@ -7,11 +7,16 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/jobs"
 	"github.com/rookgm/shortener/internal/models"
 	"github.com/rookgm/shortener/internal/random"
 	"github.com/rookgm/shortener/internal/storage"
@@ -27,6 +32,8 @@ func TestGetUserUrlsHandler(t *testing.T) {
 		setup          func(t *testing.T) *storage.MockURLStorage
 		wantStatusCode int
 		wantBody       []UserURL
+		wantLink       string
+		wantCursor     string
 	}{
 		{
 			name: "valid_request_return_200",
@@ -36,12 +43,12 @@ func TestGetUserUrlsHandler(t *testing.T) {
 				defer ctrl.Finish()
 
 				storeMock := storage.NewMockURLStorage(ctrl)
-				storeMock.EXPECT().GetUserURLsCtx(gomock.Any(), gomock.Any()).Return([]models.ShrURL{
+				storeMock.EXPECT().ListUserURLsCtx(gomock.Any(), gomock.Any()).Return([]models.ShrURL{
 					{Alias: "5LBgy9",
 						URL:     "http://uv4nq5mt9qkh7z.ru",
 						UserID:  "c81514ed-b47a-4d39-9591-b904db48a07a",
 						Deleted: false},
-				}, nil).AnyTimes()
+				}, "", nil).AnyTimes()
 				return storeMock
 			},
 			wantStatusCode: http.StatusOK,
@@ -52,6 +59,31 @@ func TestGetUserUrlsHandler(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "default_limit_return_next_cursor",
+			setup: func(t *testing.T) *storage.MockURLStorage {
+				ctrl := gomock.NewController(t)
+				defer ctrl.Finish()
+
+				storeMock := storage.NewMockURLStorage(ctrl)
+				storeMock.EXPECT().ListUserURLsCtx(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+						assert.Equal(t, 1000, q.Limit)
+						assert.True(t, q.IncludeDeleted)
+						return []models.ShrURL{{Alias: "5LBgy9", URL: "http://uv4nq5mt9qkh7z.ru"}}, "MTIzOjVMQmd5OQ", nil
+					}).AnyTimes()
+				return storeMock
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody: []UserURL{
+				{
+					ShortURL:    "http://localhost/5LBgy9",
+					OriginalURL: "http://uv4nq5mt9qkh7z.ru",
+				},
+			},
+			wantLink:   `</api/user/urls?cursor=MTIzOjVMQmd5OQ&deleted=true&limit=1000>; rel="next"`,
+			wantCursor: "MTIzOjVMQmd5OQ",
+		},
 		{
 			name: "no_content_return_204",
 			body: "",
@@ -60,7 +92,7 @@ func TestGetUserUrlsHandler(t *testing.T) {
 				defer ctrl.Finish()
 
 				storeMock := storage.NewMockURLStorage(ctrl)
-				storeMock.EXPECT().GetUserURLsCtx(gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+				storeMock.EXPECT().ListUserURLsCtx(gomock.Any(), gomock.Any()).Return(nil, "", nil).AnyTimes()
 				return storeMock
 			},
 			wantStatusCode: http.StatusNoContent,
@@ -84,6 +116,8 @@ func TestGetUserUrlsHandler(t *testing.T) {
 
 			res := w.Result()
 			assert.Equal(t, tt.wantStatusCode, res.StatusCode)
+			assert.Equal(t, tt.wantLink, res.Header.Get("Link"))
+			assert.Equal(t, tt.wantCursor, res.Header.Get("X-Next-Cursor"))
 			defer res.Body.Close()
 			resBody, err := io.ReadAll(res.Body)
 			require.NoError(t, err)
@@ -100,6 +134,590 @@ func TestGetUserUrlsHandler(t *testing.T) {
 	}
 }
 
+func TestGetUserURLStatsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	ownerToken, err := auth.Create()
+	require.NoError(t, err)
+	ownerID, err := auth.Verify(ownerToken)
+	require.NoError(t, err)
+
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	st := storage.NewMemStorage()
+	err = st.StoreURLCtx(context.Background(), models.ShrURL{
+		Alias:  "Vb7nQw1z",
+		URL:    "https://example.com/",
+		UserID: ownerID,
+		Variants: []models.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 1},
+		},
+	})
+	require.NoError(t, err)
+	_, err = st.RegisterClickCtx(context.Background(), "Vb7nQw1z", "a")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		alias          string
+		token          string
+		wantStatusCode int
+		wantBody       URLStats
+	}{
+		{
+			name:           "owner_return_200",
+			alias:          "Vb7nQw1z",
+			token:          ownerToken,
+			wantStatusCode: http.StatusOK,
+			wantBody: URLStats{
+				ShortURL: "http://localhost/Vb7nQw1z",
+				Clicks:   1,
+				Variants: []VariantStats{{Name: "a", URL: "https://example.com/a", Weight: 1, Clicks: 1}},
+			},
+		},
+		{
+			name:           "other_user_return_403",
+			alias:          "Vb7nQw1z",
+			token:          otherToken,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "unknown_alias_return_404",
+			alias:          "unknown",
+			token:          ownerToken,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/user/urls/{alias}/stats", GetUserURLStatsHandler(st, "http://localhost", auth))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls/"+tt.alias+"/stats", nil)
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: tt.token})
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatusCode, res.StatusCode)
+
+			if tt.wantStatusCode != http.StatusOK {
+				return
+			}
+
+			var got URLStats
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+
+			if diff := cmp.Diff(tt.wantBody, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPatchUserURLHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	ownerToken, err := auth.Create()
+	require.NoError(t, err)
+	ownerID, err := auth.Verify(ownerToken)
+	require.NoError(t, err)
+
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	st := storage.NewMemStorage()
+	err = st.StoreURLCtx(context.Background(), models.ShrURL{
+		Alias:  "EwHXdJfB",
+		URL:    "https://practicum.yandex.ru/",
+		UserID: ownerID,
+		UTM:    models.UTM{Source: "flyer", Campaign: "spring"},
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Patch("/api/user/urls/{alias}", PatchUserURLHandler(st, "http://localhost", auth))
+
+	tests := []struct {
+		name           string
+		token          string
+		ifMatch        string
+		body           string
+		wantStatusCode int
+		wantETag       string
+		wantBody       *UserURLInfo
+	}{
+		{
+			name:           "owner_return_200",
+			token:          ownerToken,
+			ifMatch:        `"1"`,
+			body:           `{"url":"https://practicum.yandex.ru/new","utm":{"source":"flyer","campaign":"autumn"}}`,
+			wantStatusCode: http.StatusOK,
+			wantETag:       `"2"`,
+			wantBody: &UserURLInfo{
+				ShortURL:    "http://localhost/EwHXdJfB",
+				OriginalURL: "https://practicum.yandex.ru/new",
+				UTM:         &APIUTM{Source: "flyer", Campaign: "autumn"},
+				Version:     2,
+			},
+		},
+		{
+			name:           "stale_version_return_412",
+			token:          ownerToken,
+			ifMatch:        `"1"`,
+			body:           `{"url":"https://practicum.yandex.ru/stale"}`,
+			wantStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:           "version_in_body_return_200",
+			token:          ownerToken,
+			body:           `{"rules":[{"platform":"ios","url":"https://apps.apple.com/app/id1"}],"version":2}`,
+			wantStatusCode: http.StatusOK,
+			wantETag:       `"3"`,
+			wantBody: &UserURLInfo{
+				ShortURL:    "http://localhost/EwHXdJfB",
+				OriginalURL: "https://practicum.yandex.ru/new",
+				UTM:         &APIUTM{Source: "flyer", Campaign: "autumn"},
+				Rules:       []APIRule{{Platform: "ios", URL: "https://apps.apple.com/app/id1"}},
+				Version:     3,
+			},
+		},
+		{
+			name:           "title_too_long_return_400",
+			token:          ownerToken,
+			ifMatch:        `"3"`,
+			body:           `{"title":"` + strings.Repeat("t", maxTitleLen+1) + `"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "no_version_return_428",
+			token:          ownerToken,
+			body:           `{"url":"https://practicum.yandex.ru/"}`,
+			wantStatusCode: http.StatusPreconditionRequired,
+		},
+		{
+			name:           "other_user_return_403",
+			token:          otherToken,
+			ifMatch:        "*",
+			body:           `{"url":"https://practicum.yandex.ru/"}`,
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/EwHXdJfB", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: tt.token})
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatusCode, res.StatusCode)
+
+			if tt.wantBody == nil {
+				return
+			}
+
+			assert.Equal(t, tt.wantETag, res.Header.Get("ETag"))
+
+			var got UserURLInfo
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+
+			if diff := cmp.Diff(*tt.wantBody, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetUserURLHistoryHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	ownerToken, err := auth.Create()
+	require.NoError(t, err)
+	ownerID, err := auth.Verify(ownerToken)
+	require.NoError(t, err)
+
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	st := storage.NewMemStorage()
+	err = st.StoreURLCtx(context.Background(), models.ShrURL{
+		Alias:  "EwHXdJfB",
+		URL:    "https://practicum.yandex.ru/",
+		UserID: ownerID,
+	})
+	require.NoError(t, err)
+	_, err = st.UpdateURLCtx(context.Background(), models.ShrURL{
+		Alias:  "EwHXdJfB",
+		URL:    "https://practicum.yandex.ru/new",
+		UserID: ownerID,
+	}, 1)
+	require.NoError(t, err)
+
+	// purged url
+	err = st.StoreURLCtx(context.Background(), models.ShrURL{
+		Alias:  "RTfd56hn",
+		URL:    "https://ya.ru/",
+		UserID: ownerID,
+	})
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(context.Background(), ownerID, []string{"RTfd56hn"})
+	require.NoError(t, err)
+	_, err = st.PurgeDeletedURLsCtx(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Get("/api/user/urls/{alias}/history", GetUserURLHistoryHandler(st, auth))
+
+	t.Run("owner_return_200", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/EwHXdJfB/history", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: ownerToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got []URLHistoryEvent
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		require.Len(t, got, 2)
+
+		assert.Equal(t, models.ActionCreate, got[0].Action)
+		assert.Equal(t, ownerID, got[0].UserID)
+		assert.Equal(t, models.ActionUpdate, got[1].Action)
+		assert.Equal(t, "https://practicum.yandex.ru/new", got[1].OriginalURL)
+		assert.Equal(t, "https://practicum.yandex.ru/", got[1].PrevURL)
+	})
+
+	t.Run("other_user_return_403", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/EwHXdJfB/history", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: otherToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("purged_owner_return_200", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/RTfd56hn/history", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: ownerToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var got []URLHistoryEvent
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+		require.Len(t, got, 3)
+		assert.Equal(t, models.ActionPurge, got[2].Action)
+	})
+
+	t.Run("purged_other_user_return_403", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/RTfd56hn/history", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: otherToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	})
+
+	t.Run("unknown_return_404", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls/unknown/history", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: ownerToken})
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestRestoreUserUrlsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	registry := jobs.NewRegistry()
+	restoreCh := make(chanRestoreQueue, 1)
+	handler := RestoreUserUrlsHandler(registry, auth, restoreCh)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", bytes.NewBufferString(`["6qxTVvsy","RTfd56hn"]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	var accepted APIJobAccepted
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&accepted))
+	assert.Equal(t, "/api/user/jobs/"+accepted.JobID, res.Header.Get("Location"))
+
+	job, ok := registry.Get(accepted.JobID)
+	require.True(t, ok)
+	assert.Equal(t, userID, job.UserID)
+	assert.Equal(t, "restore", job.Kind)
+
+	require.Len(t, restoreCh, 1)
+	task := <-restoreCh
+	assert.Equal(t, models.UserRestoreTask{UID: userID, Aliases: []string{"6qxTVvsy", "RTfd56hn"}, JobID: accepted.JobID}, task)
+}
+
+// chanRestoreQueue queues restoring tasks to channel
+type chanRestoreQueue chan models.UserRestoreTask
+
+func (q chanRestoreQueue) Push(task models.UserRestoreTask) error {
+	q <- task
+	return nil
+}
+
+// chanQueue queues deletion tasks to channel
+type chanQueue chan models.UserDeleteTask
+
+func (q chanQueue) Push(task models.UserDeleteTask) error {
+	q <- task
+	return nil
+}
+
+func TestDeleteUserUrlsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	registry := jobs.NewRegistry()
+	fanInCh := make(chanQueue, 1)
+	handler := DeleteUserUrlsHandler(registry, auth, fanInCh)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBufferString(`["6qxTVvsy","RTfd56hn"]`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+	w := httptest.NewRecorder()
+
+	handler(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	var accepted APIJobAccepted
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&accepted))
+	assert.Equal(t, "/api/user/jobs/"+accepted.JobID, res.Header.Get("Location"))
+
+	require.Len(t, fanInCh, 1)
+	task := <-fanInCh
+	assert.Equal(t, models.UserDeleteTask{UID: userID, Aliases: []string{"6qxTVvsy", "RTfd56hn"}, JobID: accepted.JobID}, task)
+
+	job, ok := registry.Get(accepted.JobID)
+	require.True(t, ok)
+	assert.Equal(t, userID, job.UserID)
+	assert.Equal(t, jobs.StatusPending, job.Status)
+	assert.Equal(t, 2, job.Total)
+}
+
+func TestGetUserUrlsHandler_Paged(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	// clicks define order of urls
+	links := []struct {
+		alias  string
+		url    string
+		clicks int
+	}{
+		{"EwHXdJfB", "https://practicum.yandex.ru/", 3},
+		{"6qxTVvsy", "https://yandex.ru/maps", 5},
+		{"RTfd56hn", "https://go.dev/doc", 1},
+		{"4rSPg8ap", "https://yandex.ru/", 4},
+		{"dG56Hqxm", "https://go.dev/", 2},
+	}
+	for _, l := range links {
+		require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: l.alias, URL: l.url, UserID: userID}))
+		for i := 0; i < l.clicks; i++ {
+			_, err := st.RegisterClickCtx(ctx, l.alias, "")
+			require.NoError(t, err)
+		}
+	}
+	_, err = st.DeleteUserURLsCtx(ctx, userID, []string{"RTfd56hn"})
+	require.NoError(t, err)
+
+	handler := GetUserUrlsHandler(st, "http://localhost:8080/", auth)
+
+	get := func(target string) (*http.Response, []UserURL) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+
+		var urls []UserURL
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&urls))
+		}
+		return res, urls
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var got []string
+		target := "/api/user/urls?sort=clicks&limit=2"
+		for pages := 0; target != ""; pages++ {
+			require.Less(t, pages, 3, "too many pages")
+			res, urls := get(target)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			for _, u := range urls {
+				got = append(got, strings.TrimPrefix(u.ShortURL, "http://localhost:8080/"))
+			}
+			target = ""
+			if link := res.Header.Get("Link"); link != "" {
+				require.True(t, strings.HasSuffix(link, `>; rel="next"`), link)
+				target = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+		assert.Equal(t, []string{"6qxTVvsy", "4rSPg8ap", "EwHXdJfB", "dG56Hqxm"}, got)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		res, urls := get("/api/user/urls?domain=GO.dev&deleted=true&sort=clicks")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Link"))
+		assert.Equal(t, []UserURL{
+			{ShortURL: "http://localhost:8080/dG56Hqxm", OriginalURL: "https://go.dev/"},
+			{ShortURL: "http://localhost:8080/RTfd56hn", OriginalURL: "https://go.dev/doc", Deleted: true},
+		}, urls)
+
+		res, urls = get("/api/user/urls?created_after=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, urls)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		// newest urls first, deleted urls are listed too
+		res, urls := get("/api/user/urls")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, res.Header.Get("Link"))
+		var got []string
+		for _, u := range urls {
+			got = append(got, strings.TrimPrefix(u.ShortURL, "http://localhost:8080/"))
+		}
+		assert.Equal(t, []string{"dG56Hqxm", "4rSPg8ap", "RTfd56hn", "6qxTVvsy", "EwHXdJfB"}, got)
+	})
+
+	t.Run("bad_request", func(t *testing.T) {
+		for _, q := range []string{"limit=0", "limit=x", "sort=name", "cursor=!!", "created_before=yesterday", "deleted=maybe"} {
+			res, _ := get("/api/user/urls?" + q)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode, q)
+		}
+	})
+}
+
+func TestSearchUserUrlsHandler(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "EwHXdJfB", URL: "https://practicum.yandex.ru/", UserID: userID}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: userID, Title: "Practice Go"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://yandex.ru/", UserID: userID}))
+
+	handler := SearchUserUrlsHandler(st, "http://localhost:8080/", auth)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []UserURL
+	}{
+		{
+			name:       "ranked",
+			query:      "q=pract",
+			wantStatus: http.StatusOK,
+			want: []UserURL{
+				{ShortURL: "http://localhost:8080/6qxTVvsy", OriginalURL: "https://go.dev/"},
+				{ShortURL: "http://localhost:8080/EwHXdJfB", OriginalURL: "https://practicum.yandex.ru/"},
+			},
+		},
+		{
+			name:       "not_found",
+			query:      "q=moscow",
+			wantStatus: http.StatusOK,
+			want:       []UserURL{},
+		},
+		{
+			name:       "empty_query",
+			query:      "q=",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid_limit",
+			query:      "q=go&limit=-1",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls/search?"+tt.query, nil)
+			req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: userToken})
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var got []UserURL
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&got))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
 func BenchmarkGetUserUrlsHandler(b *testing.B) {
 
 	userID := "c81514ed-b47a-4d39-9591-b904db48a07a"
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/webhook"
+	"go.uber.org/zap"
+)
+
+// APIWebhookReq represents request to register webhook
+type APIWebhookReq struct {
+	URL string `json:"url"`
+	// Events is event types to deliver, empty means all events
+	Events []string `json:"events,omitempty"`
+	// Secret is key of payload signature, it is generated if empty
+	Secret string `json:"secret,omitempty"`
+}
+
+// APIWebhook represents registered webhook
+type APIWebhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+	// Secret is returned only on registration
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIWebhookDelivery represents attempt to deliver event to webhook
+type APIWebhookDelivery struct {
+	ID         string    `json:"id"`
+	EventID    string    `json:"event_id"`
+	Event      string    `json:"event"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Time       time.Time `json:"delivered_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// APIWebhookDeadLetter represents event not delivered to webhook after all attempts
+type APIWebhookDeadLetter struct {
+	EventID     string    `json:"event_id"`
+	Event       string    `json:"event"`
+	Alias       string    `json:"alias"`
+	OriginalURL string    `json:"original_url,omitempty"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	Time        time.Time `json:"failed_at"`
+}
+
+// toAPIWebhook converts hook to response, secret is not included
+func toAPIWebhook(hook webhook.Hook) APIWebhook {
+	return APIWebhook{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Events:    hook.Events,
+		CreatedAt: hook.CreatedAt,
+	}
+}
+
+// validateWebhookReq returns error message if webhook request is invalid
+func validateWebhookReq(req APIWebhookReq) string {
+	u, err := url.ParseRequestURI(req.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "invalid url"
+	}
+	for _, e := range req.Events {
+		if !slices.Contains(webhook.EventTypes, e) {
+			return "unknown event " + e
+		}
+	}
+	return ""
+}
+
+// CreateWebhookHandler registers webhook of user (route POST /api/user/webhooks).
+// Events are posted to url as JSON signed by HMAC-SHA256 of the secret in X-Shortener-Signature header.
+//
+// Request
+//
+//	POST /api/user/webhooks HTTP/1.1
+//	Content-Type: application/json
+//
+//	{ "url": "https://cms.example.com/hooks/links", "events": ["link.created", "link.deleted"] }
+//
+// Response
+//
+//	HTTP/1.1 201 Created
+//	Content-Type: application/json
+//
+//	{ "id": "5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "url": "https://cms.example.com/hooks/links",
+//	  "events": ["link.created", "link.deleted"], "secret": "9c2e...", "created_at": "2024-05-01T10:00:00Z" }
+func CreateWebhookHandler(registry *webhook.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Log.Debug("check Content-Type")
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			st := strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+			if !strings.Contains(st, "application/json") {
+				msg := "Content-Type is not application/json"
+				logger.Log.Debug(msg, zap.String("is", ct))
+				http.Error(w, msg, http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		var req APIWebhookReq
+
+		logger.Log.Debug("decode request")
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Log.Debug("cannot decode JSON body", zap.Error(err))
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if msg := validateWebhookReq(req); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		secret := req.Secret
+		if secret == "" {
+			secret = webhook.NewSecret()
+		}
+
+		hook, err := registry.Add(r.Context(), webhook.Hook{
+			UserID: uid,
+			URL:    req.URL,
+			Secret: secret,
+			Events: req.Events,
+		})
+		if err != nil {
+			logger.Log.Error("store webhook", zap.Error(err))
+			http.Error(w, "can't create webhook", http.StatusInternalServerError)
+			return
+		}
+
+		resp := toAPIWebhook(hook)
+		resp.Secret = hook.Secret
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// GetWebhooksHandler returns webhooks of user (route GET /api/user/webhooks)
+func GetWebhooksHandler(registry *webhook.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		resp := []APIWebhook{}
+		for _, hook := range registry.List(uid) {
+			resp = append(resp, toAPIWebhook(hook))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// DeleteWebhookHandler removes webhook of user with its logs (route DELETE /api/user/webhooks/{id})
+func DeleteWebhookHandler(registry *webhook.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		ok, err := registry.Remove(r.Context(), uid, chi.URLParam(r, "id"))
+		if err != nil {
+			logger.Log.Error("remove webhook from storage", zap.Error(err))
+			http.Error(w, "can't delete webhook", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetWebhookDeliveriesHandler returns delivery attempts of user's webhook,
+// the latest go first (route GET /api/user/webhooks/{id}/deliveries)
+func GetWebhookDeliveriesHandler(registry *webhook.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		hook, ok := registry.Get(uid, chi.URLParam(r, "id"))
+		if !ok {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+
+		resp := []APIWebhookDelivery{}
+		for _, d := range registry.Deliveries(hook.ID) {
+			resp = append(resp, APIWebhookDelivery{
+				ID:         d.ID,
+				EventID:    d.EventID,
+				Event:      d.EventType,
+				Attempt:    d.Attempt,
+				StatusCode: d.StatusCode,
+				Error:      d.Error,
+				Time:       d.Time,
+				DurationMs: d.Duration.Milliseconds(),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
+
+// GetWebhookDeadLettersHandler returns events not delivered to user's webhook,
+// the latest go first (route GET /api/user/webhooks/{id}/dead-letters)
+func GetWebhookDeadLettersHandler(registry *webhook.Registry, token client.AuthToken) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// extract user ID from request cookie
+		uid := token.GetUserID(r)
+
+		hook, ok := registry.Get(uid, chi.URLParam(r, "id"))
+		if !ok {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+
+		letters, err := registry.DeadLetters(r.Context(), hook.ID)
+		if err != nil {
+			logger.Log.Error("get webhook dead letters from storage", zap.Error(err))
+			http.Error(w, "can't get dead letters", http.StatusInternalServerError)
+			return
+		}
+
+		resp := []APIWebhookDeadLetter{}
+		for _, dl := range letters {
+			resp = append(resp, APIWebhookDeadLetter{
+				EventID:     dl.Event.ID,
+				Event:       dl.Event.Type,
+				Alias:       dl.Event.Alias,
+				OriginalURL: dl.Event.URL,
+				Attempts:    dl.Attempts,
+				LastError:   dl.LastError,
+				Time:        dl.Time,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Log.Error("cannot encode JSON body", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/events"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/rookgm/shortener/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	auth := client.NewAuthToken([]byte("secretkey"))
+
+	userToken, err := auth.Create()
+	require.NoError(t, err)
+	userID, err := auth.Verify(userToken)
+	require.NoError(t, err)
+	otherToken, err := auth.Create()
+	require.NoError(t, err)
+
+	// receiver checks signature of payload
+	received := make(chan string, 10)
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhook.HeaderSignature) != webhook.Sign(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r.Header.Get(webhook.HeaderEvent)
+	}))
+	defer receiver.Close()
+
+	registry, err := webhook.NewRegistry(context.Background(), storage.NewMemStorage())
+	require.NoError(t, err)
+	dispatcher := webhook.NewDispatcher(registry, webhook.WithRetries(1, time.Millisecond))
+	bus := events.NewBus()
+	go dispatcher.Consume(bus.Subscribe("webhooks", events.Options{}))
+	st := events.NewStorage(storage.NewMemStorage(), bus)
+
+	router := chi.NewRouter()
+	router.Post("/api/user/webhooks", CreateWebhookHandler(registry, auth))
+	router.Get("/api/user/webhooks", GetWebhooksHandler(registry, auth))
+	router.Delete("/api/user/webhooks/{id}", DeleteWebhookHandler(registry, auth))
+	router.Get("/api/user/webhooks/{id}/deliveries", GetWebhookDeliveriesHandler(registry, auth))
+	router.Get("/api/user/webhooks/{id}/dead-letters", GetWebhookDeadLettersHandler(registry, auth))
+
+	do := func(method string, target string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "auth_shortener", Value: token})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("bad_requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks", `{"url":"ftp://example.com"}`, userToken).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks",
+			`{"url":"https://example.com","events":["link.renamed"]}`, userToken).Code)
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/webhooks", `{`, userToken).Code)
+	})
+
+	w := do(http.MethodPost, "/api/user/webhooks", `{"url":"`+receiver.URL+`","events":["link.created"]}`, userToken)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created APIWebhook
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, []string{webhook.EventCreated}, created.Events)
+	require.NotEmpty(t, created.Secret)
+	secret = created.Secret
+
+	t.Run("list", func(t *testing.T) {
+		w := do(http.MethodGet, "/api/user/webhooks", "", userToken)
+		require.Equal(t, http.StatusOK, w.Code)
+		var hooks []APIWebhook
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&hooks))
+		require.Len(t, hooks, 1)
+		assert.Equal(t, created.ID, hooks[0].ID)
+		// secret is shown only on registration
+		assert.Empty(t, hooks[0].Secret)
+
+		w = do(http.MethodGet, "/api/user/webhooks", "", otherToken)
+		assert.Equal(t, "[]\n", w.Body.String())
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		ctx := context.Background()
+		require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: userID}))
+		// not subscribed event
+		_, err := st.DeleteUserURLsCtx(ctx, userID, []string{"6qxTVvsy"})
+		require.NoError(t, err)
+
+		select {
+		case event := <-received:
+			assert.Equal(t, webhook.EventCreated, event)
+		case <-time.After(time.Second):
+			t.Fatal("webhook is not delivered")
+		}
+
+		var deliveries []APIWebhookDelivery
+		require.Eventually(t, func() bool {
+			w := do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/deliveries", "", userToken)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&deliveries))
+			return len(deliveries) == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, webhook.EventCreated, deliveries[0].Event)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.Empty(t, deliveries[0].Error)
+
+		w := do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/dead-letters", "", userToken)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "[]\n", w.Body.String())
+
+		// logs of other user's webhook are not found
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/deliveries", "", otherToken).Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/webhooks/"+created.ID, "", otherToken).Code)
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/webhooks/"+created.ID, "", userToken).Code)
+		assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/webhooks/"+created.ID+"/deliveries", "", userToken).Code)
+	})
+
+	bus.Close()
+	require.NoError(t, dispatcher.Shutdown(context.Background()))
+}
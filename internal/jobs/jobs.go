@@ -0,0 +1,174 @@
+// Package jobs keeps state of asynchronous user jobs, so clients can poll their progress.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// job statuses
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+	// StatusPartial is status of finished job with failed items
+	StatusPartial = "partial"
+)
+
+// job kinds
+const (
+	KindImport  = "import"
+	KindDelete  = "delete"
+	KindRestore = "restore"
+)
+
+// maxItemErrors is maximum number of item errors kept in job, the rest are only counted
+const maxItemErrors = 1000
+
+// defaultTTL is how long finished jobs are kept
+const defaultTTL = 24 * time.Hour
+
+// ItemError is error of processing one item of job
+type ItemError struct {
+	// Item is number of item in job input, e.g. row of uploaded file
+	Item int
+	// Key identifies the item, e.g. url or alias
+	Key   string
+	Error string
+}
+
+// Job is state of asynchronous job
+type Job struct {
+	ID     string
+	UserID string
+	Kind   string
+	Status string
+	// Total is number of items to process
+	Total     int
+	Processed int
+	Failed    int
+	Errors    []ItemError
+	// Err is error stopped the whole job
+	Err        string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Registry keeps jobs by ID
+type Registry struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	ttl  time.Duration
+}
+
+// NewRegistry creates empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		jobs: make(map[string]*Job),
+		ttl:  defaultTTL,
+	}
+}
+
+// newID returns random job ID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create registers pending job of the user and returns its ID.
+// Jobs finished longer than ttl ago are dropped.
+func (r *Registry) Create(userID string, kind string, total int) string {
+	id := newID()
+	r.Recreate(id, userID, kind, total)
+	return id
+}
+
+// Recreate registers pending job with known ID, e.g. job of task replayed after restart,
+// so clients polling the job find it. Existing job is kept.
+func (r *Registry) Recreate(id string, userID string, kind string, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, j := range r.jobs {
+		if !j.FinishedAt.IsZero() && now.Sub(j.FinishedAt) > r.ttl {
+			delete(r.jobs, id)
+		}
+	}
+	if _, ok := r.jobs[id]; ok {
+		return
+	}
+
+	r.jobs[id] = &Job{
+		ID:        id,
+		UserID:    userID,
+		Kind:      kind,
+		Status:    StatusPending,
+		Total:     total,
+		CreatedAt: now,
+	}
+}
+
+// Get returns copy of job by ID
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	res := *job
+	res.Errors = append([]ItemError(nil), job.Errors...)
+	return res, true
+}
+
+// update calls fn for job if it exists
+func (r *Registry) update(id string, fn func(job *Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job, ok := r.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// Start marks job as running
+func (r *Registry) Start(id string) {
+	r.update(id, func(job *Job) {
+		job.Status = StatusRunning
+	})
+}
+
+// Progress counts processed item, itemErr is nil if the item is processed successfully
+func (r *Registry) Progress(id string, itemErr *ItemError) {
+	r.update(id, func(job *Job) {
+		job.Processed++
+		if itemErr == nil {
+			return
+		}
+		job.Failed++
+		if len(job.Errors) < maxItemErrors {
+			job.Errors = append(job.Errors, *itemErr)
+		}
+	})
+}
+
+// Finish marks job as done, as partially failed if some items failed or as failed with err
+func (r *Registry) Finish(id string, err error) {
+	r.update(id, func(job *Job) {
+		job.Status = StatusDone
+		if job.Failed > 0 {
+			job.Status = StatusPartial
+		}
+		if err != nil {
+			job.Status = StatusFailed
+			job.Err = err.Error()
+		}
+		job.FinishedAt = time.Now()
+	})
+}
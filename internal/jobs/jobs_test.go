@@ -0,0 +1,76 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	id := r.Create("user", "import", 3)
+	job, ok := r.Get(id)
+	require.True(t, ok)
+	assert.Equal(t, StatusPending, job.Status)
+	assert.Equal(t, 3, job.Total)
+
+	r.Start(id)
+	r.Progress(id, nil)
+	r.Progress(id, &ItemError{Item: 2, Key: "http://ya.ru", Error: "url exists"})
+	r.Progress(id, nil)
+	r.Finish(id, nil)
+
+	job, ok = r.Get(id)
+	require.True(t, ok)
+	assert.Equal(t, StatusPartial, job.Status)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 1, job.Failed)
+	assert.Equal(t, []ItemError{{Item: 2, Key: "http://ya.ru", Error: "url exists"}}, job.Errors)
+	assert.False(t, job.FinishedAt.IsZero())
+
+	done := r.Create("user", "delete", 1)
+	r.Start(done)
+	r.Progress(done, nil)
+	r.Finish(done, nil)
+	job, _ = r.Get(done)
+	assert.Equal(t, StatusDone, job.Status)
+
+	failed := r.Create("user", "import", 1)
+	r.Finish(failed, errors.New("storage is closed"))
+	job, _ = r.Get(failed)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Equal(t, "storage is closed", job.Err)
+
+	// finished jobs are dropped after ttl
+	r.ttl = 0
+	time.Sleep(time.Millisecond)
+	r.Create("user", "import", 0)
+	_, ok = r.Get(id)
+	assert.False(t, ok)
+
+	_, ok = r.Get("unknown")
+	assert.False(t, ok)
+}
+
+func TestRegistry_Recreate(t *testing.T) {
+	r := NewRegistry()
+
+	// job of replayed task
+	r.Recreate("5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a", "user", KindDelete, 2)
+	job, ok := r.Get("5b1f0c3e8a0d4c2f9e7a6b5c4d3e2f1a")
+	require.True(t, ok)
+	assert.Equal(t, "user", job.UserID)
+	assert.Equal(t, KindDelete, job.Kind)
+	assert.Equal(t, StatusPending, job.Status)
+	assert.Equal(t, 2, job.Total)
+
+	// existing job is kept
+	r.Start(job.ID)
+	r.Recreate(job.ID, "user", KindDelete, 2)
+	job, _ = r.Get(job.ID)
+	assert.Equal(t, StatusRunning, job.Status)
+}
@@ -53,6 +53,18 @@ func (rw *responseWrite) WriteHeader(statusCode int) {
 	rw.responseData.status = statusCode
 }
 
+// Flush sends buffered data to the client
+func (rw *responseWrite) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns original writer for http.ResponseController
+func (rw *responseWrite) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Middleware is middleware logger
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
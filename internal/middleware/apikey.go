@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/rookgm/shortener/internal/apikey"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// APIKey authenticates request carrying API key in Authorization: Bearer or X-API-Key header.
+// Safe requests need read scope, others need write scope. Requests without key are passed as is,
+// so cookie authentication is performed by Auth.
+func APIKey(registry *apikey.Registry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := apikey.FromRequest(r)
+		if secret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := registry.Verify(secret)
+		if err != nil {
+			logger.Log.Debug("cannot verify API key", zap.Error(err))
+			msg := "invalid API key"
+			if errors.Is(err, apikey.ErrExpired) {
+				msg = "API key is expired"
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+
+		scope := apikey.ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = apikey.ScopeRead
+		}
+		if !key.Allows(scope) {
+			logger.Log.Debug("API key has no scope", zap.String("key", key.ID), zap.String("scope", scope))
+			http.Error(w, "API key has no "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(apikey.NewContext(r.Context(), key)))
+	})
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rookgm/shortener/internal/apikey"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKey(t *testing.T) {
+	ctx := context.Background()
+	registry, err := apikey.NewRegistry(ctx, storage.NewMemStorage())
+	require.NoError(t, err)
+	_, readSecret, err := registry.Create(ctx, apikey.Key{UserID: "user", Scopes: []string{apikey.ScopeRead}})
+	require.NoError(t, err)
+	_, allSecret, err := registry.Create(ctx, apikey.Key{UserID: "user"})
+	require.NoError(t, err)
+	_, expiredSecret, err := registry.Create(ctx, apikey.Key{UserID: "user", ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+
+	token := apikey.WrapAuthToken(client.NewAuthToken([]byte("secretkey")))
+	var gotUserID string
+	handler := APIKey(registry, Auth(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUserID = token.GetUserID(r)
+	})))
+
+	tests := []struct {
+		name       string
+		method     string
+		header     string
+		secret     string
+		statusCode int
+		wantUserID string
+	}{
+		{name: "read", method: http.MethodGet, header: "Authorization", secret: "Bearer " + readSecret, statusCode: http.StatusOK, wantUserID: "user"},
+		{name: "no_write_scope", method: http.MethodDelete, header: "Authorization", secret: "Bearer " + readSecret, statusCode: http.StatusForbidden},
+		{name: "write", method: http.MethodPost, header: apikey.HeaderAPIKey, secret: allSecret, statusCode: http.StatusOK, wantUserID: "user"},
+		{name: "expired", method: http.MethodGet, header: apikey.HeaderAPIKey, secret: expiredSecret, statusCode: http.StatusUnauthorized},
+		{name: "invalid", method: http.MethodGet, header: apikey.HeaderAPIKey, secret: "shr_unknown", statusCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID = ""
+			req := httptest.NewRequest(tt.method, "/api/user/urls", nil)
+			req.Header.Set(tt.header, tt.secret)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.statusCode, res.StatusCode)
+			assert.Equal(t, tt.wantUserID, gotUserID)
+			// cookie is not issued to key clients
+			assert.Empty(t, res.Cookies())
+		})
+	}
+
+	t.Run("cookie", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		res := w.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Len(t, res.Cookies(), 1)
+	})
+}
@@ -2,8 +2,8 @@ package middleware
 
 import (
 	"net/http"
-	"time"
 
+	"github.com/rookgm/shortener/internal/apikey"
 	"github.com/rookgm/shortener/internal/client"
 	"github.com/rookgm/shortener/internal/logger"
 	"go.uber.org/zap"
@@ -14,6 +14,11 @@ const authCookieName = "auth_shortener"
 // Auth performs authorization
 func Auth(authToken client.AuthToken, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// request is authenticated by API key, cookie is not needed
+		if _, ok := apikey.FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
 		logger.Log.Debug("try get auth cookie")
 		cookie, err := r.Cookie(authCookieName)
 		if err != nil {
@@ -33,7 +38,8 @@ func Auth(authToken client.AuthToken, next http.Handler) http.Handler {
 			r.AddCookie(&http.Cookie{Name: authCookieName, Value: token})
 		} else {
 			logger.Log.Debug("cookie exist, verify it")
-			uid, err := authToken.Verify(cookie.Value)
+			// token with expired session is renewed, so user keeps identity
+			renewed, uid, err := authToken.Refresh(cookie.Value)
 			if err != nil {
 				logger.Log.Warn("cannot verify token")
 				// create token
@@ -46,10 +52,11 @@ func Auth(authToken client.AuthToken, next http.Handler) http.Handler {
 				// adds a Set-Cookie header
 				logger.Log.Debug("set cookie with new token")
 				authSetCookie(w, token)
-				// add new token to request cookie for using in handlers
-				logger.Log.Debug("add new token to request cookie")
-				r.AddCookie(&http.Cookie{Name: authCookieName, Value: token})
+				// handlers verify the first auth cookie of request, so stale token is replaced
+				logger.Log.Debug("replace request cookie with new token")
+				replaceAuthCookie(r, token)
 				next.ServeHTTP(w, r)
+				return
 			}
 
 			if uid == "" {
@@ -57,6 +64,12 @@ func Auth(authToken client.AuthToken, next http.Handler) http.Handler {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if renewed != "" {
+				logger.Log.Debug("set cookie with renewed token")
+				authSetCookie(w, renewed)
+				// handlers verify the first auth cookie of request
+				replaceAuthCookie(r, renewed)
+			}
 			logger.Log.Debug("add uid to request cookie")
 			r.AddCookie(&http.Cookie{Name: authCookieName, Value: uid})
 		}
@@ -65,11 +78,17 @@ func Auth(authToken client.AuthToken, next http.Handler) http.Handler {
 }
 
 func authSetCookie(w http.ResponseWriter, tokenString string) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     authCookieName,
-		Value:    tokenString,
-		Path:     "/",
-		Expires:  time.Now().Add(24 * time.Hour),
-		HttpOnly: true,
-	})
+	client.SetAuthCookie(w, tokenString)
+}
+
+// replaceAuthCookie replaces auth cookie of request with token
+func replaceAuthCookie(r *http.Request, tokenString string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	r.AddCookie(&http.Cookie{Name: authCookieName, Value: tokenString})
+	for _, c := range cookies {
+		if c.Name != authCookieName {
+			r.AddCookie(c)
+		}
+	}
 }
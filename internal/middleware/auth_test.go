@@ -1,12 +1,16 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/rookgm/shortener/internal/client"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAuth(t *testing.T) {
@@ -27,11 +31,11 @@ func TestAuth(t *testing.T) {
 			tokenString: tokenStr,
 			statusCode:  http.StatusOK,
 		},
-		// empty token
+		// empty token is replaced with new one
 		{
 			name:        "empty_token",
 			tokenString: "",
-			statusCode:  http.StatusUnauthorized,
+			statusCode:  http.StatusOK,
 		},
 	}
 
@@ -61,3 +65,108 @@ func TestAuth(t *testing.T) {
 	})
 
 }
+
+func TestAuth_Renewal(t *testing.T) {
+	key := []byte("secretkey")
+	token := client.NewAuthToken(key)
+
+	var gotUID string
+	handler := Auth(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUID = token.GetUserID(r)
+	}))
+
+	// token with expired session issued a week ago
+	issued := time.Now().Add(-7 * 24 * time.Hour)
+	expired := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "ce7868a3-0606-4335-b2e9-5b602f9db5a4",
+		"jti":     "session",
+		"iat":     issued.Unix(),
+		"exp":     issued.Add(24 * time.Hour).Unix(),
+		"idexp":   issued.Add(365 * 24 * time.Hour).Unix(),
+	})
+	expiredStr, err := expired.SignedString(key)
+	require.NoError(t, err)
+	freshStr, err := token.Create()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		tokenString string
+		wantRenewed bool
+	}{
+		{
+			name:        "fresh",
+			tokenString: freshStr,
+			wantRenewed: false,
+		},
+		{
+			name:        "expired_session",
+			tokenString: expiredStr,
+			wantRenewed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUID = ""
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: tt.tokenString})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			if !tt.wantRenewed {
+				assert.Empty(t, res.Cookies())
+				assert.NotEmpty(t, gotUID)
+				return
+			}
+			// user keeps identity
+			require.Len(t, res.Cookies(), 1)
+			uid, err := token.Verify(res.Cookies()[0].Value)
+			require.NoError(t, err)
+			assert.Equal(t, "ce7868a3-0606-4335-b2e9-5b602f9db5a4", uid)
+			assert.Equal(t, uid, gotUID)
+			// other cookies of request are kept
+			c, err := req.Cookie("other")
+			require.NoError(t, err)
+			assert.Equal(t, "value", c.Value)
+		})
+	}
+}
+
+func TestAuth_Revoked(t *testing.T) {
+	token := client.NewAuthToken([]byte("secretkey"))
+
+	calls := 0
+	var gotUID string
+	handler := Auth(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotUID = token.GetUserID(r)
+	}))
+
+	revoked, err := token.CreateForUser("ce7868a3-0606-4335-b2e9-5b602f9db5a4")
+	require.NoError(t, err)
+	require.NoError(t, token.Revoke(context.Background(), revoked, false))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: revoked})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	// request is served once with new identity
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, res.Cookies(), 1)
+	uid, err := token.Verify(res.Cookies()[0].Value)
+	require.NoError(t, err)
+	assert.NotEqual(t, "ce7868a3-0606-4335-b2e9-5b602f9db5a4", uid)
+	// handler sees the new identity
+	assert.Equal(t, uid, gotUID)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // validContentTypes is valid content types.
-var validContentTypes = []string{"application/json", "text/html"}
+var validContentTypes = []string{"application/json", "text/html", "application/x-ndjson"}
 
 // ContentTypeChecker is content type checker.
 type ContentTypeChecker struct {
@@ -69,6 +69,23 @@ func (c *compressWriter) WriteHeader(statusCode int) {
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush sends compressed data written so far to the client,
+// so streamed responses are not delayed by compression
+func (c *compressWriter) Flush() {
+	// only gzip
+	if strings.Compare(c.Header().Get("Content-Encoding"), "gzip") == 0 {
+		c.zw.Flush()
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns original writer for http.ResponseController
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
 // Close closes writer
 func (c *compressWriter) Close() error {
 	// only gzip
@@ -73,3 +73,54 @@ func TestGzipMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestGzipMiddleware_Flush(t *testing.T) {
+	line := `{"correlation_id":"1","short_url":"http://localhost:8080/EwHXdJfB"}` + "\n"
+	w := httptest.NewRecorder()
+
+	handler := GzipMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		rw.WriteHeader(http.StatusCreated)
+		_, err := rw.Write([]byte(line))
+		require.NoError(t, err)
+		require.NoError(t, http.NewResponseController(rw).Flush())
+
+		// flushed line is decoded before the response is finished
+		assert.True(t, w.Flushed)
+		zr, err := gzip.NewReader(strings.NewReader(w.Body.String()))
+		require.NoError(t, err)
+		got := make([]byte, len(line))
+		_, err = io.ReadFull(zr, got)
+		require.NoError(t, err)
+		assert.Equal(t, line, string(got))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
+
+func TestGzipMiddleware_EventStream(t *testing.T) {
+	event := "id: 1\nevent: created\ndata: {}\n\n"
+	w := httptest.NewRecorder()
+
+	handler := GzipMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusOK)
+		_, err := rw.Write([]byte(event))
+		require.NoError(t, err)
+		require.NoError(t, http.NewResponseController(rw).Flush())
+
+		// event is sent as is without buffering
+		assert.True(t, w.Flushed)
+		assert.Equal(t, event, w.Body.String())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(w, req)
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+}
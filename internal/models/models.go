@@ -1,15 +1,234 @@
 package models
 
+import (
+	"slices"
+	"time"
+)
+
 // ShrURL contains url alias and URL
 type ShrURL struct {
 	Alias   string
 	URL     string
 	UserID  string
 	Deleted bool
+	// UTM contains utm parameters appended to URL on redirect
+	UTM UTM
+	// Rules contains platform specific redirect targets,
+	// URL is used when no rule matches
+	Rules []RedirectRule
+	// Variants contains weighted destinations of A/B split
+	Variants []Variant
+	// Clicks is number of redirects by the link
+	Clicks int64
+	// Version is incremented on every update of the link
+	Version int64
+	// DeletedAt is time of deletion, deleted links are purged after retention period
+	DeletedAt time.Time
+	// CreatedAt is time of shortening
+	CreatedAt time.Time
+	// Title and Note describe the link for its owner, they are used in search
+	Title string
+	Note  string
+	// Tags group links of the owner, they are sorted and unique
+	Tags []string
+}
+
+// Variant is one of weighted destinations of the link
+type Variant struct {
+	Name   string
+	URL    string
+	Weight int
+	// Clicks is number of redirects to the variant
+	Clicks int64
+}
+
+// RedirectRule contains redirect target for client platform
+type RedirectRule struct {
+	Platform string
+	URL      string
+}
+
+// UTM contains utm parameters of the link
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Content  string
+}
+
+// IsEmpty reports whether no utm parameter is set
+func (u UTM) IsEmpty() bool {
+	return u == UTM{}
+}
+
+// statuses of batch urls
+const (
+	BatchCreated  = "created"
+	BatchExists   = "exists"
+	BatchRejected = "rejected"
+)
+
+// BatchResult is outcome of storing url of batch
+type BatchResult struct {
+	Status string
+	// Alias is alias of created url or of already existing url with the same destination
+	Alias string
+	// Reason explains why url is rejected
+	Reason string
+}
+
+// sort orders of user urls listing, urls are listed in descending order
+const (
+	SortByCreated = "created"
+	SortByClicks  = "clicks"
+)
+
+// UserURLsQuery contains options of user urls listing
+type UserURLsQuery struct {
+	UserID string
+	// SortBy is SortByCreated or SortByClicks
+	SortBy string
+	// Cursor is position of the next page returned by previous listing,
+	// empty cursor is the first page
+	Cursor string
+	Limit  int
+	// Domain filters urls by host of destination
+	Domain string
+	// CreatedAfter and CreatedBefore filter urls by time of shortening, zero time is not applied
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	IncludeDeleted bool
+	// Tag filters urls having the tag
+	Tag string
+}
+
+// TagCount is number of user urls having the tag
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// url history actions
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionTags    = "tags"
+	// ActionTransfer is reassignment of url to another user
+	ActionTransfer = "transfer"
+	// ActionPurge is physical removal of deleted url
+	ActionPurge = "purge"
+)
+
+// Click is counted redirect of link
+type Click struct {
+	// UserID is owner of link
+	UserID string
+	URL    string
+	// Clicks is total number of link clicks including this one
+	Clicks int64
+	// First is set for the first click of link, it is decided by storage atomically with counting
+	First bool
+}
+
+// URLEvent is a change of url in its history
+type URLEvent struct {
+	Alias  string
+	Action string
+	// UserID is user performed the action
+	UserID string
+	// URL is destination after the action
+	URL string
+	// PrevURL is destination before the action
+	PrevURL string
+	Time    time.Time
 }
 
 // UserDeleteTask presents user tasks to be deleted
 type UserDeleteTask struct {
 	UID     string
 	Aliases []string
+	// JobID is ID of job tracking the deletion
+	JobID string
+}
+
+// UserRestoreTask presents user tasks to be restored after deletion
+type UserRestoreTask struct {
+	UID     string
+	Aliases []string
+	// JobID is ID of job tracking the restoring
+	JobID string
+}
+
+// User is registered account, its ID is user ID of account's links
+type User struct {
+	ID    string
+	Login string
+	// PasswordHash is bcrypt hash of password
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// Webhook is endpoint registered by user to receive events of links
+type Webhook struct {
+	ID     string
+	UserID string
+	URL    string
+	// Secret is key of HMAC signature of payload
+	Secret string
+	// Events is event types the webhook is subscribed to, empty means all events
+	Events    []string
+	CreatedAt time.Time
+}
+
+// WebhookDeadLetter is event not delivered to webhook after all attempts
+type WebhookDeadLetter struct {
+	HookID    string
+	EventID   string
+	EventType string
+	Alias     string
+	// URL is destination of link, it is empty for deleted link
+	URL       string
+	EventTime time.Time
+	Attempts  int
+	LastError string
+	Time      time.Time
+}
+
+// APIKey is key authenticating programmatic client as user, the secret itself is not kept
+type APIKey struct {
+	ID     string
+	UserID string
+	Name   string
+	// Prefix is beginning of secret identifying the key for user
+	Prefix string
+	// Hash is SHA-256 of secret
+	Hash string
+	// Scopes is allowed scopes, empty means all scopes
+	Scopes    []string
+	CreatedAt time.Time
+	// ExpiresAt is zero if key does not expire
+	ExpiresAt time.Time
+}
+
+// Allows reports whether the key has scope
+func (k APIKey) Allows(scope string) bool {
+	return len(k.Scopes) == 0 || slices.Contains(k.Scopes, scope)
+}
+
+// Expired reports whether the key is expired at time
+func (k APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// Revocation revokes auth tokens of session or all tokens of user issued before it
+type Revocation struct {
+	// SessionID is revoked session, it is empty if all tokens of user are revoked
+	SessionID string
+	UserID    string
+	// Time is time of revocation, tokens of user issued at or before it are revoked
+	Time time.Time
+	// Until is time revoked tokens expire anyway, revocation is not needed after it
+	Until time.Time
 }
@@ -0,0 +1,402 @@
+// Package oidc implements OpenID Connect authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// default parameters of provider
+const (
+	defaultTimeout = 10 * time.Second
+	// loginTTL is time user has to authenticate at identity provider
+	loginTTL = 10 * time.Minute
+	// leeway is allowed clock skew of identity provider
+	leeway = time.Minute
+)
+
+// oidc errors
+var (
+	// ErrUnknownState is returned if login is not started, expired or already finished
+	ErrUnknownState = errors.New("unknown login state")
+	// ErrInvalidToken is returned if ID token is not valid
+	ErrInvalidToken = errors.New("invalid ID token")
+)
+
+// Config is client registration at identity provider
+type Config struct {
+	// Issuer is URL of identity provider, discovery document is loaded from it
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is callback receiving authorization code
+	RedirectURL string
+	// Scopes are requested in addition to openid scope
+	Scopes []string
+}
+
+// Claims is identity of authenticated user
+type Claims struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+}
+
+// UserID returns shortener user ID of identity, it is the same on every login
+func (c Claims) UserID() string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(c.Issuer+"#"+c.Subject)).String()
+}
+
+// metadata is discovery document of identity provider
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// login is started login waiting for authorization code
+type login struct {
+	nonce     string
+	verifier  string
+	expiresAt time.Time
+}
+
+// Option sets parameter of provider
+type Option func(*Provider)
+
+// WithClient sets HTTP client of requests to identity provider
+func WithClient(client *http.Client) Option {
+	return func(p *Provider) {
+		if client != nil {
+			p.client = client
+		}
+	}
+}
+
+// Provider performs login at identity provider
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	// mu guards metadata and keys loaded on demand
+	mu   sync.Mutex
+	meta *metadata
+	keys map[string]*rsa.PublicKey
+
+	// logins are started logins grouped by state
+	loginsMu sync.Mutex
+	logins   map[string]login
+}
+
+// NewProvider creates provider, discovery document is loaded on the first login
+func NewProvider(cfg Config, opts ...Option) *Provider {
+	p := &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: defaultTimeout},
+		logins: make(map[string]login),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// randomString returns random URL safe string
+func randomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challenge returns S256 PKCE code challenge of verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Start begins login and returns URL of identity provider user is redirected to
+// and state which must be passed to Finish
+func (p *Provider) Start(ctx context.Context) (string, string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := randomString()
+	l := login{
+		nonce:     randomString(),
+		verifier:  randomString(),
+		expiresAt: time.Now().Add(loginTTL),
+	}
+
+	p.loginsMu.Lock()
+	now := time.Now()
+	for s, old := range p.logins {
+		if now.After(old.expiresAt) {
+			delete(p.logins, s)
+		}
+	}
+	p.logins[state] = l
+	p.loginsMu.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", l.nonce)
+	q.Set("code_challenge", challenge(l.verifier))
+	q.Set("code_challenge_method", "S256")
+
+	authURL := meta.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + q.Encode()
+	} else {
+		authURL += "?" + q.Encode()
+	}
+	return authURL, state, nil
+}
+
+// Finish exchanges authorization code of started login for ID token and returns its claims
+func (p *Provider) Finish(ctx context.Context, state string, code string) (Claims, error) {
+	p.loginsMu.Lock()
+	l, ok := p.logins[state]
+	// state is used once
+	delete(p.logins, state)
+	p.loginsMu.Unlock()
+
+	if !ok || time.Now().After(l.expiresAt) {
+		return Claims{}, ErrUnknownState
+	}
+
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	rawIDToken, err := p.exchange(ctx, meta, code, l.verifier)
+	if err != nil {
+		return Claims{}, err
+	}
+	return p.verify(ctx, meta, rawIDToken, l.nonce)
+}
+
+// discover loads discovery document of identity provider, it is cached after success
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var meta metadata
+	if err := p.getJSON(ctx, wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("cannot load discovery document: %w", err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("issuer %q does not match configured %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// getJSON decodes response of GET request
+func (p *Provider) getJSON(ctx context.Context, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// tokenResponse is response of token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchange redeems authorization code at token endpoint and returns ID token
+func (p *Provider) exchange(ctx context.Context, meta *metadata, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("cannot decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.Error != "" {
+		return "", fmt.Errorf("token request failed: status %d %s %s", resp.StatusCode, tr.Error, tr.ErrorDescription)
+	}
+	if tr.IDToken == "" {
+		return "", errors.New("token response has no ID token")
+	}
+	return tr.IDToken, nil
+}
+
+// idClaims are claims of ID token
+type idClaims struct {
+	jwt.RegisteredClaims
+	Nonce string `json:"nonce"`
+	// AuthorizedParty is client the token is issued to
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	Name            string `json:"name"`
+}
+
+// verify validates signature and claims of ID token
+func (p *Provider) verify(ctx context.Context, meta *metadata, rawIDToken string, nonce string) (Claims, error) {
+	var claims idClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return Claims{}, fmt.Errorf("%w: token is issued to %q", ErrInvalidToken, claims.AuthorizedParty)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	return Claims{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+		Name:    claims.Name,
+	}, nil
+}
+
+// jwk is public key of JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// key returns signing key by ID, key set is reloaded if key is unknown,
+// so keys rotated by identity provider are found
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("cannot load key set: %w", err)
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := parseRSAKey(k)
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookupKey returns cached key by ID, the only key is used if token has no key ID
+func (p *Provider) lookupKey(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// parseRSAKey converts JWK to RSA public key
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rookgm/shortener/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/api/user/oidc/callback"
+
+// authorize follows authorization URL and returns state and code of redirect to client
+func authorize(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, redirectURL, location.Scheme+"://"+location.Host+location.Path)
+	return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestProvider(t *testing.T) {
+	idp := oidctest.NewIdP("shortener", "client secret")
+	defer idp.Close()
+
+	p := NewProvider(Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "shortener",
+		ClientSecret: "client secret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email"},
+	})
+	ctx := context.Background()
+
+	login := func(t *testing.T) (Claims, error) {
+		authURL, state, err := p.Start(ctx)
+		require.NoError(t, err)
+		gotState, code := authorize(t, authURL)
+		require.Equal(t, state, gotState)
+		return p.Finish(ctx, state, code)
+	}
+
+	claims, err := login(t)
+	require.NoError(t, err)
+	assert.Equal(t, idp.Issuer(), claims.Issuer)
+	assert.Equal(t, idp.Subject, claims.Subject)
+	assert.Equal(t, idp.Email, claims.Email)
+
+	t.Run("stable_user_id", func(t *testing.T) {
+		again, err := login(t)
+		require.NoError(t, err)
+		assert.Equal(t, claims.UserID(), again.UserID())
+		assert.NotEqual(t, claims.UserID(), Claims{Issuer: "https://other.example.com", Subject: claims.Subject}.UserID())
+	})
+
+	t.Run("state_used_once", func(t *testing.T) {
+		authURL, state, err := p.Start(ctx)
+		require.NoError(t, err)
+		_, code := authorize(t, authURL)
+		_, err = p.Finish(ctx, state, code)
+		require.NoError(t, err)
+		_, err = p.Finish(ctx, state, code)
+		assert.ErrorIs(t, err, ErrUnknownState)
+	})
+
+	t.Run("key_rotation", func(t *testing.T) {
+		idp.RotateKey()
+		_, err := login(t)
+		assert.NoError(t, err)
+	})
+
+	tests := []struct {
+		name   string
+		modify func(claims jwt.MapClaims)
+	}{
+		{name: "audience", modify: func(c jwt.MapClaims) { c["aud"] = "other" }},
+		{name: "authorized_party", modify: func(c jwt.MapClaims) { c["aud"] = []string{"shortener", "other"}; c["azp"] = "other" }},
+		{name: "issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "nonce", modify: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no_expiration", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "no_subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			idp.Modify = test.modify
+			defer func() { idp.Modify = nil }()
+
+			_, err := login(t)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestProvider_Errors(t *testing.T) {
+	idp := oidctest.NewIdP("shortener", "client secret")
+	defer idp.Close()
+	ctx := context.Background()
+
+	t.Run("issuer_mismatch", func(t *testing.T) {
+		p := NewProvider(Config{Issuer: idp.Issuer() + "/", ClientID: "shortener", RedirectURL: redirectURL})
+		_, _, err := p.Start(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("client_secret", func(t *testing.T) {
+		p := NewProvider(Config{Issuer: idp.Issuer(), ClientID: "shortener", ClientSecret: "wrong", RedirectURL: redirectURL})
+		authURL, state, err := p.Start(ctx)
+		require.NoError(t, err)
+		_, code := authorize(t, authURL)
+		_, err = p.Finish(ctx, state, code)
+		assert.ErrorContains(t, err, "invalid_client")
+	})
+
+	t.Run("unknown_state", func(t *testing.T) {
+		p := NewProvider(Config{Issuer: idp.Issuer(), ClientID: "shortener", RedirectURL: redirectURL})
+		_, err := p.Finish(ctx, "state", "code")
+		assert.ErrorIs(t, err, ErrUnknownState)
+	})
+}
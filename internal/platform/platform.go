@@ -0,0 +1,54 @@
+// Package platform detects client platform by User-Agent header.
+package platform
+
+import "strings"
+
+// supported platforms
+const (
+	// IOS is iPhone, iPad and iPod devices
+	IOS = "ios"
+	// Android is android devices
+	Android = "android"
+	// Windows is windows desktops
+	Windows = "windows"
+	// MacOS is apple desktops
+	MacOS = "macos"
+	// Linux is linux desktops
+	Linux = "linux"
+)
+
+// platform markers in User-Agent, order is important:
+// iOS agents contain "Mac OS X" and android agents contain "Linux"
+var markers = []struct {
+	platform string
+	tokens   []string
+}{
+	{IOS, []string{"iphone", "ipad", "ipod"}},
+	{Android, []string{"android"}},
+	{Windows, []string{"windows"}},
+	{MacOS, []string{"macintosh", "mac os x"}},
+	{Linux, []string{"linux", "x11"}},
+}
+
+// Detect returns platform of User-Agent, if platform is unknown returns empty string
+func Detect(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, m := range markers {
+		for _, token := range m.tokens {
+			if strings.Contains(ua, token) {
+				return m.platform
+			}
+		}
+	}
+	return ""
+}
+
+// IsKnown reports whether platform is supported
+func IsKnown(platform string) bool {
+	for _, m := range markers {
+		if m.platform == platform {
+			return true
+		}
+	}
+	return false
+}
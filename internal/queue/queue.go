@@ -0,0 +1,224 @@
+// Package queue implements durable queue of tasks kept in write-ahead log file.
+//
+// Every pushed task and every acknowledgement is appended to the file as JSON line,
+// tasks not acknowledged before the process is stopped are returned by Pending after Open.
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// ErrClosed is an error when queue is used after Close
+var ErrClosed = errors.New("queue is closed")
+
+// record is line of log, record without item acknowledges item with the sequence number
+type record[T any] struct {
+	Seq  uint64 `json:"seq"`
+	Item *T     `json:"item,omitempty"`
+}
+
+// Entry is pending item with its sequence number
+type Entry[T any] struct {
+	Seq  uint64
+	Item T
+}
+
+// Queue is durable queue of items of type T
+type Queue[T any] struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	seq     uint64
+	pending map[uint64]T
+}
+
+// Open opens queue log, creating it if it does not exist.
+// Pending items are replayed from the log and the log is compacted to them.
+func Open[T any](path string) (*Queue[T], error) {
+	q := &Queue[T]{
+		path:    path,
+		pending: make(map[uint64]T),
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// replay reads log, incomplete last line written by crashed process is skipped.
+// Corrupted lines are logged and skipped, they are dropped by compaction.
+func (q *Queue[T]) replay() error {
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec record[T]
+		if err := json.Unmarshal(line, &rec); err != nil {
+			logger.Log.Warn("skip corrupted queue record", zap.String("path", q.path), zap.Int("line", n), zap.Error(err))
+			continue
+		}
+		q.seq = max(q.seq, rec.Seq)
+		if rec.Item == nil {
+			delete(q.pending, rec.Seq)
+			continue
+		}
+		q.pending[rec.Seq] = *rec.Item
+	}
+}
+
+// compact rewrites log with pending items only and opens it for appending
+func (q *Queue[T]) compact() error {
+	tmp := q.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, e := range q.entries() {
+		if err := encoder.Encode(record[T]{Seq: e.Seq, Item: &e.Item}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+
+	q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0666)
+	return err
+}
+
+// entries returns pending items ordered by sequence number
+func (q *Queue[T]) entries() []Entry[T] {
+	entries := make([]Entry[T], 0, len(q.pending))
+	for seq, item := range q.pending {
+		entries = append(entries, Entry[T]{Seq: seq, Item: item})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries
+}
+
+// write appends record to log
+func (q *Queue[T]) write(rec record[T]) error {
+	if q.file == nil {
+		return ErrClosed
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = q.file.Write(append(b, '\n'))
+	return err
+}
+
+// Pending returns items not acknowledged yet in order of pushing
+func (q *Queue[T]) Pending() []Entry[T] {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.entries()
+}
+
+// Push appends item to log and returns its sequence number.
+// The log is synced to disk, so the item is not lost once Push returns.
+func (q *Queue[T]) Push(item T) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	seq := q.seq + 1
+	if err := q.write(record[T]{Seq: seq, Item: &item}); err != nil {
+		return 0, err
+	}
+	if err := q.file.Sync(); err != nil {
+		return 0, err
+	}
+	q.seq = seq
+	q.pending[seq] = item
+	return seq, nil
+}
+
+// Ack acknowledges processed items. Acknowledgement is not synced,
+// if it is lost the item is processed again, so processing must be idempotent.
+// Log is truncated when there are no pending items.
+func (q *Queue[T]) Ack(seqs ...uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return ErrClosed
+	}
+	for _, seq := range seqs {
+		if _, ok := q.pending[seq]; !ok {
+			continue
+		}
+		if err := q.write(record[T]{Seq: seq}); err != nil {
+			return err
+		}
+		delete(q.pending, seq)
+	}
+
+	if len(q.pending) == 0 {
+		return q.file.Truncate(0)
+	}
+	return nil
+}
+
+// Close closes log file
+func (q *Queue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type task struct {
+	UID     string
+	Aliases []string
+}
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	q, err := Open[task](path)
+	require.NoError(t, err)
+	assert.Empty(t, q.Pending())
+
+	first, err := q.Push(task{UID: "user", Aliases: []string{"6qxTVvsy"}})
+	require.NoError(t, err)
+	second, err := q.Push(task{UID: "other", Aliases: []string{"RTfd56hn", "EwHXdJfB"}})
+	require.NoError(t, err)
+	require.NoError(t, q.Ack(first))
+	require.NoError(t, q.Close())
+
+	// not acknowledged task is replayed
+	q, err = Open[task](path)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry[task]{
+		{Seq: second, Item: task{UID: "other", Aliases: []string{"RTfd56hn", "EwHXdJfB"}}},
+	}, q.Pending())
+
+	// sequence continues after replay
+	third, err := q.Push(task{UID: "user", Aliases: []string{"dG56Hqxm"}})
+	require.NoError(t, err)
+	assert.Greater(t, third, second)
+
+	// log is truncated when everything is acknowledged
+	require.NoError(t, q.Ack(second, third))
+	require.NoError(t, q.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	q, err = Open[task](path)
+	require.NoError(t, err)
+	assert.Empty(t, q.Pending())
+	require.NoError(t, q.Close())
+
+	_, err = q.Push(task{UID: "user"})
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestQueue_IncompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	// last record is cut by crash
+	log := `{"seq":1,"item":{"UID":"user","Aliases":["6qxTVvsy"]}}` + "\n" +
+		`{"seq":2,"item":{"UID":"user","Ali`
+	require.NoError(t, os.WriteFile(path, []byte(log), 0666))
+
+	q, err := Open[task](path)
+	require.NoError(t, err)
+	defer q.Close()
+	assert.Equal(t, []Entry[task]{{Seq: 1, Item: task{UID: "user", Aliases: []string{"6qxTVvsy"}}}}, q.Pending())
+
+	// broken record is dropped by compaction
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `{"seq":1,"item":{"UID":"user","Aliases":["6qxTVvsy"]}}`+"\n", string(b))
+}
+
+func TestQueue_CorruptedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	// complete line is corrupted in the middle of log
+	log := `{"seq":1,"item":{"UID":"user","Aliases":["6qxTVvsy"]}}` + "\n" +
+		`{"seq":2,"item":{"UID":` + "\x00\x00" + `}}` + "\n" +
+		`{"seq":3,"item":{"UID":"other","Aliases":["RTfd56hn"]}}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(log), 0666))
+
+	q, err := Open[task](path)
+	require.NoError(t, err)
+	defer q.Close()
+	assert.Equal(t, []Entry[task]{
+		{Seq: 1, Item: task{UID: "user", Aliases: []string{"6qxTVvsy"}}},
+		{Seq: 3, Item: task{UID: "other", Aliases: []string{"RTfd56hn"}}},
+	}, q.Pending())
+
+	// sequence continues after valid records
+	seq, err := q.Push(task{UID: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), seq)
+}
@@ -4,13 +4,122 @@ import (
 	"bufio"
 	"encoding/json"
 	"io"
+	"time"
 )
 
-// Record is record entity
+// Record is record entity.
+// Every change of url is written as a new record, so the last record of alias is actual state of url.
 type Record struct {
-	UUID        string `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	UUID        string     `json:"uuid"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id,omitempty"`
+	UTM         *UTM       `json:"utm,omitempty"`
+	Rules       []Rule     `json:"rules,omitempty"`
+	Variants    []Variant  `json:"variants,omitempty"`
+	Version     int64      `json:"version,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Note        string     `json:"note,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	// Action is change of url written the record
+	Action string `json:"action,omitempty"`
+	// Actor is user performed the action
+	Actor string    `json:"actor,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// UserRecord is record of registered account
+type UserRecord struct {
+	ID           string    `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// WebhookRecord is record of webhook with events not delivered to it
+type WebhookRecord struct {
+	ID          string             `json:"id"`
+	UserID      string             `json:"user_id"`
+	URL         string             `json:"url"`
+	Secret      string             `json:"secret"`
+	Events      []string           `json:"events,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	DeadLetters []DeadLetterRecord `json:"dead_letters,omitempty"`
+}
+
+// DeadLetterRecord is event not delivered to webhook
+type DeadLetterRecord struct {
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type"`
+	Alias     string    `json:"alias"`
+	URL       string    `json:"url,omitempty"`
+	EventTime time.Time `json:"event_time"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	Time      time.Time `json:"time"`
+}
+
+// APIKeyRecord is record of API key, only hash of its secret is written
+type APIKeyRecord struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name,omitempty"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// RevocationRecord is record of revoked session or user, the last record of them is actual
+type RevocationRecord struct {
+	SessionID string    `json:"session_id,omitempty"`
+	UserID    string    `json:"user_id"`
+	Time      time.Time `json:"time"`
+	Until     time.Time `json:"until"`
+}
+
+// ClickRecord is number of clicks added to link, clicks of all records of alias are summed up.
+// Click of variant is counted for the variant and for the link.
+type ClickRecord struct {
+	ShortURL string `json:"short_url"`
+	Variant  string `json:"variant,omitempty"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// Variant is A/B split destination of record
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Rule is platform redirect rule of record
+type Rule struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+}
+
+// UTM is utm parameters of record
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// maxRecordLen is maximum length of record line,
+// records keep notes of links and dead letters of webhooks, so they may be longer than default limit of scanner
+const maxRecordLen = 4 * 1024 * 1024
+
+// newScanner returns scanner of record lines
+func newScanner(reader io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, maxRecordLen)
+	return scanner
 }
 
 // Recorder is recorder
@@ -27,24 +136,190 @@ func (r *Recorder) WriteRecord(writer io.Writer, rec *Record) error {
 	return encoder.Encode(&rec)
 }
 
-// ReadAllRecords reading all records
-func (r *Recorder) ReadAllRecords(reader io.Reader) (map[string]string, error) {
+// ReadAllRecords reading all records in the order they were written
+func (r *Recorder) ReadAllRecords(reader io.Reader) ([]Record, error) {
 
-	m := make(map[string]string)
+	var recs []Record
 
-	scanner := bufio.NewScanner(reader)
+	scanner := newScanner(reader)
 	for scanner.Scan() {
 		rec := Record{}
 		err := json.Unmarshal(scanner.Bytes(), &rec)
 		if err != nil {
 			return nil, err
 		}
-		m[rec.ShortURL] = rec.OriginalURL
+		recs = append(recs, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// WriteAllRecords writes records
+func (r *Recorder) WriteAllRecords(writer io.Writer, recs []Record) error {
+	w := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(w)
+	for i := range recs {
+		if err := encoder.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// WriteUserRecord writes account record
+func (r *Recorder) WriteUserRecord(writer io.Writer, rec *UserRecord) error {
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(rec)
+}
+
+// ReadAllUserRecords reading all account records in the order they were written
+func (r *Recorder) ReadAllUserRecords(reader io.Reader) ([]UserRecord, error) {
+	var recs []UserRecord
+
+	scanner := newScanner(reader)
+	for scanner.Scan() {
+		rec := UserRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// WriteClickRecord writes click record
+func (r *Recorder) WriteClickRecord(writer io.Writer, rec *ClickRecord) error {
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(rec)
+}
+
+// ReadAllClickRecords reading all click records in the order they were written
+func (r *Recorder) ReadAllClickRecords(reader io.Reader) ([]ClickRecord, error) {
+	var recs []ClickRecord
+
+	scanner := newScanner(reader)
+	for scanner.Scan() {
+		rec := ClickRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// WriteAllWebhookRecords writes webhook records
+func (r *Recorder) WriteAllWebhookRecords(writer io.Writer, recs []WebhookRecord) error {
+	w := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(w)
+	for i := range recs {
+		if err := encoder.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// ReadAllWebhookRecords reading all webhook records in the order they were written
+func (r *Recorder) ReadAllWebhookRecords(reader io.Reader) ([]WebhookRecord, error) {
+	var recs []WebhookRecord
+
+	scanner := newScanner(reader)
+	for scanner.Scan() {
+		rec := WebhookRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// WriteAllAPIKeyRecords writes API key records
+func (r *Recorder) WriteAllAPIKeyRecords(writer io.Writer, recs []APIKeyRecord) error {
+	w := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(w)
+	for i := range recs {
+		if err := encoder.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// ReadAllAPIKeyRecords reading all API key records in the order they were written
+func (r *Recorder) ReadAllAPIKeyRecords(reader io.Reader) ([]APIKeyRecord, error) {
+	var recs []APIKeyRecord
+
+	scanner := newScanner(reader)
+	for scanner.Scan() {
+		rec := APIKeyRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return recs, nil
+}
+
+// WriteRevocationRecord writes revocation record
+func (r *Recorder) WriteRevocationRecord(writer io.Writer, rec *RevocationRecord) error {
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(rec)
+}
+
+// WriteAllRevocationRecords writes revocation records
+func (r *Recorder) WriteAllRevocationRecords(writer io.Writer, recs []RevocationRecord) error {
+	w := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(w)
+	for i := range recs {
+		if err := encoder.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// ReadAllRevocationRecords reading all revocation records in the order they were written
+func (r *Recorder) ReadAllRevocationRecords(reader io.Reader) ([]RevocationRecord, error) {
+	var recs []RevocationRecord
+
+	scanner := newScanner(reader)
+	for scanner.Scan() {
+		rec := RevocationRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
 
-	return m, nil
+	return recs, nil
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rookgm/shortener/config"
+	"github.com/rookgm/shortener/internal/client"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// generatedAuthKeyLen is length of key generated if no key is configured
+const generatedAuthKeyLen = 32
+
+// loadSigningKeys returns keys signing auth tokens, key file takes precedence over single key
+func loadSigningKeys(config *config.Config) ([]client.SigningKey, error) {
+	if config.AuthKeyFile != "" {
+		return client.LoadKeyFile(config.AuthKeyFile)
+	}
+
+	if config.AuthKey == "" {
+		// key is kept in memory only, so it is never shared with other processes
+		key := make([]byte, generatedAuthKeyLen)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		logger.Log.Warn("auth key is not configured, random key is generated, sessions will not survive restart")
+		return []client.SigningKey{client.NewSigningKey(key)}, nil
+	}
+	key, err := hex.DecodeString(config.AuthKey)
+	if err != nil {
+		return nil, err
+	}
+	return []client.SigningKey{client.NewSigningKey(key)}, nil
+}
+
+// runKeyReloader reloads key file on SIGHUP, so keys are rotated without restart
+func runKeyReloader(ctx context.Context, keys *client.KeySet, name string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Debug("worker is stopped", zap.String("worker", "key reloader"))
+			return
+		case <-hup:
+			loaded, err := client.LoadKeyFile(name)
+			if err == nil {
+				err = keys.Replace(loaded)
+			}
+			if err != nil {
+				// current keys are kept
+				logger.Log.Error("can't reload signing keys", zap.String("file", name), zap.Error(err))
+				continue
+			}
+			logger.Log.Info("signing keys are reloaded", zap.Int("count", len(loaded)))
+		}
+	}
+}
@@ -2,29 +2,34 @@ package server
 
 import (
 	"context"
-	"encoding/hex"
 	"errors"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rookgm/shortener/config"
+	"github.com/rookgm/shortener/internal/apikey"
 	"github.com/rookgm/shortener/internal/client"
 	"github.com/rookgm/shortener/internal/db"
+	"github.com/rookgm/shortener/internal/events"
 	"github.com/rookgm/shortener/internal/handlers"
+	"github.com/rookgm/shortener/internal/jobs"
 	"github.com/rookgm/shortener/internal/logger"
 	"github.com/rookgm/shortener/internal/middleware"
 	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/oidc"
+	"github.com/rookgm/shortener/internal/queue"
 	"github.com/rookgm/shortener/internal/storage"
+	"github.com/rookgm/shortener/internal/webhook"
+	"github.com/rookgm/shortener/internal/worker"
 	"go.uber.org/zap"
 )
 
-const authTokenKey = "f53ac685bbceebd75043e6be2e06ee07"
-
 const (
 	serverCertFileName = "cert/server.crt"
 	serverKeyFileName  = "cert/server.key"
@@ -54,6 +59,10 @@ func Run(config *config.Config) error {
 
 	var sdb *db.DataBase
 	var st storage.URLStorage
+	var users storage.UserStorage
+	var hooks storage.WebhookStorage
+	var keys storage.APIKeyStorage
+	var revocations storage.RevocationStorage
 	var err error
 
 	// detect type of storage
@@ -65,80 +74,136 @@ func Run(config *config.Config) error {
 		}
 		defer sdb.Close()
 		// create db storage
-		st, err = storage.NewDBStorage(sdb)
+		dbst, err := storage.NewDBStorage(sdb)
 		if err != nil {
 			return err
 		}
+		st, users, hooks, keys, revocations = dbst, dbst, dbst, dbst, dbst
 	} else if config.StoragePath != "" {
 		// create file storage
-		st = storage.NewFileStorage(config.StoragePath)
+		fst := storage.NewFileStorage(config.StoragePath)
+		defer func() {
+			if err := fst.Close(); err != nil {
+				logger.Log.Error("Error closing storage files", zap.Error(err))
+			}
+		}()
+		st, users, hooks, keys, revocations = fst, fst, fst, fst, fst
 		// load storage from file
 		if err := st.LoadFromFile(); err != nil {
 			return err
 		}
 	} else {
 		// create storage on memory
-		st = storage.NewMemStorage()
+		mst := storage.NewMemStorage()
+		st, users, hooks, keys, revocations = mst, mst, mst, mst, mst
 	}
 
-	key, err := hex.DecodeString(authTokenKey)
+	// keys signing auth tokens, they are rotated by schedule of key file
+	signingKeys, err := loadSigningKeys(config)
+	if err != nil {
+		logger.Log.Error("can not load signing keys", zap.Error(err))
+		return err
+	}
+	keySet, err := client.NewKeySet(signingKeys, config.AuthKeyGrace)
 	if err != nil {
-		logger.Log.Error("can not extract key")
+		logger.Log.Error("invalid signing keys", zap.Error(err))
 		return err
 	}
+	if config.AuthKeyFile != "" {
+		go runKeyReloader(ctx, keySet, config.AuthKeyFile)
+	}
 
-	// faInCh channel accepts data for batch alias deletion
-	fanInCh := make(chan models.UserDeleteTask, 1000)
+	// streamsDone closes event streams on shutdown, server does not wait for them
+	streamsDone := make(chan struct{})
 
-	// run delete worker
-	var batch []models.UserDeleteTask
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+	// registry of asynchronous user jobs
+	jobRegistry := jobs.NewRegistry()
 
+	// storage publishes link changes to event bus,
+	// the last events are kept to resume event streams
+	bus := events.NewBus(events.WithHistory(1000))
+	st = events.NewStorage(st, bus)
+
+	// link changes are delivered to webhooks of their users
+	webhookRegistry, err := webhook.NewRegistry(ctx, hooks)
+	if err != nil {
+		logger.Log.Error("can not load webhooks", zap.Error(err))
+		return err
+	}
+	dispatcher := webhook.NewDispatcher(webhookRegistry)
+	// slow webhooks must not block publishing of link changes,
+	// events not fitting in delivery queue are moved to dead letters
+	webhookSub := bus.Subscribe("webhooks", events.Options{Buffer: 1000, Policy: events.DropOldest})
+	webhookDone := make(chan struct{})
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				if len(batch) > 0 {
-					for _, b := range batch {
-						logger.Log.Debug("delete user urls", zap.String("uid", b.UID))
-						if err := st.DeleteUserURLsCtx(ctx, b.UID, b.Aliases); err != nil {
-							logger.Log.Error("can't delete user urls", zap.String("uid", b.UID), zap.Error(err))
-						}
-					}
-				}
-				logger.Log.Debug("delete worker is stopped")
-				return
-			case toDelete, ok := <-fanInCh:
-				if !ok {
-					if len(batch) > 0 {
-						if err := st.DeleteUserURLsCtx(ctx, toDelete.UID, toDelete.Aliases); err != nil {
-							logger.Log.Error("can't delete user urls", zap.String("uid", toDelete.UID), zap.Error(err))
-						}
-					}
-				}
-				logger.Log.Debug("got delete task in fanInCh", zap.Any("task", toDelete))
-				batch = append(batch, toDelete)
-			case <-ticker.C:
-				logger.Log.Debug("ticker tick")
-				logger.Log.Debug("starting delete user urls...")
-				for _, b := range batch {
-					logger.Log.Debug("delete user urls", zap.String("uid", b.UID))
-					if err := st.DeleteUserURLsCtx(ctx, b.UID, b.Aliases); err != nil {
-						logger.Log.Error("can't delete user urls", zap.String("uid", b.UID), zap.Error(err))
-					}
-				}
-				batch = nil
-			}
-			logger.Log.Debug("finished delete user urls")
-		}
+		defer close(webhookDone)
+		dispatcher.Consume(webhookSub)
 	}()
 
-	token := client.NewAuthToken(key)
+	// accepted deletions are persisted to survive restart
+	var deleterOpts []worker.Option
+	if config.DeleteQueuePath != "" {
+		deleteQueue, err := queue.Open[models.UserDeleteTask](config.DeleteQueuePath)
+		if err != nil {
+			logger.Log.Error("can not open delete queue", zap.Error(err))
+			return err
+		}
+		defer deleteQueue.Close()
+		deleterOpts = append(deleterOpts, worker.WithQueue(deleteQueue))
+	}
+
+	// run delete worker, it is drained on shutdown
+	deleter := worker.NewDeleter(st, jobRegistry, deleterOpts...)
+	go deleter.Run()
+
+	// run restore worker, it is drained on shutdown
+	restorer := worker.NewRestorer(st, jobRegistry)
+	go restorer.Run()
+
+	// imports are waited for on shutdown
+	importer := worker.NewGroup()
+
+	// run purge of deleted urls
+	if config.DeletedRetention > 0 {
+		go runPurgeWorker(ctx, st, config.DeletedRetention)
+	}
+
+	// API keys authenticate programmatic clients as their users
+	keyRegistry, err := apikey.NewRegistry(ctx, keys)
+	if err != nil {
+		logger.Log.Error("can not load API keys", zap.Error(err))
+		return err
+	}
+	// revoked sessions are kept in storage, so logout survives restart
+	authToken, err := client.NewAuthTokenWithStorage(ctx, keySet, revocations)
+	if err != nil {
+		logger.Log.Error("can not load token revocations", zap.Error(err))
+		return err
+	}
+	token := apikey.WrapAuthToken(authToken)
+
+	// login with OpenID Connect identity provider
+	var oidcProvider *oidc.Provider
+	if config.OIDCIssuer != "" {
+		redirectURL := config.OIDCRedirectURL
+		if redirectURL == "" {
+			redirectURL = strings.TrimSuffix(config.BaseURL, "/") + "/api/user/oidc/callback"
+		}
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       config.OIDCIssuer,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"email"},
+		})
+	}
 
 	router := chi.NewRouter()
 	router.Use(logger.Middleware)
 	router.Use(middleware.GzipMiddleware)
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.APIKey(keyRegistry, next)
+	})
 	router.Use(func(next http.Handler) http.Handler {
 		return middleware.Auth(token, next)
 	})
@@ -146,11 +211,40 @@ func Run(config *config.Config) error {
 	router.Route("/", func(r chi.Router) {
 		router.Post("/", handlers.PostHandler(st, config.BaseURL, token))
 		router.Get("/{id}", handlers.GetHandler(st))
-		router.Post("/api/shorten", handlers.APIShortenHandler(st, config.BaseURL))
+		router.Post("/api/shorten", handlers.APIShortenHandler(st, config.BaseURL, token))
 		router.Get("/ping", handlers.PingHandler(sdb))
 		router.Post("/api/shorten/batch", handlers.PostBatchHandler(st, config.BaseURL))
 		router.Get("/api/user/urls", handlers.GetUserUrlsHandler(st, config.BaseURL, token))
-		router.Delete("/api/user/urls", handlers.DeleteUserUrlsHandler(st, token, fanInCh))
+		router.Delete("/api/user/urls", handlers.DeleteUserUrlsHandler(jobRegistry, token, deleter))
+		router.Post("/api/user/urls/import", handlers.ImportUserUrlsHandler(st, jobRegistry, token, importer))
+		router.Get("/api/user/jobs/{id}", handlers.GetJobHandler(jobRegistry, token))
+		router.Get("/api/user/urls/export", handlers.ExportUserUrlsHandler(st, config.BaseURL, token))
+		router.Get("/api/user/urls/search", handlers.SearchUserUrlsHandler(st, config.BaseURL, token))
+		router.Post("/api/user/urls/restore", handlers.RestoreUserUrlsHandler(jobRegistry, token, restorer))
+		router.Get("/api/user/urls/{alias}", handlers.GetUserURLHandler(st, config.BaseURL, token))
+		router.Patch("/api/user/urls/{alias}", handlers.PatchUserURLHandler(st, config.BaseURL, token))
+		router.Get("/api/user/urls/{alias}/stats", handlers.GetUserURLStatsHandler(st, config.BaseURL, token))
+		router.Get("/api/user/urls/{alias}/history", handlers.GetUserURLHistoryHandler(st, token))
+		router.Put("/api/user/urls/{alias}/tags", handlers.PutURLTagsHandler(st, token))
+		router.Delete("/api/user/urls/{alias}/tags", handlers.DeleteURLTagsHandler(st, token))
+		router.Get("/api/user/tags", handlers.GetUserTagsHandler(st, token))
+		router.Post("/api/user/webhooks", handlers.CreateWebhookHandler(webhookRegistry, token))
+		router.Get("/api/user/webhooks", handlers.GetWebhooksHandler(webhookRegistry, token))
+		router.Delete("/api/user/webhooks/{id}", handlers.DeleteWebhookHandler(webhookRegistry, token))
+		router.Get("/api/user/webhooks/{id}/deliveries", handlers.GetWebhookDeliveriesHandler(webhookRegistry, token))
+		router.Get("/api/user/webhooks/{id}/dead-letters", handlers.GetWebhookDeadLettersHandler(webhookRegistry, token))
+		router.Post("/api/user/register", handlers.RegisterHandler(users, st, token))
+		router.Post("/api/user/login", handlers.LoginHandler(users, st, token))
+		router.Post("/api/user/logout", handlers.LogoutHandler(token))
+		router.Delete("/api/user/sessions", handlers.RevokeSessionsHandler(token))
+		if oidcProvider != nil {
+			router.Get("/api/user/oidc/login", handlers.OIDCLoginHandler(oidcProvider))
+			router.Get("/api/user/oidc/callback", handlers.OIDCCallbackHandler(oidcProvider, users, st, token))
+		}
+		router.Post("/api/user/keys", handlers.CreateAPIKeyHandler(keyRegistry, token))
+		router.Get("/api/user/keys", handlers.GetAPIKeysHandler(keyRegistry, token))
+		router.Delete("/api/user/keys/{id}", handlers.RevokeAPIKeyHandler(keyRegistry, token))
+		router.Get("/api/user/events", handlers.GetUserEventsHandler(bus, config.BaseURL, token, streamsDone))
 
 		if config.DebugMode {
 			r.HandleFunc("/debug/pprof/*", pprof.Index)
@@ -163,13 +257,15 @@ func Run(config *config.Config) error {
 		Addr:    config.ServerAddr,
 		Handler: router,
 	}
+	srv.RegisterOnShutdown(func() { close(streamsDone) })
 
 	go func() {
 		// run server supporting https connections
 		if config.EnableHTTPS {
-			if err := http.ListenAndServeTLS(config.ServerAddr, serverCertFileName, serverKeyFileName, router); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			if err := srv.ListenAndServeTLS(serverCertFileName, serverKeyFileName); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				logger.Log.Fatal("Error starting https server", zap.Error(err))
 			}
+			return
 		}
 		// run server with http
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -185,13 +281,32 @@ func Run(config *config.Config) error {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	<-shutdownCtx.Done()
-
 	// shutdown server
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Log.Error("Error shutdown server", zap.Error(err))
 	}
 
+	// delete queued urls before storage is closed
+	if err := deleter.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Error("Error draining delete worker", zap.Error(err))
+	}
+	if err := restorer.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Error("Error draining restore worker", zap.Error(err))
+	}
+	if err := importer.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Error("Error waiting for imports", zap.Error(err))
+	}
+
+	// deliver events of the last changes
+	bus.Close()
+	select {
+	case <-webhookDone:
+	case <-shutdownCtx.Done():
+	}
+	if err := dispatcher.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Error("Error stopping webhook dispatcher", zap.Error(err))
+	}
+
 	// close storage
 
 	if sdb != nil {
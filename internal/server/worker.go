@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// purgeInterval returns interval of purge job for the retention period
+func purgeInterval(retention time.Duration) time.Duration {
+	return min(retention, time.Hour)
+}
+
+// runPurgeWorker periodically purges urls deleted more than retention period ago
+func runPurgeWorker(ctx context.Context, st storage.URLStorage, retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval(retention))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Debug("worker is stopped", zap.String("worker", "purge"))
+			return
+		case <-ticker.C:
+			n, err := st.PurgeDeletedURLsCtx(ctx, time.Now().Add(-retention))
+			if err != nil {
+				logger.Log.Error("can't purge deleted urls", zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				logger.Log.Info("deleted urls are purged", zap.Int("count", n))
+			}
+		}
+	}
+}
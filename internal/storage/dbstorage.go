@@ -3,22 +3,130 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/rookgm/shortener/internal/db"
-	"github.com/rookgm/shortener/internal/logger"
 	"github.com/rookgm/shortener/internal/models"
-	"go.uber.org/zap"
 )
 
+// insertURLQuery inserts a new url and its create event, arguments are prepared by insertURLArgs
+const insertURLQuery = `WITH ins AS (
+		INSERT INTO urls(userid,url,alias,utm_source,utm_medium,utm_campaign,utm_content,rules,variants,domain,title,note)
+		values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING alias, userid, url)
+	INSERT INTO url_history(alias,action,userid,url) SELECT alias, 'create', userid, url FROM ins`
+
+// insertBatchURLQuery inserts url of batch and returns its alias, nothing is returned on conflict,
+// so the batch transaction is not aborted by existing url or alias
+const insertBatchURLQuery = `WITH ins AS (
+		INSERT INTO urls(userid,url,alias,utm_source,utm_medium,utm_campaign,utm_content,rules,variants,domain,title,note)
+		values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) ON CONFLICT DO NOTHING RETURNING alias, userid, url),
+	hist AS (
+		INSERT INTO url_history(alias,action,userid,url) SELECT alias, 'create', userid, url FROM ins)
+	SELECT alias FROM ins`
+
+// aliasIndexName is name of unique index of aliases
+const aliasIndexName = "urls_alias_idx"
+
+// insertURLArgs returns arguments of insertURLQuery
+func insertURLArgs(url models.ShrURL) []any {
+	return []any{url.UserID, url.URL, url.Alias,
+		url.UTM.Source, url.UTM.Medium, url.UTM.Campaign, url.UTM.Content,
+		marshalRules(url.Rules), marshalVariants(url.Variants), urlDomain(url.URL), url.Title, url.Note}
+}
+
+// dbRule is redirect rule stored in rules column
+type dbRule struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+}
+
+// marshalRules converts redirect rules to JSON value of rules column
+func marshalRules(rules []models.RedirectRule) string {
+	dbRules := make([]dbRule, 0, len(rules))
+	for _, r := range rules {
+		dbRules = append(dbRules, dbRule{Platform: r.Platform, URL: r.URL})
+	}
+	b, _ := json.Marshal(dbRules)
+	return string(b)
+}
+
+// unmarshalRules converts JSON value of rules column to redirect rules
+func unmarshalRules(b []byte) ([]models.RedirectRule, error) {
+	var dbRules []dbRule
+	if err := json.Unmarshal(b, &dbRules); err != nil {
+		return nil, err
+	}
+	var rules []models.RedirectRule
+	for _, r := range dbRules {
+		rules = append(rules, models.RedirectRule{Platform: r.Platform, URL: r.URL})
+	}
+	return rules, nil
+}
+
+// dbVariant is A/B split destination stored in variants column,
+// clicks of variants are counted in variant_clicks table
+type dbVariant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// marshalVariants converts variants to JSON value of variants column
+func marshalVariants(variants []models.Variant) string {
+	dbVariants := make([]dbVariant, 0, len(variants))
+	for _, v := range variants {
+		dbVariants = append(dbVariants, dbVariant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	b, _ := json.Marshal(dbVariants)
+	return string(b)
+}
+
+// unmarshalVariants converts JSON value of variants column to variants
+func unmarshalVariants(b []byte) ([]models.Variant, error) {
+	var dbVariants []dbVariant
+	if err := json.Unmarshal(b, &dbVariants); err != nil {
+		return nil, err
+	}
+	var variants []models.Variant
+	for _, v := range dbVariants {
+		variants = append(variants, models.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	return variants, nil
+}
+
 // DBStorage presents database storage
 type DBStorage struct {
 	db *db.DataBase
 }
 
+// marshalTags converts tags to JSON value of tags column
+func marshalTags(tags []string) string {
+	if tags == nil {
+		tags = []string{}
+	}
+	b, _ := json.Marshal(tags)
+	return string(b)
+}
+
+// unmarshalTags converts JSON value of tags column to tags
+func unmarshalTags(b []byte) ([]string, error) {
+	var tags []string
+	if err := json.Unmarshal(b, &tags); err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
+
 // NewDBStorage creates a new storage on opened database
 func NewDBStorage(db *db.DataBase) (*DBStorage, error) {
 	return &DBStorage{db: db}, nil
@@ -29,15 +137,18 @@ func (d *DBStorage) LoadFromFile() error { return nil }
 
 // StoreURLCtx add url alias and original url to storage
 func (d *DBStorage) StoreURLCtx(ctx context.Context, url models.ShrURL) error {
-	stmt, err := d.db.DB.Prepare("INSERT INTO urls(userid,url,alias) values($1,$2,$3)")
+	stmt, err := d.db.DB.Prepare(insertURLQuery)
 	if err != nil {
 		return err
 	}
-	_, err = stmt.ExecContext(ctx, url.UserID, url.URL, url.Alias)
+	_, err = stmt.ExecContext(ctx, insertURLArgs(url)...)
 	if err != nil {
 		var pgErr *pgconn.PgError
-		// does the url exist
+		// does the url or alias exist
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			if pgErr.ConstraintName == aliasIndexName {
+				return ErrAliasExists
+			}
 			// url exist
 			return ErrURLExists
 		} else {
@@ -50,48 +161,72 @@ func (d *DBStorage) StoreURLCtx(ctx context.Context, url models.ShrURL) error {
 }
 
 // StoreBatchURLCtx stores batch urls
-func (d *DBStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) error {
-	tx, err := d.db.DB.Begin()
+func (d *DBStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error) {
+	tx, err := d.db.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	stmt, err := d.db.DB.PrepareContext(ctx, "INSERT INTO urls(userid,url,alias) values($1,$2,$3)")
+	// statements are prepared in transaction, so urls are stored atomically
+	stmt, err := tx.PrepareContext(ctx, insertBatchURLQuery)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stmt.Close()
 
+	aliasStmt, err := tx.PrepareContext(ctx, "SELECT alias FROM urls WHERE url=$1 AND NOT deleted")
+	if err != nil {
+		return nil, err
+	}
+	defer aliasStmt.Close()
+
+	results := make([]models.BatchResult, 0, len(urls))
 	for _, url := range urls {
-		_, err = stmt.ExecContext(ctx, url.UserID, url.URL, url.Alias)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			// does the url exist
-			if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-				// url exist
-				continue
-			} else {
-				return err
-			}
+		var alias string
+		err := stmt.QueryRowContext(ctx, insertURLArgs(url)...).Scan(&alias)
+		if err == nil {
+			results = append(results, models.BatchResult{Status: models.BatchCreated, Alias: alias})
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// url or alias exists
+		err = aliasStmt.QueryRowContext(ctx, url.URL).Scan(&alias)
+		switch {
+		case err == nil:
+			results = append(results, models.BatchResult{Status: models.BatchExists, Alias: alias})
+		case errors.Is(err, sql.ErrNoRows):
+			results = append(results, models.BatchResult{Status: models.BatchRejected, Reason: ErrAliasExists.Error()})
+		default:
+			return nil, err
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
 }
 
 // GetURLCtx returns url alias and original url by alias
 // if alias is not exist return an error
 func (d *DBStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrURL, error) {
-	stmt, err := d.db.DB.Prepare("SELECT url, deleted FROM urls WHERE alias=$1")
+	stmt, err := d.db.DB.Prepare(`SELECT url, userid, deleted, utm_source, utm_medium, utm_campaign, utm_content,
+		rules, variants, clicks, version, deleted_at, created_at, title, note, tags FROM urls WHERE alias=$1`)
 	if err != nil {
 		return models.ShrURL{}, err
 	}
 
-	var url string
-	var deleted bool
+	url := models.ShrURL{Alias: alias}
+	var rules, variants, tags []byte
+	var deletedAt sql.NullTime
 
-	err = stmt.QueryRowContext(ctx, alias).Scan(&url, &deleted)
+	err = stmt.QueryRowContext(ctx, alias).Scan(&url.URL, &url.UserID, &url.Deleted,
+		&url.UTM.Source, &url.UTM.Medium, &url.UTM.Campaign, &url.UTM.Content,
+		&rules, &variants, &url.Clicks, &url.Version, &deletedAt, &url.CreatedAt, &url.Title, &url.Note, &tags)
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
 		return models.ShrURL{}, ErrURLNotFound
@@ -99,13 +234,79 @@ func (d *DBStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrURL,
 		return models.ShrURL{}, err
 	}
 
-	return models.ShrURL{Alias: alias, URL: url, Deleted: deleted}, nil
+	url.DeletedAt = deletedAt.Time
+
+	if url.Rules, err = unmarshalRules(rules); err != nil {
+		return models.ShrURL{}, err
+	}
+	if url.Variants, err = unmarshalVariants(variants); err != nil {
+		return models.ShrURL{}, err
+	}
+	if url.Tags, err = unmarshalTags(tags); err != nil {
+		return models.ShrURL{}, err
+	}
+
+	if len(url.Variants) > 0 {
+		if err := d.loadVariantClicks(ctx, &url); err != nil {
+			return models.ShrURL{}, err
+		}
+	}
+
+	return url, nil
+}
+
+// UpdateURLCtx updates destination and options of user's url
+func (d *DBStorage) UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error) {
+	var newVersion int64
+
+	// old row is locked to get destination before update for url history
+	err := d.db.DB.QueryRowContext(ctx, `WITH old AS (
+			SELECT alias, url FROM urls WHERE alias=$1 FOR UPDATE),
+		upd AS (
+			UPDATE urls u SET url=$3,
+			utm_source=$4, utm_medium=$5, utm_campaign=$6, utm_content=$7,
+			rules=$8, variants=$9, domain=$11, title=$12, note=$13, version=u.version+1
+			FROM old WHERE u.alias=old.alias AND u.userid=$2 AND u.version=$10 AND NOT u.deleted
+			RETURNING u.alias, u.userid, u.url, old.url AS prev_url, u.version),
+		hist AS (
+			INSERT INTO url_history(alias,action,userid,url,prev_url)
+			SELECT alias, 'update', userid, url, prev_url FROM upd)
+		SELECT version FROM upd`,
+		url.Alias, url.UserID, url.URL,
+		url.UTM.Source, url.UTM.Medium, url.UTM.Campaign, url.UTM.Content,
+		marshalRules(url.Rules), marshalVariants(url.Variants), version, urlDomain(url.URL),
+		url.Title, url.Note).Scan(&newVersion)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation:
+			return models.ShrURL{}, ErrURLExists
+		case errors.Is(err, sql.ErrNoRows):
+			// find out why url was not updated
+			cur, err := d.GetURLCtx(ctx, url.Alias)
+			if err != nil {
+				return models.ShrURL{}, err
+			}
+			switch {
+			case cur.UserID != url.UserID:
+				return models.ShrURL{}, ErrNotOwner
+			case cur.Deleted:
+				return models.ShrURL{}, ErrURLDeleted
+			default:
+				return models.ShrURL{}, ErrVersionMismatch
+			}
+		default:
+			return models.ShrURL{}, err
+		}
+	}
+
+	return d.GetURLCtx(ctx, url.Alias)
 }
 
 // GetAliasCtx returns stored alias by url
 // if alias is not exist return an error
 func (d *DBStorage) GetAliasCtx(ctx context.Context, url string) (models.ShrURL, error) {
-	stmt, err := d.db.DB.Prepare("SELECT alias FROM urls WHERE url=$1")
+	stmt, err := d.db.DB.Prepare("SELECT alias FROM urls WHERE url=$1 AND NOT deleted")
 	if err != nil {
 		return models.ShrURL{}, err
 	}
@@ -146,32 +347,689 @@ func (d *DBStorage) GetUserURLsCtx(ctx context.Context, userID string) ([]models
 	return userURLs, nil
 }
 
-// DeleteUserURLsCtx deletes user URLs
-func (d *DBStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) error {
-	tx, err := d.db.DB.Begin()
+// WalkUserURLsCtx calls fn for every user URL while reading rows,
+// so URLs are not loaded into memory all at once
+func (d *DBStorage) WalkUserURLsCtx(ctx context.Context, userID string, fn func(url models.ShrURL) error) error {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT alias, url, deleted, clicks, created_at, deleted_at
+		FROM urls WHERE userid=$1 ORDER BY created_at, alias`, userID)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	for rows.Next() {
+		url := models.ShrURL{UserID: userID}
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&url.Alias, &url.URL, &url.Deleted, &url.Clicks, &url.CreatedAt, &deletedAt); err != nil {
+			return err
+		}
+		url.DeletedAt = deletedAt.Time
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
 
-	stmt, err := tx.Prepare("UPDATE urls SET deleted=true WHERE userid=$1 AND alias=$2")
+// ListUserURLsCtx returns page of user URLs matching the query.
+// Pages are selected by keyset of sort column and alias, so deep pages are as fast as the first one.
+func (d *DBStorage) ListUserURLsCtx(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+	sortColumn := "created_at"
+	if q.SortBy == models.SortByClicks {
+		sortColumn = "clicks"
+	}
+
+	args := []any{q.UserID}
+	where := []string{"userid=$1"}
+	// addCond adds condition with placeholders of next arguments
+	addCond := func(cond string, condArgs ...any) {
+		nums := make([]any, 0, len(condArgs))
+		for _, a := range condArgs {
+			args = append(args, a)
+			nums = append(nums, len(args))
+		}
+		where = append(where, fmt.Sprintf(cond, nums...))
+	}
+
+	if !q.IncludeDeleted {
+		where = append(where, "NOT deleted")
+	}
+	if q.Domain != "" {
+		addCond("domain=$%d", strings.ToLower(q.Domain))
+	}
+	if !q.CreatedAfter.IsZero() {
+		addCond("created_at>$%d", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		addCond("created_at<$%d", q.CreatedBefore)
+	}
+	if q.Tag != "" {
+		addCond("tags ? $%d", q.Tag)
+	}
+	if q.Cursor != "" {
+		key, alias, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		var keyArg any = key
+		if sortColumn == "created_at" {
+			keyArg = time.UnixMicro(key)
+		}
+		addCond("("+sortColumn+", alias)<($%d, $%d)", keyArg, alias)
+	}
+
+	limit := listLimit(q)
+	// one more row tells there is the next page
+	args = append(args, limit+1)
+	query := fmt.Sprintf(`SELECT alias, url, userid, deleted, clicks, version, created_at, deleted_at, tags
+		FROM urls WHERE %s ORDER BY %s DESC, alias DESC LIMIT $%d`,
+		strings.Join(where, " AND "), sortColumn, len(args))
+
+	rows, err := d.db.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	urls := make([]models.ShrURL, 0, limit)
+	for rows.Next() {
+		var url models.ShrURL
+		var deletedAt sql.NullTime
+		var tags []byte
+		if err := rows.Scan(&url.Alias, &url.URL, &url.UserID, &url.Deleted, &url.Clicks,
+			&url.Version, &url.CreatedAt, &deletedAt, &tags); err != nil {
+			return nil, "", err
+		}
+		url.DeletedAt = deletedAt.Time
+		if url.Tags, err = unmarshalTags(tags); err != nil {
+			return nil, "", err
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(urls) <= limit {
+		return urls, "", nil
+	}
+	urls = urls[:limit]
+	last := urls[limit-1]
+	return urls, encodeCursor(sortKey(last, q.SortBy), last.Alias), nil
+}
+
+// SearchUserURLsCtx returns user URLs matching the query ranked by full-text search
+func (d *DBStorage) SearchUserURLsCtx(ctx context.Context, userID string, query string, limit int) ([]models.ShrURL, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	// every term is matched as prefix of word, terms contain letters and digits only
+	for i, t := range terms {
+		terms[i] = t + ":*"
+	}
+	limit = listLimit(models.UserURLsQuery{Limit: limit})
+
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT alias, url, userid, clicks, version, created_at, title, note
+		FROM urls, to_tsquery('simple', $2) AS q
+		WHERE userid=$1 AND NOT deleted AND search @@ q
+		ORDER BY ts_rank(search, q) DESC, created_at DESC, alias DESC LIMIT $3`,
+		userID, strings.Join(terms, " & "), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []models.ShrURL
+	for rows.Next() {
+		var url models.ShrURL
+		if err := rows.Scan(&url.Alias, &url.URL, &url.UserID, &url.Clicks, &url.Version,
+			&url.CreatedAt, &url.Title, &url.Note); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// UpdateURLTagsCtx adds and removes tags of user's url
+func (d *DBStorage) UpdateURLTagsCtx(ctx context.Context, userID string, alias string, add []string, remove []string) ([]string, error) {
+	var tags []byte
+
+	err := d.db.DB.QueryRowContext(ctx, `WITH upd AS (
+			UPDATE urls SET tags=(
+				SELECT coalesce(jsonb_agg(DISTINCT t ORDER BY t), '[]')
+				FROM jsonb_array_elements_text(tags || $3::jsonb) t
+				WHERE NOT $4::jsonb ? t OR $3::jsonb ? t)
+			WHERE alias=$1 AND userid=$2 AND NOT deleted
+			RETURNING alias, userid, url, tags),
+		hist AS (
+			INSERT INTO url_history(alias,action,userid,url,prev_url)
+			SELECT alias, 'tags', userid, url, url FROM upd)
+		SELECT tags FROM upd`,
+		alias, userID, marshalTags(add), marshalTags(remove)).Scan(&tags)
+	if errors.Is(err, sql.ErrNoRows) {
+		// find out why tags were not updated
+		cur, err := d.GetURLCtx(ctx, alias)
+		if err != nil {
+			return nil, err
+		}
+		_, err = updateTags(cur, userID, add, remove)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalTags(tags)
+}
+
+// GetUserTagsCtx returns tags of user URLs with number of URLs
+func (d *DBStorage) GetUserTagsCtx(ctx context.Context, userID string) ([]models.TagCount, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT t, count(*) FROM urls, jsonb_array_elements_text(tags) t
+		WHERE userid=$1 AND NOT deleted GROUP BY t ORDER BY t`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.TagCount{}
+	for rows.Next() {
+		var tc models.TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// DeleteUserURLsCtx deletes user URLs by one statement,
+// owners of all aliases are selected to report aliases not deleted
+func (d *DBStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `WITH del AS (
+			UPDATE urls SET deleted=true, deleted_at=now() WHERE userid=$1 AND alias=ANY($2) AND NOT deleted
+			RETURNING alias, userid, url),
+		hist AS (
+			INSERT INTO url_history(alias,action,userid,url,prev_url)
+			SELECT alias, 'delete', userid, url, url FROM del)
+		SELECT alias, userid FROM urls WHERE alias=ANY($2)`, userID, aliases)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]string, len(aliases))
+	for rows.Next() {
+		var alias, owner string
+		if err := rows.Scan(&alias, &owner); err != nil {
+			return nil, err
+		}
+		owners[alias] = owner
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
+	failed := make(map[string]error)
 	for _, alias := range aliases {
-		_, err = stmt.ExecContext(ctx, userID, alias)
+		owner, ok := owners[alias]
+		if err := checkDelete(models.ShrURL{UserID: owner}, ok, userID); err != nil {
+			failed[alias] = err
+		}
+	}
+	return failed, nil
+}
+
+// RestoreUserURLsCtx restores deleted user URLs,
+// urls of aliases not restored are selected to report the reason
+func (d *DBStorage) RestoreUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	tx, err := d.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the url may be shortened again after deletion
+	stmt, err := tx.PrepareContext(ctx, `WITH res AS (
+			UPDATE urls u SET deleted=false, deleted_at=NULL WHERE userid=$1 AND alias=$2 AND deleted
+			AND NOT EXISTS (SELECT 1 FROM urls a WHERE a.url=u.url AND NOT a.deleted)
+			RETURNING alias, userid, url)
+		INSERT INTO url_history(alias,action,userid,url,prev_url)
+		SELECT alias, 'restore', userid, url, url FROM res`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	restored := make(map[string]struct{}, len(aliases))
+	skipped := make([]string, 0)
+	for _, alias := range aliases {
+		if _, ok := restored[alias]; ok {
+			continue
+		}
+		res, err := stmt.ExecContext(ctx, userID, alias)
 		if err != nil {
-			tx.Rollback()
-			logger.Log.Warn("cannot update", zap.String("alias", alias), zap.Error(err))
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			restored[alias] = struct{}{}
 			continue
 		}
+		skipped = append(skipped, alias)
+	}
+
+	failed := make(map[string]error)
+	if len(skipped) > 0 {
+		rows, err := tx.QueryContext(ctx, `SELECT alias, userid, deleted FROM urls WHERE alias=ANY($1)`, skipped)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		urls := make(map[string]models.ShrURL, len(skipped))
+		for rows.Next() {
+			var url models.ShrURL
+			if err := rows.Scan(&url.Alias, &url.UserID, &url.Deleted); err != nil {
+				return nil, err
+			}
+			urls[url.Alias] = url
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		for _, alias := range skipped {
+			url, ok := urls[alias]
+			if err := checkRestore(url, ok, userID); err != nil {
+				failed[alias] = err
+				continue
+			}
+			failed[alias] = ErrURLExists
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return failed, nil
+}
+
+// TransferUserURLsCtx reassigns all user URLs to another user
+func (d *DBStorage) TransferUserURLsCtx(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	if fromUserID == toUserID {
+		return 0, nil
+	}
+
+	var transferred int
+	err := d.db.DB.QueryRowContext(ctx, `WITH moved AS (
+			UPDATE urls SET userid=$2 WHERE userid=$1 RETURNING alias, userid, url),
+		hist AS (
+			INSERT INTO url_history(alias,action,userid,url,prev_url)
+			SELECT alias, 'transfer', userid, url, url FROM moved)
+		SELECT count(*) FROM moved`, fromUserID, toUserID).Scan(&transferred)
+	if err != nil {
+		return 0, err
+	}
+
+	return transferred, nil
+}
+
+// PurgeDeletedURLsCtx removes URLs deleted before the time
+func (d *DBStorage) PurgeDeletedURLsCtx(ctx context.Context, before time.Time) (int, error) {
+	var purged int
+
+	err := d.db.DB.QueryRowContext(ctx, `WITH del AS (
+			DELETE FROM urls WHERE deleted AND deleted_at < $1 RETURNING alias, userid, url),
+		hist AS (
+			INSERT INTO url_history(alias,action,userid,url,prev_url)
+			SELECT alias, 'purge', userid, url, url FROM del),
+		clicks AS (
+			DELETE FROM variant_clicks WHERE alias IN (SELECT alias FROM del))
+		SELECT count(*) FROM del`, before).Scan(&purged)
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+// GetURLHistoryCtx returns events of url
+func (d *DBStorage) GetURLHistoryCtx(ctx context.Context, alias string) ([]models.URLEvent, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT action, userid, url, prev_url, created_at
+		FROM url_history WHERE alias=$1 ORDER BY id`, alias)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.URLEvent
+
+	for rows.Next() {
+		event := models.URLEvent{Alias: alias}
+		if err := rows.Scan(&event.Action, &event.UserID, &event.URL, &event.PrevURL, &event.Time); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	if len(events) == 0 {
+		return nil, ErrURLNotFound
+	}
+	return events, nil
+}
 
-	err = tx.Commit()
+// RegisterClickCtx counts redirect by alias to variant
+func (d *DBStorage) RegisterClickCtx(ctx context.Context, alias string, variant string) (models.Click, error) {
+	tx, err := d.db.DB.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Log.Error("cannot commit", zap.Error(err))
+		return models.Click{}, err
+	}
+	defer tx.Rollback()
+
+	var click models.Click
+
+	// row is locked by update, so exactly one click gets the first number
+	err = tx.QueryRowContext(ctx, "UPDATE urls SET clicks=clicks+1 WHERE alias=$1 RETURNING clicks, userid, url", alias).
+		Scan(&click.Clicks, &click.UserID, &click.URL)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return models.Click{}, ErrURLNotFound
+	case err != nil:
+		return models.Click{}, err
+	}
+	click.First = click.Clicks == 1
+
+	if variant != "" {
+		_, err = tx.ExecContext(ctx, `INSERT INTO variant_clicks(alias,variant,clicks) VALUES($1,$2,1)
+			ON CONFLICT (alias,variant) DO UPDATE SET clicks=variant_clicks.clicks+1`, alias, variant)
+		if err != nil {
+			return models.Click{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Click{}, err
+	}
+	return click, nil
+}
+
+// loadVariantClicks fills clicks of url variants
+func (d *DBStorage) loadVariantClicks(ctx context.Context, url *models.ShrURL) error {
+	rows, err := d.db.DB.QueryContext(ctx, "SELECT variant, clicks FROM variant_clicks WHERE alias=$1", url.Alias)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	clicks := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var n int64
+		if err := rows.Scan(&name, &n); err != nil {
+			return err
+		}
+		clicks[name] = n
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
 
+	for i := range url.Variants {
+		url.Variants[i].Clicks = clicks[url.Variants[i].Name]
+	}
+	return nil
+}
+
+// CreateUserCtx stores account
+func (d *DBStorage) CreateUserCtx(ctx context.Context, user models.User) error {
+	_, err := d.db.DB.ExecContext(ctx, `INSERT INTO users(id,login,password_hash,created_at)
+		VALUES($1,$2,$3,$4)`, user.ID, user.Login, user.PasswordHash, user.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrLoginExists
+		}
+		return err
+	}
 	return nil
 }
+
+// GetUserCtx returns account by ID
+func (d *DBStorage) GetUserCtx(ctx context.Context, id string) (models.User, error) {
+	return d.getUser(ctx, "SELECT id, login, password_hash, created_at FROM users WHERE id=$1", id)
+}
+
+// GetUserByLoginCtx returns account by login
+func (d *DBStorage) GetUserByLoginCtx(ctx context.Context, login string) (models.User, error) {
+	return d.getUser(ctx, "SELECT id, login, password_hash, created_at FROM users WHERE login=$1", login)
+}
+
+// getUser returns account selected by query
+func (d *DBStorage) getUser(ctx context.Context, query string, arg string) (models.User, error) {
+	var user models.User
+	err := d.db.DB.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, ErrAccountNotFound
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// CreateWebhookCtx stores webhook
+func (d *DBStorage) CreateWebhookCtx(ctx context.Context, hook models.Webhook) error {
+	_, err := d.db.DB.ExecContext(ctx, `INSERT INTO webhooks(id,userid,url,secret,events,created_at)
+		VALUES($1,$2,$3,$4,$5,$6)`, hook.ID, hook.UserID, hook.URL, hook.Secret, marshalTags(hook.Events), hook.CreatedAt)
+	return err
+}
+
+// ListWebhooksCtx returns webhooks of all users ordered by creation time
+func (d *DBStorage) ListWebhooksCtx(ctx context.Context) ([]models.Webhook, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT id, userid, url, secret, events, created_at
+		FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []models.Webhook
+
+	for rows.Next() {
+		var hook models.Webhook
+		var events []byte
+		if err := rows.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Secret, &events, &hook.CreatedAt); err != nil {
+			return nil, err
+		}
+		// events are kept in the same form as tags
+		if hook.Events, err = unmarshalTags(events); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// DeleteWebhookCtx removes webhook, its dead letters are removed by cascade
+func (d *DBStorage) DeleteWebhookCtx(ctx context.Context, id string) error {
+	res, err := d.db.DB.ExecContext(ctx, "DELETE FROM webhooks WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// AddWebhookDeadLetterCtx stores event not delivered to webhook, the oldest dead letters are dropped
+func (d *DBStorage) AddWebhookDeadLetterCtx(ctx context.Context, letter models.WebhookDeadLetter, keep int) error {
+	tx, err := d.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_dead_letters(hook_id,event_id,event_type,alias,url,event_time,attempts,last_error,created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9)`, letter.HookID, letter.EventID, letter.EventType, letter.Alias, letter.URL,
+		letter.EventTime, letter.Attempts, letter.LastError, letter.Time)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return ErrWebhookNotFound
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM webhook_dead_letters WHERE hook_id=$1 AND id NOT IN
+		(SELECT id FROM webhook_dead_letters WHERE hook_id=$1 ORDER BY id DESC LIMIT $2)`, letter.HookID, keep)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListWebhookDeadLettersCtx returns dead letters of webhook, the latest goes first
+func (d *DBStorage) ListWebhookDeadLettersCtx(ctx context.Context, hookID string) ([]models.WebhookDeadLetter, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT event_id, event_type, alias, url, event_time, attempts, last_error, created_at
+		FROM webhook_dead_letters WHERE hook_id=$1 ORDER BY id DESC`, hookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []models.WebhookDeadLetter
+
+	for rows.Next() {
+		letter := models.WebhookDeadLetter{HookID: hookID}
+		if err := rows.Scan(&letter.EventID, &letter.EventType, &letter.Alias, &letter.URL, &letter.EventTime,
+			&letter.Attempts, &letter.LastError, &letter.Time); err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// CreateAPIKeyCtx stores API key
+func (d *DBStorage) CreateAPIKeyCtx(ctx context.Context, key models.APIKey) error {
+	expiresAt := sql.NullTime{Time: key.ExpiresAt, Valid: !key.ExpiresAt.IsZero()}
+	_, err := d.db.DB.ExecContext(ctx, `INSERT INTO api_keys(id,userid,name,prefix,hash,scopes,created_at,expires_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8)`, key.ID, key.UserID, key.Name, key.Prefix, key.Hash,
+		marshalTags(key.Scopes), key.CreatedAt, expiresAt)
+	return err
+}
+
+// ListAPIKeysCtx returns API keys of all users ordered by creation time
+func (d *DBStorage) ListAPIKeysCtx(ctx context.Context) ([]models.APIKey, error) {
+	rows, err := d.db.DB.QueryContext(ctx, `SELECT id, userid, name, prefix, hash, scopes, created_at, expires_at
+		FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+
+	for rows.Next() {
+		var key models.APIKey
+		var scopes []byte
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &scopes,
+			&key.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		// scopes are kept in the same form as tags
+		if key.Scopes, err = unmarshalTags(scopes); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			key.ExpiresAt = expiresAt.Time
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteAPIKeyCtx removes API key
+func (d *DBStorage) DeleteAPIKeyCtx(ctx context.Context, id string) error {
+	res, err := d.db.DB.ExecContext(ctx, "DELETE FROM api_keys WHERE id=$1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// RevokeCtx stores revocation of auth tokens
+func (d *DBStorage) RevokeCtx(ctx context.Context, rev models.Revocation) error {
+	_, err := d.db.DB.ExecContext(ctx, `INSERT INTO revocations(id,session_id,userid,revoked_at,until)
+		VALUES($1,$2,$3,$4,$5) ON CONFLICT (id) DO UPDATE SET revoked_at=EXCLUDED.revoked_at, until=EXCLUDED.until`,
+		revocationKey(rev), rev.SessionID, rev.UserID, rev.Time, rev.Until)
+	return err
+}
+
+// ListRevocationsCtx returns revocations not expired at now and removes expired ones
+func (d *DBStorage) ListRevocationsCtx(ctx context.Context, now time.Time) ([]models.Revocation, error) {
+	if _, err := d.db.DB.ExecContext(ctx, "DELETE FROM revocations WHERE until < $1", now); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.DB.QueryContext(ctx, "SELECT session_id, userid, revoked_at, until FROM revocations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revs []models.Revocation
+
+	for rows.Next() {
+		var rev models.Revocation
+		if err := rows.Scan(&rev.SessionID, &rev.UserID, &rev.Time, &rev.Until); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
@@ -1,11 +1,18 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/rookgm/shortener/internal/models"
 	"github.com/rookgm/shortener/internal/recorder"
@@ -13,15 +20,49 @@ import (
 
 // FileStorage presents storage on file
 type FileStorage struct {
-	m map[string]string
-	// user urls grouped by uid
-	muser    map[string][]models.ShrURL
+	// urls grouped by alias
+	m map[string]models.ShrURL
+	// user aliases grouped by uid
+	muser map[string][]string
+	// aliases of not deleted urls grouped by destination
+	murl map[string]string
+	// url events grouped by alias
+	history  map[string][]models.URLEvent
+	search   *searchIndex
 	mtx      sync.RWMutex
 	fileName string
 	rec      *recorder.Recorder
 	index    int
+	// accounts grouped by ID
+	users map[string]models.User
+	// account IDs grouped by login
+	logins map[string]string
+	// clicksMtx guards clicks and clicks file, it is taken after mtx,
+	// so redirects are counted without exclusive lock of urls
+	clicksMtx sync.Mutex
+	// clicks of urls grouped by alias, stored urls have no clicks
+	clicks map[string]linkClicks
+	// clicksFile is clicks file opened for appending, it is nil until the first click
+	clicksFile *os.File
+	// clickRecords is number of records in clicks file,
+	// clickSnapshot is number of them written by the last compaction
+	clickRecords  int
+	clickSnapshot int
+	// webhooks grouped by ID
+	webhooks map[string]models.Webhook
+	// dead letters grouped by webhook ID in the order they were added
+	deadLetters map[string][]models.WebhookDeadLetter
+	// API keys grouped by ID
+	apiKeys map[string]models.APIKey
+	// revocations of auth tokens grouped by revoked session or user,
+	// revocationRecords is number of records in revocations file
+	revocations       map[string]models.Revocation
+	revocationRecords int
 }
 
+// clicksCompactEvery is number of click records appended to clicks file before it is compacted
+var clicksCompactEvery = 10000
+
 // NewFileStorage is created new storage on file
 func NewFileStorage(filename string) *FileStorage {
 	newRec, err := recorder.NewRecorder()
@@ -30,13 +71,49 @@ func NewFileStorage(filename string) *FileStorage {
 	}
 
 	return &FileStorage{
-		m:        make(map[string]string),
-		muser:    make(map[string][]models.ShrURL),
+		m:        make(map[string]models.ShrURL),
+		muser:    make(map[string][]string),
+		murl:     make(map[string]string),
+		clicks:   make(map[string]linkClicks),
+		history:  make(map[string][]models.URLEvent),
+		search:   newSearchIndex(),
 		fileName: filename,
 		rec:      newRec,
+		users:    make(map[string]models.User),
+		logins:   make(map[string]string),
+
+		webhooks:    make(map[string]models.Webhook),
+		deadLetters: make(map[string][]models.WebhookDeadLetter),
+		apiKeys:     make(map[string]models.APIKey),
+		revocations: make(map[string]models.Revocation),
 	}
 }
 
+// usersFileName returns name of file keeping accounts next to urls file
+func (fs *FileStorage) usersFileName() string {
+	return fs.fileName + ".users"
+}
+
+// webhooksFileName returns name of file keeping webhooks next to urls file
+func (fs *FileStorage) webhooksFileName() string {
+	return fs.fileName + ".webhooks"
+}
+
+// apiKeysFileName returns name of file keeping API keys next to urls file
+func (fs *FileStorage) apiKeysFileName() string {
+	return fs.fileName + ".apikeys"
+}
+
+// revocationsFileName returns name of file keeping revocations of auth tokens next to urls file
+func (fs *FileStorage) revocationsFileName() string {
+	return fs.fileName + ".revocations"
+}
+
+// clicksFileName returns name of file keeping clicks next to urls file
+func (fs *FileStorage) clicksFileName() string {
+	return fs.fileName + ".clicks"
+}
+
 // LoadFromFile is load storage from file
 func (fs *FileStorage) LoadFromFile() error {
 	fs.mtx.Lock()
@@ -48,88 +125,335 @@ func (fs *FileStorage) LoadFromFile() error {
 	}
 	defer file.Close()
 
-	fs.m, err = fs.rec.ReadAllRecords(file)
+	recs, err := fs.rec.ReadAllRecords(file)
 	if err != nil {
 		return err
 	}
 
-	fs.index = len(fs.m)
+	fs.m = make(map[string]models.ShrURL)
+	fs.muser = make(map[string][]string)
+	fs.murl = make(map[string]string)
+	fs.history = make(map[string][]models.URLEvent)
+	fs.search = newSearchIndex()
 
-	return nil
+	// the last record of alias is actual state of url,
+	// all records of alias are its history
+	for _, r := range recs {
+		prev, ok := fs.m[r.ShortURL]
+		if r.Action == models.ActionPurge {
+			// history of purged url is kept
+			fs.history[r.ShortURL] = append(fs.history[r.ShortURL], recordToEvent(r, prev, prev.URL))
+			removeURLs(fs.m, fs.muser, fs.murl, []string{r.ShortURL})
+			continue
+		}
+		if !ok {
+			fs.muser[r.UserID] = append(fs.muser[r.UserID], r.ShortURL)
+		} else if prev.UserID != r.UserID {
+			// url is transferred to another user
+			fs.muser[prev.UserID] = slices.DeleteFunc(fs.muser[prev.UserID], func(alias string) bool {
+				return alias == r.ShortURL
+			})
+			if len(fs.muser[prev.UserID]) == 0 {
+				delete(fs.muser, prev.UserID)
+			}
+			fs.muser[r.UserID] = append(fs.muser[r.UserID], r.ShortURL)
+		}
+		url := recordToURL(r)
+		// records written before creation time
+		if url.CreatedAt.IsZero() {
+			url.CreatedAt = r.Time
+			if ok {
+				url.CreatedAt = prev.CreatedAt
+			}
+		}
+		fs.m[r.ShortURL] = url
+		indexURL(fs.murl, prev, url)
+		fs.history[r.ShortURL] = append(fs.history[r.ShortURL], recordToEvent(r, url, prev.URL))
+	}
+
+	for _, url := range fs.m {
+		fs.search.add(url)
+	}
+	// transferred urls are appended, so aliases are sorted in order of creation
+	for _, aliases := range fs.muser {
+		slices.SortStableFunc(aliases, func(a, b string) int {
+			return fs.m[a].CreatedAt.Compare(fs.m[b].CreatedAt)
+		})
+	}
+	fs.index = len(recs)
+
+	if err := fs.loadClicks(); err != nil {
+		return err
+	}
+	if err := fs.loadUsers(); err != nil {
+		return err
+	}
+	if err := fs.loadWebhooks(); err != nil {
+		return err
+	}
+	if err := fs.loadAPIKeys(); err != nil {
+		return err
+	}
+	return fs.loadRevocations()
 }
 
-// StoreURLCtx add url alias and original url to storage
-func (fs *FileStorage) StoreURLCtx(ctx context.Context, url models.ShrURL) error {
-	fs.mtx.Lock()
-	defer fs.mtx.Unlock()
+// linkClicks are clicks of link and its variants
+type linkClicks struct {
+	total    int64
+	variants map[string]int64
+}
 
-	if fs.isURLExist(url.URL) {
-		// url exist
-		return ErrURLExists
+// add counts n clicks of the link and its variant
+func (c linkClicks) add(variant string, n int64) linkClicks {
+	c.total += n
+	if variant == "" {
+		return c
+	}
+	if c.variants == nil {
+		c.variants = make(map[string]int64)
+	}
+	c.variants[variant] += n
+	return c
+}
+
+// apply returns copy of url with the clicks,
+// variants are copied because they are shared with stored url
+func (c linkClicks) apply(url models.ShrURL) models.ShrURL {
+	url.Clicks = c.total
+	if len(url.Variants) == 0 {
+		return url
 	}
+	variants := make([]models.Variant, len(url.Variants))
+	copy(variants, url.Variants)
+	for i := range variants {
+		variants[i].Clicks = c.variants[variants[i].Name]
+	}
+	url.Variants = variants
+	return url
+}
 
-	// put url
-	fs.m[url.Alias] = url.URL
-	// put user url
-	fs.muser[url.UserID] = append(fs.muser[url.UserID], url)
+// withClicks sets clicks of urls
+func (fs *FileStorage) withClicks(urls []models.ShrURL) []models.ShrURL {
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
 
-	file, err := os.OpenFile(fs.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	for i, url := range urls {
+		if c, ok := fs.clicks[url.Alias]; ok {
+			urls[i] = c.apply(url)
+		}
+	}
+	return urls
+}
+
+// loadClicks loads clicks of loaded urls from clicks file
+func (fs *FileStorage) loadClicks() error {
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
+
+	if err := fs.closeClicksFile(); err != nil {
+		return err
+	}
+	fs.clicks = make(map[string]linkClicks)
+	fs.clickRecords = 0
+	fs.clickSnapshot = 0
+
+	file, err := os.Open(fs.clicksFileName())
+	// no link is clicked yet
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	fs.index++
+	recs, err := fs.rec.ReadAllClickRecords(file)
+	if err != nil {
+		return err
+	}
 
-	nr := recorder.Record{
-		UUID:        strconv.Itoa(fs.index),
-		ShortURL:    url.Alias,
-		OriginalURL: url.URL,
+	for _, r := range recs {
+		// clicks of purged url
+		if _, ok := fs.m[r.ShortURL]; !ok {
+			continue
+		}
+		fs.clicks[r.ShortURL] = fs.clicks[r.ShortURL].add(r.Variant, r.Clicks)
 	}
+	fs.clickRecords = len(recs)
+	fs.clickSnapshot = len(recs)
+	return nil
+}
+
+// closeClicksFile closes clicks file opened for appending, it is reopened by the next click
+func (fs *FileStorage) closeClicksFile() error {
+	if fs.clicksFile == nil {
+		return nil
+	}
+	err := fs.clicksFile.Close()
+	fs.clicksFile = nil
+	return err
+}
+
+// Close closes clicks file
+func (fs *FileStorage) Close() error {
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
+
+	return fs.closeClicksFile()
+}
+
+// compactClicks rewrites clicks file with one record per link and variant.
+// Records are written to temporary file which replaces the file.
+func (fs *FileStorage) compactClicks() error {
+	tmp, err := os.CreateTemp(filepath.Dir(fs.fileName), filepath.Base(fs.clicksFileName())+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
 
-	if err := fs.rec.WriteRecord(file, &nr); err != nil {
+	w := bufio.NewWriter(tmp)
+	n := 0
+	write := func(rec recorder.ClickRecord) error {
+		if rec.Clicks == 0 {
+			return nil
+		}
+		n++
+		return fs.rec.WriteClickRecord(w, &rec)
+	}
+	for alias, c := range fs.clicks {
+		// clicks of link not counted by variants
+		rest := c.total
+		for name, clicks := range c.variants {
+			if err := write(recorder.ClickRecord{ShortURL: alias, Variant: name, Clicks: clicks}); err != nil {
+				tmp.Close()
+				return err
+			}
+			rest -= clicks
+		}
+		if err := write(recorder.ClickRecord{ShortURL: alias, Clicks: rest}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// appending to replaced file is lost
+	if err := fs.closeClicksFile(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fs.clicksFileName()); err != nil {
 		return err
 	}
 
+	fs.clickRecords = n
+	fs.clickSnapshot = n
 	return nil
 }
 
-// StoreBatchURLCtx stores batch urls
-func (fs *FileStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) error {
-	fs.mtx.Lock()
-	defer fs.mtx.Unlock()
+// loadUsers loads accounts from users file
+func (fs *FileStorage) loadUsers() error {
+	fs.users = make(map[string]models.User)
+	fs.logins = make(map[string]string)
 
-	file, err := os.OpenFile(fs.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	file, err := os.Open(fs.usersFileName())
+	// no account is registered yet
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	for _, url := range urls {
-		if fs.isURLExist(url.URL) {
-			// url exist
-			continue
+	recs, err := fs.rec.ReadAllUserRecords(file)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		fs.users[r.ID] = models.User{
+			ID:           r.ID,
+			Login:        r.Login,
+			PasswordHash: r.PasswordHash,
+			CreatedAt:    r.CreatedAt,
 		}
+		fs.logins[r.Login] = r.ID
+	}
+	return nil
+}
 
-		// put url
-		fs.m[url.Alias] = url.URL
-		// put user url
-		fs.muser[url.UserID] = append(fs.muser[url.UserID], url)
+// StoreURLCtx add url alias and original url to storage
+func (fs *FileStorage) StoreURLCtx(ctx context.Context, url models.ShrURL) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.m[url.Alias]; ok {
+		return ErrAliasExists
+	}
+	if fs.isURLExist(url.URL) {
+		// url exist
+		return ErrURLExists
+	}
+	url.Version = 1
+	url.CreatedAt = time.Now()
+
+	if err := fs.writeRecords([]urlChange{{url, newURLEvent(models.ActionCreate, url, "")}}); err != nil {
+		return err
+	}
+
+	// put url
+	fs.m[url.Alias] = url
+	indexURL(fs.murl, models.ShrURL{}, url)
+	fs.search.add(url)
+	// put user url
+	fs.muser[url.UserID] = append(fs.muser[url.UserID], url.Alias)
+
+	return nil
+}
 
-		fs.index++
+// StoreBatchURLCtx stores batch urls
+func (fs *FileStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
 
-		nr := recorder.Record{
-			UUID:        strconv.Itoa(fs.index),
-			ShortURL:    url.Alias,
-			OriginalURL: url.URL,
+	results := make([]models.BatchResult, 0, len(urls))
+	changes := make([]urlChange, 0, len(urls))
+	for _, url := range urls {
+		if res, ok := checkBatchURL(fs.m, fs.murl, url); !ok {
+			results = append(results, res)
+			continue
 		}
+		url.Version = 1
+		url.CreatedAt = time.Now()
 
-		if err := fs.rec.WriteRecord(file, &nr); err != nil {
-			return err
+		// url is put before it is written, so the next urls of batch are checked against it
+		fs.m[url.Alias] = url
+		indexURL(fs.murl, models.ShrURL{}, url)
+		changes = append(changes, urlChange{url, newURLEvent(models.ActionCreate, url, "")})
+		results = append(results, models.BatchResult{Status: models.BatchCreated, Alias: url.Alias})
+	}
+
+	if err := fs.writeRecords(changes); err != nil {
+		// batch is not stored
+		for _, c := range changes {
+			delete(fs.m, c.url.Alias)
+			indexURL(fs.murl, c.url, models.ShrURL{})
 		}
+		return nil, err
 	}
 
-	return nil
+	for _, c := range changes {
+		fs.search.add(c.url)
+		// put user url
+		fs.muser[c.url.UserID] = append(fs.muser[c.url.UserID], c.url.Alias)
+	}
+
+	return results, nil
 }
 
 // GetURLCtx returns url alias and original url by alias
@@ -142,7 +466,7 @@ func (fs *FileStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrU
 	if !ok {
 		return models.ShrURL{}, ErrURLNotFound
 	}
-	return models.ShrURL{Alias: alias, URL: url}, nil
+	return fs.withClicks([]models.ShrURL{url})[0], nil
 }
 
 // GetAliasCtx returns stored alias by url
@@ -150,10 +474,8 @@ func (fs *FileStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrU
 func (fs *FileStorage) GetAliasCtx(ctx context.Context, url string) (models.ShrURL, error) {
 	fs.mtx.RLock()
 	defer fs.mtx.RUnlock()
-	for k, v := range fs.m {
-		if strings.Compare(v, url) == 0 {
-			return models.ShrURL{Alias: k, URL: v}, nil
-		}
+	if alias, ok := fs.murl[url]; ok {
+		return models.ShrURL{Alias: alias, URL: url}, nil
 	}
 	return models.ShrURL{}, ErrAliasNotFound
 }
@@ -162,27 +484,948 @@ func (fs *FileStorage) GetAliasCtx(ctx context.Context, url string) (models.ShrU
 func (fs *FileStorage) GetUserURLsCtx(ctx context.Context, userID string) ([]models.ShrURL, error) {
 	fs.mtx.RLock()
 	defer fs.mtx.RUnlock()
-	urls, ok := fs.muser[userID]
+	aliases, ok := fs.muser[userID]
 	if !ok {
 		return nil, ErrUserNotFound
 	}
-	return urls, nil
+
+	urls := make([]models.ShrURL, 0, len(aliases))
+	for _, alias := range aliases {
+		urls = append(urls, fs.m[alias])
+	}
+	return fs.withClicks(urls), nil
 }
 
-// DeleteUserURLsCtx deletes user URLs
-func (fs *FileStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) error {
-	// TODO
+// WalkUserURLsCtx calls fn for every user URL.
+// Storage is not locked while fn is called, so fn may be slow.
+func (fs *FileStorage) WalkUserURLsCtx(ctx context.Context, userID string, fn func(url models.ShrURL) error) error {
+	fs.mtx.RLock()
+	aliases := append([]string(nil), fs.muser[userID]...)
+	fs.mtx.RUnlock()
+
+	for _, alias := range aliases {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fs.mtx.RLock()
+		url, ok := fs.m[alias]
+		if ok {
+			url = fs.withClicks([]models.ShrURL{url})[0]
+		}
+		fs.mtx.RUnlock()
+		// url is purged while walking
+		if !ok {
+			continue
+		}
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// isURLExist checks existing url
-func (fs *FileStorage) isURLExist(url string) bool {
-	// does the url exist?
-	for _, v := range fs.m {
-		if strings.Compare(v, url) == 0 {
-			// url exist
-			return true
+// ListUserURLsCtx returns page of user URLs matching the query
+func (fs *FileStorage) ListUserURLsCtx(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	aliases := fs.muser[q.UserID]
+	urls := make([]models.ShrURL, 0, len(aliases))
+	for _, alias := range aliases {
+		urls = append(urls, fs.m[alias])
+	}
+	return listURLs(fs.withClicks(urls), q)
+}
+
+// UpdateURLTagsCtx adds and removes tags of user's url
+func (fs *FileStorage) UpdateURLTagsCtx(ctx context.Context, userID string, alias string, add []string, remove []string) ([]string, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	cur, ok := fs.m[alias]
+	if !ok {
+		return nil, ErrURLNotFound
+	}
+	upd, err := updateTags(cur, userID, add, remove)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fs.writeRecords([]urlChange{{upd, newURLEvent(models.ActionTags, upd, upd.URL)}}); err != nil {
+		return nil, err
+	}
+	fs.m[alias] = upd
+
+	return upd.Tags, nil
+}
+
+// GetUserTagsCtx returns tags of user URLs with number of URLs
+func (fs *FileStorage) GetUserTagsCtx(ctx context.Context, userID string) ([]models.TagCount, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	return countTags(fs.m, fs.muser[userID]), nil
+}
+
+// SearchUserURLsCtx returns user URLs matching the query
+func (fs *FileStorage) SearchUserURLsCtx(ctx context.Context, userID string, query string, limit int) ([]models.ShrURL, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	urls, err := searchURLs(fs.search, fs.m, userID, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return fs.withClicks(urls), nil
+}
+
+// UpdateURLCtx updates destination and options of user's url,
+// updated url is appended to the file as a new record of the alias
+func (fs *FileStorage) UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	cur, ok := fs.m[url.Alias]
+	if !ok {
+		return models.ShrURL{}, ErrURLNotFound
+	}
+	if cur.URL != url.URL && fs.isURLExist(url.URL) {
+		return models.ShrURL{}, ErrURLExists
+	}
+
+	upd, err := updateURL(cur, url, version)
+	if err != nil {
+		return models.ShrURL{}, err
+	}
+
+	if err := fs.writeRecords([]urlChange{{upd, newURLEvent(models.ActionUpdate, upd, cur.URL)}}); err != nil {
+		return models.ShrURL{}, err
+	}
+	fs.m[url.Alias] = upd
+	indexURL(fs.murl, cur, upd)
+	fs.search.add(upd)
+	fs.pruneVariantClicks(upd)
+
+	return fs.withClicks([]models.ShrURL{upd})[0], nil
+}
+
+// urlChange is url changed by event
+type urlChange struct {
+	url   models.ShrURL
+	event models.URLEvent
+}
+
+// writeRecords appends records of changed urls to the end of file by one write
+// and adds events to url history. If writing fails, the file is truncated back,
+// so either all records are written or none of them. Callers update urls after records are written.
+func (fs *FileStorage) writeRecords(changes []urlChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for i, c := range changes {
+		nr := urlToRecord(c.url)
+		nr.UUID = strconv.Itoa(fs.index + i + 1)
+		nr.Action = c.event.Action
+		nr.Actor = c.event.UserID
+		nr.Time = c.event.Time
+
+		if err := fs.rec.WriteRecord(&buf, &nr); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(fs.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		// partially written record would break loading of the file
+		_ = file.Truncate(info.Size())
+		return err
+	}
+
+	fs.index += len(changes)
+	for _, c := range changes {
+		fs.history[c.url.Alias] = append(fs.history[c.url.Alias], c.event)
+	}
+	return nil
+}
+
+// DeleteUserURLsCtx deletes user URLs
+func (fs *FileStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	failed := make(map[string]error)
+	changes := make([]urlChange, 0, len(aliases))
+	seen := make(map[string]struct{}, len(aliases))
+	for _, alias := range aliases {
+		url, ok := fs.m[alias]
+		if err := checkDelete(url, ok, userID); err != nil {
+			failed[alias] = err
+			continue
+		}
+		if _, ok := seen[alias]; ok || url.Deleted {
+			continue
+		}
+		seen[alias] = struct{}{}
+		url.Deleted = true
+		url.DeletedAt = time.Now()
+		changes = append(changes, urlChange{url, newURLEvent(models.ActionDelete, url, url.URL)})
+	}
+
+	if err := fs.writeRecords(changes); err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		indexURL(fs.murl, fs.m[c.url.Alias], c.url)
+		fs.m[c.url.Alias] = c.url
+	}
+
+	return failed, nil
+}
+
+// RestoreUserURLsCtx restores deleted user URLs
+func (fs *FileStorage) RestoreUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	failed := make(map[string]error)
+	changes := make([]urlChange, 0, len(aliases))
+	// destinations restored by the call
+	restored := make(map[string]struct{}, len(aliases))
+	seen := make(map[string]struct{}, len(aliases))
+	for _, alias := range aliases {
+		if _, ok := seen[alias]; ok {
+			continue
+		}
+		seen[alias] = struct{}{}
+		url, ok := fs.m[alias]
+		if err := checkRestore(url, ok, userID); err != nil {
+			failed[alias] = err
+			continue
+		}
+		// the url may be shortened again after deletion
+		if _, ok := restored[url.URL]; ok || fs.isURLExist(url.URL) {
+			failed[alias] = ErrURLExists
+			continue
 		}
+		restored[url.URL] = struct{}{}
+		url.Deleted = false
+		url.DeletedAt = time.Time{}
+		changes = append(changes, urlChange{url, newURLEvent(models.ActionRestore, url, url.URL)})
+	}
+
+	if err := fs.writeRecords(changes); err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		indexURL(fs.murl, fs.m[c.url.Alias], c.url)
+		fs.m[c.url.Alias] = c.url
+	}
+
+	return failed, nil
+}
+
+// TransferUserURLsCtx reassigns all user URLs to another user
+func (fs *FileStorage) TransferUserURLsCtx(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if fromUserID == toUserID {
+		return 0, nil
+	}
+	changes := make([]urlChange, 0, len(fs.muser[fromUserID]))
+	for _, alias := range fs.muser[fromUserID] {
+		url := fs.m[alias]
+		url.UserID = toUserID
+		changes = append(changes, urlChange{url, newURLEvent(models.ActionTransfer, url, url.URL)})
+	}
+
+	if err := fs.writeRecords(changes); err != nil {
+		return 0, err
+	}
+	aliases := transferAliases(fs.m, fs.muser, fromUserID, toUserID)
+	for _, c := range changes {
+		fs.m[c.url.Alias] = c.url
+		fs.search.add(c.url)
+	}
+
+	return len(aliases), nil
+}
+
+// PurgeDeletedURLsCtx removes URLs deleted before the time.
+// Purge records are appended and the file is compacted, so only history of removed URLs is kept.
+func (fs *FileStorage) PurgeDeletedURLsCtx(ctx context.Context, before time.Time) (int, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	purged := deletedBefore(fs.m, before)
+	if len(purged) == 0 {
+		return 0, nil
+	}
+
+	changes := make([]urlChange, 0, len(purged))
+	for _, alias := range purged {
+		url := fs.m[alias]
+		changes = append(changes, urlChange{url, newURLEvent(models.ActionPurge, url, url.URL)})
+	}
+	if err := fs.writeRecords(changes); err != nil {
+		return 0, err
+	}
+	for _, alias := range purged {
+		fs.search.remove(alias)
+	}
+	removeURLs(fs.m, fs.muser, fs.murl, purged)
+
+	if err := fs.compactRecords(); err != nil {
+		return 0, err
+	}
+
+	// clicks of purged urls are not counted for alias used again
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
+	for _, alias := range purged {
+		delete(fs.clicks, alias)
+	}
+	if fs.clickRecords > 0 {
+		if err := fs.compactClicks(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), nil
+}
+
+// compactRecords rewrites the file dropping content of purged URLs.
+// Records of alias up to its purge keep only fields of URL history.
+func (fs *FileStorage) compactRecords() error {
+	file, err := os.Open(fs.fileName)
+	if err != nil {
+		return err
+	}
+	recs, err := fs.rec.ReadAllRecords(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	// alias may be used again after purge, so records are checked from the latest one
+	purged := make(map[string]struct{})
+	for i := len(recs) - 1; i >= 0; i-- {
+		r := recs[i]
+		if r.Action == models.ActionPurge {
+			purged[r.ShortURL] = struct{}{}
+		}
+		if _, ok := purged[r.ShortURL]; !ok {
+			continue
+		}
+		recs[i] = recorder.Record{
+			UUID:        r.UUID,
+			ShortURL:    r.ShortURL,
+			OriginalURL: r.OriginalURL,
+			UserID:      r.UserID,
+			Deleted:     r.Deleted,
+			CreatedAt:   r.CreatedAt,
+			Action:      r.Action,
+			Actor:       r.Actor,
+			Time:        r.Time,
+		}
+	}
+
+	return replaceFile(fs.fileName, 0666, func(w io.Writer) error {
+		return fs.rec.WriteAllRecords(w, recs)
+	})
+}
+
+// GetURLHistoryCtx returns events of url
+func (fs *FileStorage) GetURLHistoryCtx(ctx context.Context, alias string) ([]models.URLEvent, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	events, ok := fs.history[alias]
+	if !ok {
+		return nil, ErrURLNotFound
+	}
+	return append([]models.URLEvent(nil), events...), nil
+}
+
+// pruneVariantClicks drops clicks of variants removed from url,
+// so variant added again with the same name is counted from zero
+func (fs *FileStorage) pruneVariantClicks(url models.ShrURL) {
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
+
+	c, ok := fs.clicks[url.Alias]
+	if !ok {
+		return
+	}
+	for name := range c.variants {
+		if !slices.ContainsFunc(url.Variants, func(v models.Variant) bool { return v.Name == name }) {
+			delete(c.variants, name)
+		}
+	}
+}
+
+// RegisterClickCtx counts redirect by alias to variant.
+// Click is appended to clicks file, the file is compacted after every clicksCompactEvery clicks.
+// Urls are locked for reading only, clicks are counted under clicksMtx.
+func (fs *FileStorage) RegisterClickCtx(ctx context.Context, alias string, variant string) (models.Click, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	url, ok := fs.m[alias]
+	if !ok {
+		return models.Click{}, ErrURLNotFound
+	}
+
+	fs.clicksMtx.Lock()
+	defer fs.clicksMtx.Unlock()
+
+	if fs.clickRecords-fs.clickSnapshot >= clicksCompactEvery {
+		if err := fs.compactClicks(); err != nil {
+			return models.Click{}, err
+		}
+	}
+
+	if fs.clicksFile == nil {
+		file, err := os.OpenFile(fs.clicksFileName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return models.Click{}, err
+		}
+		fs.clicksFile = file
+	}
+	if err := fs.rec.WriteClickRecord(fs.clicksFile, &recorder.ClickRecord{ShortURL: alias, Variant: variant, Clicks: 1}); err != nil {
+		return models.Click{}, err
+	}
+	fs.clickRecords++
+
+	c := fs.clicks[alias].add(variant, 1)
+	fs.clicks[alias] = c
+
+	return newClick(c.apply(url)), nil
+}
+
+// isURLExist checks existing url, deleted urls are not taken into account
+func (fs *FileStorage) isURLExist(url string) bool {
+	_, ok := fs.murl[url]
+	return ok
+}
+
+// urlToRecord converts url to file record
+func urlToRecord(url models.ShrURL) recorder.Record {
+	rec := recorder.Record{
+		ShortURL:    url.Alias,
+		OriginalURL: url.URL,
+		UserID:      url.UserID,
+		Version:     url.Version,
+		Deleted:     url.Deleted,
+		Title:       url.Title,
+		Note:        url.Note,
+		Tags:        url.Tags,
+	}
+	if !url.DeletedAt.IsZero() {
+		deletedAt := url.DeletedAt
+		rec.DeletedAt = &deletedAt
+	}
+	if !url.CreatedAt.IsZero() {
+		createdAt := url.CreatedAt
+		rec.CreatedAt = &createdAt
+	}
+	if !url.UTM.IsEmpty() {
+		rec.UTM = &recorder.UTM{
+			Source:   url.UTM.Source,
+			Medium:   url.UTM.Medium,
+			Campaign: url.UTM.Campaign,
+			Content:  url.UTM.Content,
+		}
+	}
+	for _, r := range url.Rules {
+		rec.Rules = append(rec.Rules, recorder.Rule{Platform: r.Platform, URL: r.URL})
+	}
+	for _, v := range url.Variants {
+		rec.Variants = append(rec.Variants, recorder.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	return rec
+}
+
+// recordToURL converts file record to url
+func recordToURL(rec recorder.Record) models.ShrURL {
+	url := models.ShrURL{
+		Alias:   rec.ShortURL,
+		URL:     rec.OriginalURL,
+		UserID:  rec.UserID,
+		Version: rec.Version,
+		Deleted: rec.Deleted,
+		Title:   rec.Title,
+		Note:    rec.Note,
+		Tags:    rec.Tags,
+	}
+	if rec.DeletedAt != nil {
+		url.DeletedAt = *rec.DeletedAt
+	}
+	if rec.CreatedAt != nil {
+		url.CreatedAt = *rec.CreatedAt
+	}
+	// records written before versioning
+	if url.Version == 0 {
+		url.Version = 1
+	}
+	if rec.UTM != nil {
+		url.UTM = models.UTM{
+			Source:   rec.UTM.Source,
+			Medium:   rec.UTM.Medium,
+			Campaign: rec.UTM.Campaign,
+			Content:  rec.UTM.Content,
+		}
+	}
+	for _, r := range rec.Rules {
+		url.Rules = append(url.Rules, models.RedirectRule{Platform: r.Platform, URL: r.URL})
+	}
+	for _, v := range rec.Variants {
+		url.Variants = append(url.Variants, models.Variant{Name: v.Name, URL: v.URL, Weight: v.Weight})
+	}
+	return url
+}
+
+// recordToEvent returns event written the record,
+// prevURL is destination of the previous record of alias
+func recordToEvent(rec recorder.Record, url models.ShrURL, prevURL string) models.URLEvent {
+	event := models.URLEvent{
+		Alias:   url.Alias,
+		Action:  rec.Action,
+		UserID:  rec.Actor,
+		URL:     url.URL,
+		PrevURL: prevURL,
+		Time:    rec.Time,
+	}
+	// records written before history
+	if event.Action == "" {
+		event.Action = models.ActionCreate
+		if prevURL != "" {
+			event.Action = models.ActionUpdate
+		}
+	}
+	if event.UserID == "" {
+		event.UserID = url.UserID
+	}
+	return event
+}
+
+// CreateUserCtx stores account and writes it to users file
+func (fs *FileStorage) CreateUserCtx(ctx context.Context, user models.User) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.logins[user.Login]; ok {
+		return ErrLoginExists
+	}
+
+	file, err := os.OpenFile(fs.usersFileName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = fs.rec.WriteUserRecord(file, &recorder.UserRecord{
+		ID:           user.ID,
+		Login:        user.Login,
+		PasswordHash: user.PasswordHash,
+		CreatedAt:    user.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.users[user.ID] = user
+	fs.logins[user.Login] = user.ID
+	return nil
+}
+
+// GetUserCtx returns account by ID
+func (fs *FileStorage) GetUserCtx(ctx context.Context, id string) (models.User, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	user, ok := fs.users[id]
+	if !ok {
+		return models.User{}, ErrAccountNotFound
+	}
+	return user, nil
+}
+
+// GetUserByLoginCtx returns account by login
+func (fs *FileStorage) GetUserByLoginCtx(ctx context.Context, login string) (models.User, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	user, ok := fs.users[fs.logins[login]]
+	if !ok {
+		return models.User{}, ErrAccountNotFound
+	}
+	return user, nil
+}
+
+// loadWebhooks loads webhooks with their dead letters from webhooks file
+func (fs *FileStorage) loadWebhooks() error {
+	fs.webhooks = make(map[string]models.Webhook)
+	fs.deadLetters = make(map[string][]models.WebhookDeadLetter)
+
+	file, err := os.Open(fs.webhooksFileName())
+	// no webhook is registered yet
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	recs, err := fs.rec.ReadAllWebhookRecords(file)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		fs.webhooks[r.ID] = models.Webhook{
+			ID:        r.ID,
+			UserID:    r.UserID,
+			URL:       r.URL,
+			Secret:    r.Secret,
+			Events:    r.Events,
+			CreatedAt: r.CreatedAt,
+		}
+		for _, dl := range r.DeadLetters {
+			fs.deadLetters[r.ID] = append(fs.deadLetters[r.ID], models.WebhookDeadLetter{
+				HookID:    r.ID,
+				EventID:   dl.EventID,
+				EventType: dl.EventType,
+				Alias:     dl.Alias,
+				URL:       dl.URL,
+				EventTime: dl.EventTime,
+				Attempts:  dl.Attempts,
+				LastError: dl.LastError,
+				Time:      dl.Time,
+			})
+		}
+	}
+	return nil
+}
+
+// writeWebhooks rewrites webhooks file with the given webhooks and dead letters
+func (fs *FileStorage) writeWebhooks(hooks map[string]models.Webhook, letters map[string][]models.WebhookDeadLetter) error {
+	recs := make([]recorder.WebhookRecord, 0, len(hooks))
+	for _, hook := range sortedWebhooks(hooks) {
+		rec := recorder.WebhookRecord{
+			ID:        hook.ID,
+			UserID:    hook.UserID,
+			URL:       hook.URL,
+			Secret:    hook.Secret,
+			Events:    hook.Events,
+			CreatedAt: hook.CreatedAt,
+		}
+		for _, dl := range letters[hook.ID] {
+			rec.DeadLetters = append(rec.DeadLetters, recorder.DeadLetterRecord{
+				EventID:   dl.EventID,
+				EventType: dl.EventType,
+				Alias:     dl.Alias,
+				URL:       dl.URL,
+				EventTime: dl.EventTime,
+				Attempts:  dl.Attempts,
+				LastError: dl.LastError,
+				Time:      dl.Time,
+			})
+		}
+		recs = append(recs, rec)
+	}
+
+	// webhooks file keeps secrets of webhooks
+	return replaceFile(fs.webhooksFileName(), 0600, func(w io.Writer) error {
+		return fs.rec.WriteAllWebhookRecords(w, recs)
+	})
+}
+
+// replaceFile writes file with permissions perm.
+// Content is written to temporary file which replaces the file.
+func replaceFile(name string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// CreateWebhookCtx stores webhook and writes it to webhooks file
+func (fs *FileStorage) CreateWebhookCtx(ctx context.Context, hook models.Webhook) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	hooks := maps.Clone(fs.webhooks)
+	hooks[hook.ID] = hook
+	if err := fs.writeWebhooks(hooks, fs.deadLetters); err != nil {
+		return err
+	}
+	fs.webhooks = hooks
+	return nil
+}
+
+// ListWebhooksCtx returns webhooks of all users ordered by creation time
+func (fs *FileStorage) ListWebhooksCtx(ctx context.Context) ([]models.Webhook, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	return sortedWebhooks(fs.webhooks), nil
+}
+
+// DeleteWebhookCtx removes webhook with its dead letters from webhooks file
+func (fs *FileStorage) DeleteWebhookCtx(ctx context.Context, id string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	hooks := maps.Clone(fs.webhooks)
+	delete(hooks, id)
+	letters := maps.Clone(fs.deadLetters)
+	delete(letters, id)
+	if err := fs.writeWebhooks(hooks, letters); err != nil {
+		return err
+	}
+	fs.webhooks = hooks
+	fs.deadLetters = letters
+	return nil
+}
+
+// AddWebhookDeadLetterCtx stores event not delivered to webhook, the oldest dead letters are dropped
+func (fs *FileStorage) AddWebhookDeadLetterCtx(ctx context.Context, letter models.WebhookDeadLetter, keep int) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.webhooks[letter.HookID]; !ok {
+		return ErrWebhookNotFound
+	}
+	letters := maps.Clone(fs.deadLetters)
+	letters[letter.HookID] = appendDeadLetter(slices.Clone(letters[letter.HookID]), letter, keep)
+	if err := fs.writeWebhooks(fs.webhooks, letters); err != nil {
+		return err
+	}
+	fs.deadLetters = letters
+	return nil
+}
+
+// ListWebhookDeadLettersCtx returns dead letters of webhook, the latest goes first
+func (fs *FileStorage) ListWebhookDeadLettersCtx(ctx context.Context, hookID string) ([]models.WebhookDeadLetter, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	letters := slices.Clone(fs.deadLetters[hookID])
+	slices.Reverse(letters)
+	return letters, nil
+}
+
+// loadAPIKeys loads API keys from API keys file
+func (fs *FileStorage) loadAPIKeys() error {
+	fs.apiKeys = make(map[string]models.APIKey)
+
+	file, err := os.Open(fs.apiKeysFileName())
+	// no API key is issued yet
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	recs, err := fs.rec.ReadAllAPIKeyRecords(file)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		key := models.APIKey{
+			ID:        r.ID,
+			UserID:    r.UserID,
+			Name:      r.Name,
+			Prefix:    r.Prefix,
+			Hash:      r.Hash,
+			Scopes:    r.Scopes,
+			CreatedAt: r.CreatedAt,
+		}
+		if r.ExpiresAt != nil {
+			key.ExpiresAt = *r.ExpiresAt
+		}
+		fs.apiKeys[r.ID] = key
+	}
+	return nil
+}
+
+// writeAPIKeys rewrites API keys file with the given keys
+func (fs *FileStorage) writeAPIKeys(keys map[string]models.APIKey) error {
+	recs := make([]recorder.APIKeyRecord, 0, len(keys))
+	for _, key := range sortedAPIKeys(keys) {
+		rec := recorder.APIKeyRecord{
+			ID:        key.ID,
+			UserID:    key.UserID,
+			Name:      key.Name,
+			Prefix:    key.Prefix,
+			Hash:      key.Hash,
+			Scopes:    key.Scopes,
+			CreatedAt: key.CreatedAt,
+		}
+		if !key.ExpiresAt.IsZero() {
+			rec.ExpiresAt = &key.ExpiresAt
+		}
+		recs = append(recs, rec)
+	}
+
+	return replaceFile(fs.apiKeysFileName(), 0600, func(w io.Writer) error {
+		return fs.rec.WriteAllAPIKeyRecords(w, recs)
+	})
+}
+
+// CreateAPIKeyCtx stores API key and writes it to API keys file
+func (fs *FileStorage) CreateAPIKeyCtx(ctx context.Context, key models.APIKey) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	keys := maps.Clone(fs.apiKeys)
+	keys[key.ID] = key
+	if err := fs.writeAPIKeys(keys); err != nil {
+		return err
+	}
+	fs.apiKeys = keys
+	return nil
+}
+
+// ListAPIKeysCtx returns API keys of all users ordered by creation time
+func (fs *FileStorage) ListAPIKeysCtx(ctx context.Context) ([]models.APIKey, error) {
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	return sortedAPIKeys(fs.apiKeys), nil
+}
+
+// DeleteAPIKeyCtx removes API key from API keys file
+func (fs *FileStorage) DeleteAPIKeyCtx(ctx context.Context, id string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.apiKeys[id]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	keys := maps.Clone(fs.apiKeys)
+	delete(keys, id)
+	if err := fs.writeAPIKeys(keys); err != nil {
+		return err
+	}
+	fs.apiKeys = keys
+	return nil
+}
+
+// loadRevocations loads revocations of auth tokens from revocations file
+func (fs *FileStorage) loadRevocations() error {
+	fs.revocations = make(map[string]models.Revocation)
+	fs.revocationRecords = 0
+
+	file, err := os.Open(fs.revocationsFileName())
+	// no token is revoked yet
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	recs, err := fs.rec.ReadAllRevocationRecords(file)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range recs {
+		rev := models.Revocation{SessionID: r.SessionID, UserID: r.UserID, Time: r.Time, Until: r.Until}
+		fs.revocations[revocationKey(rev)] = rev
+	}
+	fs.revocationRecords = len(recs)
+	return nil
+}
+
+// RevokeCtx stores revocation of auth tokens and appends it to revocations file
+func (fs *FileStorage) RevokeCtx(ctx context.Context, rev models.Revocation) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	file, err := os.OpenFile(fs.revocationsFileName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = fs.rec.WriteRevocationRecord(file, &recorder.RevocationRecord{
+		SessionID: rev.SessionID,
+		UserID:    rev.UserID,
+		Time:      rev.Time,
+		Until:     rev.Until,
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.revocations[revocationKey(rev)] = rev
+	fs.revocationRecords++
+	return nil
+}
+
+// ListRevocationsCtx returns revocations not expired at now.
+// Revocations file is rewritten without expired and replaced records.
+func (fs *FileStorage) ListRevocationsCtx(ctx context.Context, now time.Time) ([]models.Revocation, error) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	pruneRevocations(fs.revocations, now)
+	revs := slices.Collect(maps.Values(fs.revocations))
+	if fs.revocationRecords == len(revs) {
+		return revs, nil
+	}
+
+	recs := make([]recorder.RevocationRecord, 0, len(revs))
+	for _, rev := range revs {
+		recs = append(recs, recorder.RevocationRecord{
+			SessionID: rev.SessionID,
+			UserID:    rev.UserID,
+			Time:      rev.Time,
+			Until:     rev.Until,
+		})
+	}
+	err := replaceFile(fs.revocationsFileName(), 0600, func(w io.Writer) error {
+		return fs.rec.WriteAllRevocationRecords(w, recs)
+	})
+	if err != nil {
+		return nil, err
 	}
-	return false
+	fs.revocationRecords = len(recs)
+	return revs, nil
 }
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rookgm/shortener/internal/models"
+)
+
+// weights of url fields in search ranking
+const (
+	weightTitle = 3
+	weightNote  = 2
+	weightURL   = 1
+)
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexedURL is url tokens kept to remove url from index
+type indexedURL struct {
+	userID string
+	tokens []string
+}
+
+// searchIndex is inverted index of user urls used by in-process storages.
+// It is not safe for concurrent use, storage lock guards it.
+type searchIndex struct {
+	// weights of aliases grouped by user and token
+	tokens map[string]map[string]map[string]int
+	urls   map[string]indexedURL
+}
+
+// newSearchIndex creates empty search index
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		tokens: make(map[string]map[string]map[string]int),
+		urls:   make(map[string]indexedURL),
+	}
+}
+
+// add indexes url, previously indexed url with the same alias is replaced
+func (idx *searchIndex) add(url models.ShrURL) {
+	idx.remove(url.Alias)
+
+	weights := make(map[string]int)
+	for _, f := range []struct {
+		text   string
+		weight int
+	}{
+		{url.Title, weightTitle},
+		{url.Note, weightNote},
+		{url.URL, weightURL},
+	} {
+		for _, t := range tokenize(f.text) {
+			weights[t] = max(weights[t], f.weight)
+		}
+	}
+
+	userTokens, ok := idx.tokens[url.UserID]
+	if !ok {
+		userTokens = make(map[string]map[string]int)
+		idx.tokens[url.UserID] = userTokens
+	}
+	doc := indexedURL{userID: url.UserID, tokens: make([]string, 0, len(weights))}
+	for t, w := range weights {
+		if userTokens[t] == nil {
+			userTokens[t] = make(map[string]int)
+		}
+		userTokens[t][url.Alias] = w
+		doc.tokens = append(doc.tokens, t)
+	}
+	idx.urls[url.Alias] = doc
+}
+
+// remove removes url from index
+func (idx *searchIndex) remove(alias string) {
+	doc, ok := idx.urls[alias]
+	if !ok {
+		return
+	}
+	userTokens := idx.tokens[doc.userID]
+	for _, t := range doc.tokens {
+		delete(userTokens[t], alias)
+		if len(userTokens[t]) == 0 {
+			delete(userTokens, t)
+		}
+	}
+	if len(userTokens) == 0 {
+		delete(idx.tokens, doc.userID)
+	}
+	delete(idx.urls, alias)
+}
+
+// search returns scores of user aliases having tokens starting with every query term
+func (idx *searchIndex) search(userID string, terms []string) map[string]int {
+	var scores map[string]int
+	for _, term := range terms {
+		// the best weight of alias tokens matching the term
+		matched := make(map[string]int)
+		for t, aliases := range idx.tokens[userID] {
+			if !strings.HasPrefix(t, term) {
+				continue
+			}
+			for alias, w := range aliases {
+				matched[alias] = max(matched[alias], w)
+			}
+		}
+		if scores == nil {
+			scores = matched
+			continue
+		}
+		for alias, score := range scores {
+			if w, ok := matched[alias]; ok {
+				scores[alias] = score + w
+			} else {
+				delete(scores, alias)
+			}
+		}
+	}
+	return scores
+}
+
+// searchURLs searches user urls in index of in-process storage
+func searchURLs(idx *searchIndex, m map[string]models.ShrURL, userID string, query string, limit int) ([]models.ShrURL, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+
+	scores := idx.search(userID, terms)
+	urls := make([]models.ShrURL, 0, len(scores))
+	for alias := range scores {
+		if url, ok := m[alias]; ok && !url.Deleted {
+			urls = append(urls, url)
+		}
+	}
+
+	// the best matches go first, newer urls are preferred
+	sort.Slice(urls, func(i, j int) bool {
+		si, sj := scores[urls[i].Alias], scores[urls[j].Alias]
+		if si != sj {
+			return si > sj
+		}
+		if !urls[i].CreatedAt.Equal(urls[j].CreatedAt) {
+			return urls[i].CreatedAt.After(urls[j].CreatedAt)
+		}
+		return urls[i].Alias > urls[j].Alias
+	})
+
+	limit = listLimit(models.UserURLsQuery{Limit: limit})
+	if len(urls) > limit {
+		urls = urls[:limit]
+	}
+	return urls, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rookgm/shortener/internal/models"
+)
+
+// listing limits
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listLimit returns page size of the query
+func listLimit(q models.UserURLsQuery) int {
+	switch {
+	case q.Limit <= 0:
+		return defaultListLimit
+	case q.Limit > maxListLimit:
+		return maxListLimit
+	}
+	return q.Limit
+}
+
+// urlDomain returns lowercase host of url destination
+func urlDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// sortKey returns value urls are sorted by.
+// Creation time is taken in microseconds as it is stored in db.
+func sortKey(url models.ShrURL, sortBy string) int64 {
+	if sortBy == models.SortByClicks {
+		return url.Clicks
+	}
+	return url.CreatedAt.UnixMicro()
+}
+
+// encodeCursor returns cursor pointing after url with the sort key
+func encodeCursor(key int64, alias string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10) + ":" + alias))
+}
+
+// decodeCursor returns sort key and alias of the cursor
+func decodeCursor(cursor string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	k, alias, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return 0, "", ErrInvalidCursor
+	}
+	key, err := strconv.ParseInt(k, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return key, alias, nil
+}
+
+// listURLs filters, sorts and paginates user urls of in-process storages
+// the same way as db does it
+func listURLs(urls []models.ShrURL, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+	var (
+		after      bool
+		afterKey   int64
+		afterAlias string
+	)
+	if q.Cursor != "" {
+		var err error
+		if afterKey, afterAlias, err = decodeCursor(q.Cursor); err != nil {
+			return nil, "", err
+		}
+		after = true
+	}
+	domain := strings.ToLower(q.Domain)
+
+	res := make([]models.ShrURL, 0, len(urls))
+	for _, url := range urls {
+		switch {
+		case url.Deleted && !q.IncludeDeleted:
+			continue
+		case domain != "" && urlDomain(url.URL) != domain:
+			continue
+		case !q.CreatedAfter.IsZero() && !url.CreatedAt.After(q.CreatedAfter):
+			continue
+		case !q.CreatedBefore.IsZero() && !url.CreatedAt.Before(q.CreatedBefore):
+			continue
+		case q.Tag != "" && !slices.Contains(url.Tags, q.Tag):
+			continue
+		}
+		if after {
+			// keyset: (key, alias) < (afterKey, afterAlias)
+			key := sortKey(url, q.SortBy)
+			if key > afterKey || key == afterKey && url.Alias >= afterAlias {
+				continue
+			}
+		}
+		res = append(res, url)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		ki, kj := sortKey(res[i], q.SortBy), sortKey(res[j], q.SortBy)
+		if ki != kj {
+			return ki > kj
+		}
+		return res[i].Alias > res[j].Alias
+	})
+
+	limit := listLimit(q)
+	if len(res) <= limit {
+		return res, "", nil
+	}
+	res = res[:limit]
+	last := res[limit-1]
+	return res, encodeCursor(sortKey(last, q.SortBy), last.Alias), nil
+}
@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
-	"strings"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/rookgm/shortener/internal/models"
 )
@@ -11,16 +13,45 @@ import (
 // MemStorage is storage based on gomap
 type MemStorage struct {
 	mu sync.RWMutex
-	m  map[string]string
-	// user urls grouped by uid
-	muser map[string][]models.ShrURL
+	// urls grouped by alias
+	m map[string]models.ShrURL
+	// user aliases grouped by uid
+	muser map[string][]string
+	// aliases of not deleted urls grouped by destination
+	murl map[string]string
+	// url events grouped by alias
+	history map[string][]models.URLEvent
+	// search index of urls
+	search *searchIndex
+	// accounts grouped by ID
+	users map[string]models.User
+	// account IDs grouped by login
+	logins map[string]string
+	// webhooks grouped by ID
+	webhooks map[string]models.Webhook
+	// dead letters grouped by webhook ID in the order they were added
+	deadLetters map[string][]models.WebhookDeadLetter
+	// API keys grouped by ID
+	apiKeys map[string]models.APIKey
+	// revocations of auth tokens grouped by revoked session or user
+	revocations map[string]models.Revocation
 }
 
 // NewMemStorage creates a new storage in memory
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		m:     make(map[string]string),
-		muser: make(map[string][]models.ShrURL),
+		m:       make(map[string]models.ShrURL),
+		muser:   make(map[string][]string),
+		murl:    make(map[string]string),
+		history: make(map[string][]models.URLEvent),
+		search:  newSearchIndex(),
+		users:   make(map[string]models.User),
+		logins:  make(map[string]string),
+
+		webhooks:    make(map[string]models.Webhook),
+		deadLetters: make(map[string][]models.WebhookDeadLetter),
+		apiKeys:     make(map[string]models.APIKey),
+		revocations: make(map[string]models.Revocation),
 	}
 }
 
@@ -35,32 +66,70 @@ func (ms *MemStorage) StoreURLCtx(ctx context.Context, url models.ShrURL) error
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	if _, ok := ms.m[url.Alias]; ok {
+		return ErrAliasExists
+	}
 	if ms.isURLExist(url.URL) {
 		return ErrURLExists
 	}
+	url.Version = 1
+	url.CreatedAt = time.Now()
 	// put url
-	ms.m[url.Alias] = url.URL
+	ms.m[url.Alias] = url
+	indexURL(ms.murl, models.ShrURL{}, url)
 	// put user url
-	ms.muser[url.UserID] = append(ms.muser[url.UserID], url)
+	ms.muser[url.UserID] = append(ms.muser[url.UserID], url.Alias)
+	ms.search.add(url)
+	ms.addEvent(newURLEvent(models.ActionCreate, url, ""))
 	return nil
 }
 
 // StoreBatchURLCtx stores batch urls
-func (ms *MemStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) error {
+func (ms *MemStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	results := make([]models.BatchResult, 0, len(urls))
 	for _, url := range urls {
-		if ms.isURLExist(url.URL) {
-			// url exists
+		if res, ok := checkBatchURL(ms.m, ms.murl, url); !ok {
+			results = append(results, res)
 			continue
 		}
+		url.Version = 1
+		url.CreatedAt = time.Now()
 		// put url
-		ms.m[url.Alias] = url.URL
+		ms.m[url.Alias] = url
+		indexURL(ms.murl, models.ShrURL{}, url)
 		// put user url
-		ms.muser[url.UserID] = append(ms.muser[url.UserID], url)
+		ms.muser[url.UserID] = append(ms.muser[url.UserID], url.Alias)
+		ms.search.add(url)
+		ms.addEvent(newURLEvent(models.ActionCreate, url, ""))
+		results = append(results, models.BatchResult{Status: models.BatchCreated, Alias: url.Alias})
+	}
+	return results, nil
+}
+
+// checkBatchURL reports whether batch url may be stored in in-process storage,
+// otherwise it returns outcome of the url
+func checkBatchURL(m map[string]models.ShrURL, murl map[string]string, url models.ShrURL) (models.BatchResult, bool) {
+	if alias, ok := murl[url.URL]; ok {
+		return models.BatchResult{Status: models.BatchExists, Alias: alias}, false
+	}
+	if _, ok := m[url.Alias]; ok {
+		return models.BatchResult{Status: models.BatchRejected, Reason: ErrAliasExists.Error()}, false
+	}
+	return models.BatchResult{}, true
+}
+
+// indexURL updates index of not deleted urls after url is changed from prev,
+// prev is zero for new url
+func indexURL(murl map[string]string, prev models.ShrURL, url models.ShrURL) {
+	if prev.Alias != "" && !prev.Deleted && murl[prev.URL] == prev.Alias {
+		delete(murl, prev.URL)
+	}
+	if url.Alias != "" && !url.Deleted {
+		murl[url.URL] = url.Alias
 	}
-	return nil
 }
 
 // GetURLCtx is return ShrURL by alias
@@ -71,7 +140,7 @@ func (ms *MemStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrUR
 	if !ok {
 		return models.ShrURL{}, ErrURLNotFound
 	}
-	return models.ShrURL{Alias: alias, URL: url}, nil
+	return url, nil
 }
 
 // GetAliasCtx returns stored alias by url
@@ -79,10 +148,8 @@ func (ms *MemStorage) GetURLCtx(ctx context.Context, alias string) (models.ShrUR
 func (ms *MemStorage) GetAliasCtx(ctx context.Context, url string) (models.ShrURL, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
-	for k, v := range ms.m {
-		if strings.Compare(v, url) == 0 {
-			return models.ShrURL{Alias: k, URL: v}, nil
-		}
+	if alias, ok := ms.murl[url]; ok {
+		return models.ShrURL{Alias: alias, URL: url}, nil
 	}
 	return models.ShrURL{}, ErrAliasNotFound
 }
@@ -91,28 +158,551 @@ func (ms *MemStorage) GetAliasCtx(ctx context.Context, url string) (models.ShrUR
 func (ms *MemStorage) GetUserURLsCtx(ctx context.Context, userID string) ([]models.ShrURL, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
-	urls, ok := ms.muser[userID]
+	aliases, ok := ms.muser[userID]
 	if !ok {
 		return nil, ErrUserNotFound
 	}
 
+	urls := make([]models.ShrURL, 0, len(aliases))
+	for _, alias := range aliases {
+		urls = append(urls, ms.m[alias])
+	}
+
 	return urls, nil
 }
 
+// WalkUserURLsCtx calls fn for every user URL.
+// Storage is not locked while fn is called, so fn may be slow.
+func (ms *MemStorage) WalkUserURLsCtx(ctx context.Context, userID string, fn func(url models.ShrURL) error) error {
+	ms.mu.RLock()
+	aliases := append([]string(nil), ms.muser[userID]...)
+	ms.mu.RUnlock()
+
+	for _, alias := range aliases {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ms.mu.RLock()
+		url, ok := ms.m[alias]
+		ms.mu.RUnlock()
+		// url is purged while walking
+		if !ok {
+			continue
+		}
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListUserURLsCtx returns page of user URLs matching the query
+func (ms *MemStorage) ListUserURLsCtx(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	aliases := ms.muser[q.UserID]
+	urls := make([]models.ShrURL, 0, len(aliases))
+	for _, alias := range aliases {
+		urls = append(urls, ms.m[alias])
+	}
+	return listURLs(urls, q)
+}
+
+// UpdateURLTagsCtx adds and removes tags of user's url
+func (ms *MemStorage) UpdateURLTagsCtx(ctx context.Context, userID string, alias string, add []string, remove []string) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cur, ok := ms.m[alias]
+	if !ok {
+		return nil, ErrURLNotFound
+	}
+	upd, err := updateTags(cur, userID, add, remove)
+	if err != nil {
+		return nil, err
+	}
+	ms.m[alias] = upd
+	ms.addEvent(newURLEvent(models.ActionTags, upd, upd.URL))
+
+	return upd.Tags, nil
+}
+
+// GetUserTagsCtx returns tags of user URLs with number of URLs
+func (ms *MemStorage) GetUserTagsCtx(ctx context.Context, userID string) ([]models.TagCount, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return countTags(ms.m, ms.muser[userID]), nil
+}
+
+// SearchUserURLsCtx returns user URLs matching the query
+func (ms *MemStorage) SearchUserURLsCtx(ctx context.Context, userID string, query string, limit int) ([]models.ShrURL, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return searchURLs(ms.search, ms.m, userID, query, limit)
+}
+
+// UpdateURLCtx updates destination and options of user's url
+func (ms *MemStorage) UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	cur, ok := ms.m[url.Alias]
+	if !ok {
+		return models.ShrURL{}, ErrURLNotFound
+	}
+	if cur.URL != url.URL && ms.isURLExist(url.URL) {
+		return models.ShrURL{}, ErrURLExists
+	}
+
+	upd, err := updateURL(cur, url, version)
+	if err != nil {
+		return models.ShrURL{}, err
+	}
+	ms.m[url.Alias] = upd
+	indexURL(ms.murl, cur, upd)
+	ms.search.add(upd)
+	ms.addEvent(newURLEvent(models.ActionUpdate, upd, cur.URL))
+
+	return upd, nil
+}
+
+// updateURL checks that cur url may be updated by upd and returns updated url
+func updateURL(cur models.ShrURL, upd models.ShrURL, version int64) (models.ShrURL, error) {
+	switch {
+	case cur.UserID != upd.UserID:
+		return models.ShrURL{}, ErrNotOwner
+	case cur.Deleted:
+		return models.ShrURL{}, ErrURLDeleted
+	case cur.Version != version:
+		return models.ShrURL{}, ErrVersionMismatch
+	}
+
+	// keep clicks of variants with the same name
+	clicks := make(map[string]int64)
+	for _, v := range cur.Variants {
+		clicks[v.Name] = v.Clicks
+	}
+	variants := make([]models.Variant, 0, len(upd.Variants))
+	for _, v := range upd.Variants {
+		v.Clicks = clicks[v.Name]
+		variants = append(variants, v)
+	}
+
+	cur.URL = upd.URL
+	cur.UTM = upd.UTM
+	cur.Rules = upd.Rules
+	cur.Variants = variants
+	cur.Title = upd.Title
+	cur.Note = upd.Note
+	cur.Version++
+
+	return cur, nil
+}
+
 // DeleteUserURLsCtx deletes user URLs
-func (ms *MemStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) error {
-	// TODO
+func (ms *MemStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	failed := make(map[string]error)
+	for _, alias := range aliases {
+		url, ok := ms.m[alias]
+		if err := checkDelete(url, ok, userID); err != nil {
+			failed[alias] = err
+			continue
+		}
+		if url.Deleted {
+			continue
+		}
+		url.Deleted = true
+		url.DeletedAt = time.Now()
+		indexURL(ms.murl, ms.m[alias], url)
+		ms.m[alias] = url
+		ms.addEvent(newURLEvent(models.ActionDelete, url, url.URL))
+	}
+	return failed, nil
+}
+
+// checkDelete returns error if url found by alias cannot be deleted by the user
+func checkDelete(url models.ShrURL, found bool, userID string) error {
+	if !found {
+		return ErrAliasNotFound
+	}
+	if url.UserID != userID {
+		return ErrNotOwner
+	}
 	return nil
 }
 
-// isURLExist checks existing url
-func (ms *MemStorage) isURLExist(url string) bool {
-	// does the url exist?
-	for _, v := range ms.m {
-		if strings.Compare(v, url) == 0 {
-			// url exist
-			return true
+// checkRestore returns error if url found by alias cannot be restored by the user
+func checkRestore(url models.ShrURL, found bool, userID string) error {
+	if err := checkDelete(url, found, userID); err != nil {
+		return err
+	}
+	if !url.Deleted {
+		return ErrURLNotDeleted
+	}
+	return nil
+}
+
+// RestoreUserURLsCtx restores deleted user URLs
+func (ms *MemStorage) RestoreUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	failed := make(map[string]error)
+	seen := make(map[string]struct{}, len(aliases))
+	for _, alias := range aliases {
+		if _, ok := seen[alias]; ok {
+			continue
+		}
+		seen[alias] = struct{}{}
+		url, ok := ms.m[alias]
+		if err := checkRestore(url, ok, userID); err != nil {
+			failed[alias] = err
+			continue
+		}
+		// the url may be shortened again after deletion
+		if ms.isURLExist(url.URL) {
+			failed[alias] = ErrURLExists
+			continue
+		}
+		url.Deleted = false
+		url.DeletedAt = time.Time{}
+		ms.m[alias] = url
+		indexURL(ms.murl, models.ShrURL{}, url)
+		ms.addEvent(newURLEvent(models.ActionRestore, url, url.URL))
+	}
+	return failed, nil
+}
+
+// TransferUserURLsCtx reassigns all user URLs to another user
+func (ms *MemStorage) TransferUserURLsCtx(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	aliases := transferAliases(ms.m, ms.muser, fromUserID, toUserID)
+	for _, alias := range aliases {
+		url := ms.m[alias]
+		url.UserID = toUserID
+		ms.m[alias] = url
+		ms.search.add(url)
+		ms.addEvent(newURLEvent(models.ActionTransfer, url, url.URL))
+	}
+	return len(aliases), nil
+}
+
+// transferAliases moves aliases of user to another user and returns moved aliases,
+// aliases of the user are kept in order of creation
+func transferAliases(m map[string]models.ShrURL, muser map[string][]string, fromUserID string, toUserID string) []string {
+	aliases := muser[fromUserID]
+	if len(aliases) == 0 || fromUserID == toUserID {
+		return nil
+	}
+	delete(muser, fromUserID)
+
+	merged := append(muser[toUserID], aliases...)
+	slices.SortStableFunc(merged, func(a, b string) int {
+		return m[a].CreatedAt.Compare(m[b].CreatedAt)
+	})
+	muser[toUserID] = merged
+	return aliases
+}
+
+// PurgeDeletedURLsCtx removes URLs deleted before the time
+func (ms *MemStorage) PurgeDeletedURLsCtx(ctx context.Context, before time.Time) (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	purged := deletedBefore(ms.m, before)
+	for _, alias := range purged {
+		url := ms.m[alias]
+		ms.history[alias] = append(ms.history[alias], newURLEvent(models.ActionPurge, url, url.URL))
+		ms.search.remove(alias)
+	}
+	removeURLs(ms.m, ms.muser, ms.murl, purged)
+	return len(purged), nil
+}
+
+// deletedBefore returns aliases of urls deleted before the time
+func deletedBefore(m map[string]models.ShrURL, before time.Time) []string {
+	var aliases []string
+	for alias, url := range m {
+		if url.Deleted && url.DeletedAt.Before(before) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// removeURLs removes urls from maps of urls, user urls and destinations
+func removeURLs(m map[string]models.ShrURL, muser map[string][]string, murl map[string]string, aliases []string) {
+	for _, alias := range aliases {
+		url, ok := m[alias]
+		if !ok {
+			continue
+		}
+		delete(m, alias)
+		indexURL(murl, url, models.ShrURL{})
+		muser[url.UserID] = slices.DeleteFunc(muser[url.UserID], func(a string) bool {
+			return a == alias
+		})
+	}
+}
+
+// GetURLHistoryCtx returns events of url
+func (ms *MemStorage) GetURLHistoryCtx(ctx context.Context, alias string) ([]models.URLEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	events, ok := ms.history[alias]
+	if !ok {
+		return nil, ErrURLNotFound
+	}
+	return append([]models.URLEvent(nil), events...), nil
+}
+
+// addEvent appends event to url history
+func (ms *MemStorage) addEvent(event models.URLEvent) {
+	ms.history[event.Alias] = append(ms.history[event.Alias], event)
+}
+
+// newURLEvent returns event of url performed by its owner
+func newURLEvent(action string, url models.ShrURL, prevURL string) models.URLEvent {
+	return models.URLEvent{
+		Alias:   url.Alias,
+		Action:  action,
+		UserID:  url.UserID,
+		URL:     url.URL,
+		PrevURL: prevURL,
+		Time:    time.Now(),
+	}
+}
+
+// RegisterClickCtx counts redirect by alias to variant
+func (ms *MemStorage) RegisterClickCtx(ctx context.Context, alias string, variant string) (models.Click, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	url, ok := ms.m[alias]
+	if !ok {
+		return models.Click{}, ErrURLNotFound
+	}
+	url = countClick(url, variant)
+	ms.m[alias] = url
+
+	return newClick(url), nil
+}
+
+// newClick returns click counted for url
+func newClick(url models.ShrURL) models.Click {
+	return models.Click{UserID: url.UserID, URL: url.URL, Clicks: url.Clicks, First: url.Clicks == 1}
+}
+
+// countClick returns copy of url with counted click
+func countClick(url models.ShrURL, variant string) models.ShrURL {
+	return addClicks(url, variant, 1)
+}
+
+// addClicks returns copy of url with n clicks added to the link and its variant,
+// variants are copied because they may be shared with previously returned urls
+func addClicks(url models.ShrURL, variant string, n int64) models.ShrURL {
+	url.Clicks += n
+	if variant == "" {
+		return url
+	}
+	variants := make([]models.Variant, len(url.Variants))
+	copy(variants, url.Variants)
+	for i := range variants {
+		if variants[i].Name == variant {
+			variants[i].Clicks += n
 		}
 	}
-	return false
+	url.Variants = variants
+	return url
+}
+
+// isURLExist checks existing url, deleted urls are not taken into account
+func (ms *MemStorage) isURLExist(url string) bool {
+	_, ok := ms.murl[url]
+	return ok
+}
+
+// CreateUserCtx stores account
+func (ms *MemStorage) CreateUserCtx(ctx context.Context, user models.User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.logins[user.Login]; ok {
+		return ErrLoginExists
+	}
+	ms.users[user.ID] = user
+	ms.logins[user.Login] = user.ID
+	return nil
+}
+
+// GetUserCtx returns account by ID
+func (ms *MemStorage) GetUserCtx(ctx context.Context, id string) (models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, ok := ms.users[id]
+	if !ok {
+		return models.User{}, ErrAccountNotFound
+	}
+	return user, nil
+}
+
+// GetUserByLoginCtx returns account by login
+func (ms *MemStorage) GetUserByLoginCtx(ctx context.Context, login string) (models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, ok := ms.users[ms.logins[login]]
+	if !ok {
+		return models.User{}, ErrAccountNotFound
+	}
+	return user, nil
+}
+
+// CreateWebhookCtx stores webhook
+func (ms *MemStorage) CreateWebhookCtx(ctx context.Context, hook models.Webhook) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.webhooks[hook.ID] = hook
+	return nil
+}
+
+// ListWebhooksCtx returns webhooks of all users ordered by creation time
+func (ms *MemStorage) ListWebhooksCtx(ctx context.Context) ([]models.Webhook, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return sortedWebhooks(ms.webhooks), nil
+}
+
+// sortedWebhooks returns webhooks ordered by creation time
+func sortedWebhooks(m map[string]models.Webhook) []models.Webhook {
+	hooks := make([]models.Webhook, 0, len(m))
+	for _, hook := range m {
+		hooks = append(hooks, hook)
+	}
+	slices.SortFunc(hooks, func(a, b models.Webhook) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return hooks
+}
+
+// DeleteWebhookCtx removes webhook with its dead letters
+func (ms *MemStorage) DeleteWebhookCtx(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(ms.webhooks, id)
+	delete(ms.deadLetters, id)
+	return nil
+}
+
+// AddWebhookDeadLetterCtx stores event not delivered to webhook, the oldest dead letters are dropped
+func (ms *MemStorage) AddWebhookDeadLetterCtx(ctx context.Context, letter models.WebhookDeadLetter, keep int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.webhooks[letter.HookID]; !ok {
+		return ErrWebhookNotFound
+	}
+	ms.deadLetters[letter.HookID] = appendDeadLetter(ms.deadLetters[letter.HookID], letter, keep)
+	return nil
+}
+
+// appendDeadLetter appends dead letter keeping only keep latest ones
+func appendDeadLetter(letters []models.WebhookDeadLetter, letter models.WebhookDeadLetter, keep int) []models.WebhookDeadLetter {
+	letters = append(letters, letter)
+	if len(letters) > keep {
+		letters = slices.Clone(letters[len(letters)-keep:])
+	}
+	return letters
+}
+
+// ListWebhookDeadLettersCtx returns dead letters of webhook, the latest goes first
+func (ms *MemStorage) ListWebhookDeadLettersCtx(ctx context.Context, hookID string) ([]models.WebhookDeadLetter, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	letters := slices.Clone(ms.deadLetters[hookID])
+	slices.Reverse(letters)
+	return letters, nil
+}
+
+// CreateAPIKeyCtx stores API key
+func (ms *MemStorage) CreateAPIKeyCtx(ctx context.Context, key models.APIKey) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.apiKeys[key.ID] = key
+	return nil
+}
+
+// ListAPIKeysCtx returns API keys of all users ordered by creation time
+func (ms *MemStorage) ListAPIKeysCtx(ctx context.Context) ([]models.APIKey, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return sortedAPIKeys(ms.apiKeys), nil
+}
+
+// sortedAPIKeys returns API keys ordered by creation time
+func sortedAPIKeys(m map[string]models.APIKey) []models.APIKey {
+	keys := make([]models.APIKey, 0, len(m))
+	for _, key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b models.APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys
+}
+
+// DeleteAPIKeyCtx removes API key
+func (ms *MemStorage) DeleteAPIKeyCtx(ctx context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.apiKeys[id]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	delete(ms.apiKeys, id)
+	return nil
+}
+
+// RevokeCtx stores revocation of auth tokens
+func (ms *MemStorage) RevokeCtx(ctx context.Context, rev models.Revocation) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.revocations[revocationKey(rev)] = rev
+	return nil
+}
+
+// ListRevocationsCtx returns revocations not expired at now and removes expired ones
+func (ms *MemStorage) ListRevocationsCtx(ctx context.Context, now time.Time) ([]models.Revocation, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	pruneRevocations(ms.revocations, now)
+	return slices.Collect(maps.Values(ms.revocations)), nil
+}
+
+// pruneRevocations removes revocations of tokens expired at now
+func pruneRevocations(m map[string]models.Revocation, now time.Time) {
+	maps.DeleteFunc(m, func(_ string, rev models.Revocation) bool {
+		return now.After(rev.Until)
+	})
 }
@@ -2,7 +2,13 @@ package storage
 
 import (
 	"context"
+	"database/sql/driver"
 	"errors"
+	"net"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 
 	"github.com/rookgm/shortener/internal/models"
 )
@@ -13,19 +19,142 @@ var (
 	ErrURLNotFound = errors.New("url not found")
 	// ErrURLExists is an error when URL is already exist in the storage
 	ErrURLExists = errors.New("url exists")
+	// ErrAliasExists is an error when alias is already used by another URL
+	ErrAliasExists = errors.New("alias exists")
 	// ErrAliasNotFound is an error when shortened URL is not found in the storage
 	ErrAliasNotFound = errors.New("alias not found")
 	// ErrUserNotFound is an error when user's URL is not found in the storage
 	ErrUserNotFound = errors.New("user not found")
+	// ErrNotOwner is an error when URL belongs to another user
+	ErrNotOwner = errors.New("url belongs to another user")
+	// ErrURLDeleted is an error when URL is deleted
+	ErrURLDeleted = errors.New("url is deleted")
+	// ErrURLNotDeleted is an error when URL to restore is not deleted
+	ErrURLNotDeleted = errors.New("url is not deleted")
+	// ErrVersionMismatch is an error when URL was changed since the expected version
+	ErrVersionMismatch = errors.New("url version mismatch")
+	// ErrInvalidCursor is an error when listing cursor is malformed
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrEmptyQuery is an error when search query has no words
+	ErrEmptyQuery = errors.New("empty search query")
+	// ErrLoginExists is an error when login is used by another account
+	ErrLoginExists = errors.New("login exists")
+	// ErrAccountNotFound is an error when account is not found in the storage
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrWebhookNotFound is an error when webhook is not found in the storage
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrAPIKeyNotFound is an error when API key is not found in the storage
+	ErrAPIKeyNotFound = errors.New("API key not found")
 )
 
+// IsTransient reports whether operation failed with err may succeed if retried,
+// e.g. database connection is lost or transaction is aborted by concurrent one
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgerrcode.IsTransactionRollback(pgErr.Code) ||
+			pgErr.Code == pgerrcode.AdminShutdown ||
+			pgErr.Code == pgerrcode.CannotConnectNow
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // URLStorage is interface for interacting with storage-related data
 type URLStorage interface {
 	StoreURLCtx(ctx context.Context, url models.ShrURL) error
-	StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) error
+	// StoreBatchURLCtx stores urls and returns outcome of every url in the same order,
+	// url is not stored if its destination exists or its alias is used
+	StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error)
 	GetURLCtx(ctx context.Context, alias string) (models.ShrURL, error)
+	// UpdateURLCtx updates destination and options of user's url if the url has expected version,
+	// returns updated url with incremented version
+	UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error)
 	GetAliasCtx(ctx context.Context, url string) (models.ShrURL, error)
 	GetUserURLsCtx(ctx context.Context, userID string) ([]models.ShrURL, error)
-	DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) error
+	// UpdateURLTagsCtx adds and removes tags of user's url and returns resulting tags,
+	// tags are not removed if they are added by the same call
+	UpdateURLTagsCtx(ctx context.Context, userID string, alias string, add []string, remove []string) ([]string, error)
+	// GetUserTagsCtx returns tags of not deleted user URLs with number of URLs, sorted by tag
+	GetUserTagsCtx(ctx context.Context, userID string) ([]models.TagCount, error)
+	// WalkUserURLsCtx calls fn for every user URL including deleted ones in order of creation,
+	// walking stops on the first error of fn and the error is returned
+	WalkUserURLsCtx(ctx context.Context, userID string, fn func(url models.ShrURL) error) error
+	// ListUserURLsCtx returns page of user URLs matching the query
+	// and cursor of the next page, the cursor is empty on the last page
+	ListUserURLsCtx(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error)
+	// SearchUserURLsCtx returns not deleted user URLs which destination, title or note
+	// contain words starting with every word of the query, the best matches go first
+	SearchUserURLsCtx(ctx context.Context, userID string, query string, limit int) ([]models.ShrURL, error)
+	// DeleteUserURLsCtx deletes user URLs and returns errors of aliases not deleted,
+	// deleting already deleted url is not an error
+	DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error)
+	// RestoreUserURLsCtx restores deleted user URLs and returns errors of aliases not restored,
+	// url shortened again after deletion is not restored
+	RestoreUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error)
+	// TransferUserURLsCtx reassigns all URLs of user including deleted ones to another user
+	// and returns number of transferred URLs
+	TransferUserURLsCtx(ctx context.Context, fromUserID string, toUserID string) (int, error)
+	// PurgeDeletedURLsCtx physically removes URLs deleted before the time and returns number of removed URLs,
+	// history of removed URLs is kept
+	PurgeDeletedURLsCtx(ctx context.Context, before time.Time) (int, error)
+	// RegisterClickCtx counts redirect by alias to variant (empty if the link has no variants)
+	// and returns owner of the link and total number of its clicks
+	RegisterClickCtx(ctx context.Context, alias string, variant string) (models.Click, error)
+	// GetURLHistoryCtx returns events of url in the order they happened
+	GetURLHistoryCtx(ctx context.Context, alias string) ([]models.URLEvent, error)
 	LoadFromFile() error
 }
+
+// UserStorage is interface for interacting with registered accounts
+type UserStorage interface {
+	// CreateUserCtx stores account, login must be unique
+	CreateUserCtx(ctx context.Context, user models.User) error
+	GetUserCtx(ctx context.Context, id string) (models.User, error)
+	GetUserByLoginCtx(ctx context.Context, login string) (models.User, error)
+}
+
+// WebhookStorage is interface for interacting with webhooks and events not delivered to them
+type WebhookStorage interface {
+	CreateWebhookCtx(ctx context.Context, hook models.Webhook) error
+	// ListWebhooksCtx returns webhooks of all users ordered by creation time
+	ListWebhooksCtx(ctx context.Context) ([]models.Webhook, error)
+	// DeleteWebhookCtx removes webhook with its dead letters
+	DeleteWebhookCtx(ctx context.Context, id string) error
+	// AddWebhookDeadLetterCtx stores event not delivered to webhook,
+	// only keep latest dead letters of the webhook are retained
+	AddWebhookDeadLetterCtx(ctx context.Context, letter models.WebhookDeadLetter, keep int) error
+	// ListWebhookDeadLettersCtx returns dead letters of webhook, the latest goes first
+	ListWebhookDeadLettersCtx(ctx context.Context, hookID string) ([]models.WebhookDeadLetter, error)
+}
+
+// RevocationStorage is interface for interacting with revocations of auth tokens
+type RevocationStorage interface {
+	// RevokeCtx stores revocation, it replaces previous revocation of the same session or user
+	RevokeCtx(ctx context.Context, rev models.Revocation) error
+	// ListRevocationsCtx returns revocations not expired at now, expired ones may be removed
+	ListRevocationsCtx(ctx context.Context, now time.Time) ([]models.Revocation, error)
+}
+
+// revocationKey returns key of revocation, revocations with the same key replace each other
+func revocationKey(rev models.Revocation) string {
+	if rev.SessionID != "" {
+		return "session:" + rev.SessionID
+	}
+	return "user:" + rev.UserID
+}
+
+// APIKeyStorage is interface for interacting with API keys, only hashes of their secrets are stored
+type APIKeyStorage interface {
+	CreateAPIKeyCtx(ctx context.Context, key models.APIKey) error
+	// ListAPIKeysCtx returns API keys of all users ordered by creation time
+	ListAPIKeysCtx(ctx context.Context) ([]models.APIKey, error)
+	DeleteAPIKeyCtx(ctx context.Context, id string) error
+}
@@ -7,6 +7,7 @@ package storage
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/rookgm/shortener/internal/models"
@@ -36,11 +37,12 @@ func (m *MockURLStorage) EXPECT() *MockURLStorageMockRecorder {
 }
 
 // DeleteUserURLsCtx mocks base method.
-func (m *MockURLStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) error {
+func (m *MockURLStorage) DeleteUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeleteUserURLsCtx", ctx, userID, aliases)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(map[string]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // DeleteUserURLsCtx indicates an expected call of DeleteUserURLsCtx.
@@ -79,6 +81,36 @@ func (mr *MockURLStorageMockRecorder) GetURLCtx(ctx, alias interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLCtx", reflect.TypeOf((*MockURLStorage)(nil).GetURLCtx), ctx, alias)
 }
 
+// GetURLHistoryCtx mocks base method.
+func (m *MockURLStorage) GetURLHistoryCtx(ctx context.Context, alias string) ([]models.URLEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetURLHistoryCtx", ctx, alias)
+	ret0, _ := ret[0].([]models.URLEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetURLHistoryCtx indicates an expected call of GetURLHistoryCtx.
+func (mr *MockURLStorageMockRecorder) GetURLHistoryCtx(ctx, alias interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetURLHistoryCtx", reflect.TypeOf((*MockURLStorage)(nil).GetURLHistoryCtx), ctx, alias)
+}
+
+// GetUserTagsCtx mocks base method.
+func (m *MockURLStorage) GetUserTagsCtx(ctx context.Context, userID string) ([]models.TagCount, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserTagsCtx", ctx, userID)
+	ret0, _ := ret[0].([]models.TagCount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserTagsCtx indicates an expected call of GetUserTagsCtx.
+func (mr *MockURLStorageMockRecorder) GetUserTagsCtx(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserTagsCtx", reflect.TypeOf((*MockURLStorage)(nil).GetUserTagsCtx), ctx, userID)
+}
+
 // GetUserURLsCtx mocks base method.
 func (m *MockURLStorage) GetUserURLsCtx(ctx context.Context, userID string) ([]models.ShrURL, error) {
 	m.ctrl.T.Helper()
@@ -94,6 +126,22 @@ func (mr *MockURLStorageMockRecorder) GetUserURLsCtx(ctx, userID interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).GetUserURLsCtx), ctx, userID)
 }
 
+// ListUserURLsCtx mocks base method.
+func (m *MockURLStorage) ListUserURLsCtx(ctx context.Context, q models.UserURLsQuery) ([]models.ShrURL, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserURLsCtx", ctx, q)
+	ret0, _ := ret[0].([]models.ShrURL)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListUserURLsCtx indicates an expected call of ListUserURLsCtx.
+func (mr *MockURLStorageMockRecorder) ListUserURLsCtx(ctx, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).ListUserURLsCtx), ctx, q)
+}
+
 // LoadFromFile mocks base method.
 func (m *MockURLStorage) LoadFromFile() error {
 	m.ctrl.T.Helper()
@@ -108,12 +156,73 @@ func (mr *MockURLStorageMockRecorder) LoadFromFile() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadFromFile", reflect.TypeOf((*MockURLStorage)(nil).LoadFromFile))
 }
 
+// PurgeDeletedURLsCtx mocks base method.
+func (m *MockURLStorage) PurgeDeletedURLsCtx(ctx context.Context, before time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeletedURLsCtx", ctx, before)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeletedURLsCtx indicates an expected call of PurgeDeletedURLsCtx.
+func (mr *MockURLStorageMockRecorder) PurgeDeletedURLsCtx(ctx, before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeletedURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).PurgeDeletedURLsCtx), ctx, before)
+}
+
+// RegisterClickCtx mocks base method.
+func (m *MockURLStorage) RegisterClickCtx(ctx context.Context, alias, variant string) (models.Click, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RegisterClickCtx", ctx, alias, variant)
+	ret0, _ := ret[0].(models.Click)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RegisterClickCtx indicates an expected call of RegisterClickCtx.
+func (mr *MockURLStorageMockRecorder) RegisterClickCtx(ctx, alias, variant interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterClickCtx", reflect.TypeOf((*MockURLStorage)(nil).RegisterClickCtx), ctx, alias, variant)
+}
+
+// RestoreUserURLsCtx mocks base method.
+func (m *MockURLStorage) RestoreUserURLsCtx(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreUserURLsCtx", ctx, userID, aliases)
+	ret0, _ := ret[0].(map[string]error)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreUserURLsCtx indicates an expected call of RestoreUserURLsCtx.
+func (mr *MockURLStorageMockRecorder) RestoreUserURLsCtx(ctx, userID, aliases interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).RestoreUserURLsCtx), ctx, userID, aliases)
+}
+
+// SearchUserURLsCtx mocks base method.
+func (m *MockURLStorage) SearchUserURLsCtx(ctx context.Context, userID, query string, limit int) ([]models.ShrURL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchUserURLsCtx", ctx, userID, query, limit)
+	ret0, _ := ret[0].([]models.ShrURL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchUserURLsCtx indicates an expected call of SearchUserURLsCtx.
+func (mr *MockURLStorageMockRecorder) SearchUserURLsCtx(ctx, userID, query, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).SearchUserURLsCtx), ctx, userID, query, limit)
+}
+
 // StoreBatchURLCtx mocks base method.
-func (m *MockURLStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) error {
+func (m *MockURLStorage) StoreBatchURLCtx(ctx context.Context, urls []models.ShrURL) ([]models.BatchResult, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "StoreBatchURLCtx", ctx, urls)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].([]models.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // StoreBatchURLCtx indicates an expected call of StoreBatchURLCtx.
@@ -135,3 +244,342 @@ func (mr *MockURLStorageMockRecorder) StoreURLCtx(ctx, url interface{}) *gomock.
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreURLCtx", reflect.TypeOf((*MockURLStorage)(nil).StoreURLCtx), ctx, url)
 }
+
+// TransferUserURLsCtx mocks base method.
+func (m *MockURLStorage) TransferUserURLsCtx(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferUserURLsCtx", ctx, fromUserID, toUserID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferUserURLsCtx indicates an expected call of TransferUserURLsCtx.
+func (mr *MockURLStorageMockRecorder) TransferUserURLsCtx(ctx, fromUserID, toUserID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).TransferUserURLsCtx), ctx, fromUserID, toUserID)
+}
+
+// UpdateURLCtx mocks base method.
+func (m *MockURLStorage) UpdateURLCtx(ctx context.Context, url models.ShrURL, version int64) (models.ShrURL, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateURLCtx", ctx, url, version)
+	ret0, _ := ret[0].(models.ShrURL)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateURLCtx indicates an expected call of UpdateURLCtx.
+func (mr *MockURLStorageMockRecorder) UpdateURLCtx(ctx, url, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateURLCtx", reflect.TypeOf((*MockURLStorage)(nil).UpdateURLCtx), ctx, url, version)
+}
+
+// UpdateURLTagsCtx mocks base method.
+func (m *MockURLStorage) UpdateURLTagsCtx(ctx context.Context, userID, alias string, add, remove []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateURLTagsCtx", ctx, userID, alias, add, remove)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateURLTagsCtx indicates an expected call of UpdateURLTagsCtx.
+func (mr *MockURLStorageMockRecorder) UpdateURLTagsCtx(ctx, userID, alias, add, remove interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateURLTagsCtx", reflect.TypeOf((*MockURLStorage)(nil).UpdateURLTagsCtx), ctx, userID, alias, add, remove)
+}
+
+// WalkUserURLsCtx mocks base method.
+func (m *MockURLStorage) WalkUserURLsCtx(ctx context.Context, userID string, fn func(models.ShrURL) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WalkUserURLsCtx", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WalkUserURLsCtx indicates an expected call of WalkUserURLsCtx.
+func (mr *MockURLStorageMockRecorder) WalkUserURLsCtx(ctx, userID, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WalkUserURLsCtx", reflect.TypeOf((*MockURLStorage)(nil).WalkUserURLsCtx), ctx, userID, fn)
+}
+
+// MockUserStorage is a mock of UserStorage interface.
+type MockUserStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockUserStorageMockRecorder
+}
+
+// MockUserStorageMockRecorder is the mock recorder for MockUserStorage.
+type MockUserStorageMockRecorder struct {
+	mock *MockUserStorage
+}
+
+// NewMockUserStorage creates a new mock instance.
+func NewMockUserStorage(ctrl *gomock.Controller) *MockUserStorage {
+	mock := &MockUserStorage{ctrl: ctrl}
+	mock.recorder = &MockUserStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUserStorage) EXPECT() *MockUserStorageMockRecorder {
+	return m.recorder
+}
+
+// CreateUserCtx mocks base method.
+func (m *MockUserStorage) CreateUserCtx(ctx context.Context, user models.User) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUserCtx", ctx, user)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateUserCtx indicates an expected call of CreateUserCtx.
+func (mr *MockUserStorageMockRecorder) CreateUserCtx(ctx, user interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUserCtx", reflect.TypeOf((*MockUserStorage)(nil).CreateUserCtx), ctx, user)
+}
+
+// GetUserByLoginCtx mocks base method.
+func (m *MockUserStorage) GetUserByLoginCtx(ctx context.Context, login string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByLoginCtx", ctx, login)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByLoginCtx indicates an expected call of GetUserByLoginCtx.
+func (mr *MockUserStorageMockRecorder) GetUserByLoginCtx(ctx, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByLoginCtx", reflect.TypeOf((*MockUserStorage)(nil).GetUserByLoginCtx), ctx, login)
+}
+
+// GetUserCtx mocks base method.
+func (m *MockUserStorage) GetUserCtx(ctx context.Context, id string) (models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserCtx", ctx, id)
+	ret0, _ := ret[0].(models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserCtx indicates an expected call of GetUserCtx.
+func (mr *MockUserStorageMockRecorder) GetUserCtx(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserCtx", reflect.TypeOf((*MockUserStorage)(nil).GetUserCtx), ctx, id)
+}
+
+// MockWebhookStorage is a mock of WebhookStorage interface.
+type MockWebhookStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookStorageMockRecorder
+}
+
+// MockWebhookStorageMockRecorder is the mock recorder for MockWebhookStorage.
+type MockWebhookStorageMockRecorder struct {
+	mock *MockWebhookStorage
+}
+
+// NewMockWebhookStorage creates a new mock instance.
+func NewMockWebhookStorage(ctrl *gomock.Controller) *MockWebhookStorage {
+	mock := &MockWebhookStorage{ctrl: ctrl}
+	mock.recorder = &MockWebhookStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhookStorage) EXPECT() *MockWebhookStorageMockRecorder {
+	return m.recorder
+}
+
+// AddWebhookDeadLetterCtx mocks base method.
+func (m *MockWebhookStorage) AddWebhookDeadLetterCtx(ctx context.Context, letter models.WebhookDeadLetter, keep int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddWebhookDeadLetterCtx", ctx, letter, keep)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddWebhookDeadLetterCtx indicates an expected call of AddWebhookDeadLetterCtx.
+func (mr *MockWebhookStorageMockRecorder) AddWebhookDeadLetterCtx(ctx, letter, keep interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddWebhookDeadLetterCtx", reflect.TypeOf((*MockWebhookStorage)(nil).AddWebhookDeadLetterCtx), ctx, letter, keep)
+}
+
+// CreateWebhookCtx mocks base method.
+func (m *MockWebhookStorage) CreateWebhookCtx(ctx context.Context, hook models.Webhook) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateWebhookCtx", ctx, hook)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateWebhookCtx indicates an expected call of CreateWebhookCtx.
+func (mr *MockWebhookStorageMockRecorder) CreateWebhookCtx(ctx, hook interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhookCtx", reflect.TypeOf((*MockWebhookStorage)(nil).CreateWebhookCtx), ctx, hook)
+}
+
+// DeleteWebhookCtx mocks base method.
+func (m *MockWebhookStorage) DeleteWebhookCtx(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhookCtx", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhookCtx indicates an expected call of DeleteWebhookCtx.
+func (mr *MockWebhookStorageMockRecorder) DeleteWebhookCtx(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhookCtx", reflect.TypeOf((*MockWebhookStorage)(nil).DeleteWebhookCtx), ctx, id)
+}
+
+// ListWebhookDeadLettersCtx mocks base method.
+func (m *MockWebhookStorage) ListWebhookDeadLettersCtx(ctx context.Context, hookID string) ([]models.WebhookDeadLetter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListWebhookDeadLettersCtx", ctx, hookID)
+	ret0, _ := ret[0].([]models.WebhookDeadLetter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListWebhookDeadLettersCtx indicates an expected call of ListWebhookDeadLettersCtx.
+func (mr *MockWebhookStorageMockRecorder) ListWebhookDeadLettersCtx(ctx, hookID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListWebhookDeadLettersCtx", reflect.TypeOf((*MockWebhookStorage)(nil).ListWebhookDeadLettersCtx), ctx, hookID)
+}
+
+// ListWebhooksCtx mocks base method.
+func (m *MockWebhookStorage) ListWebhooksCtx(ctx context.Context) ([]models.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListWebhooksCtx", ctx)
+	ret0, _ := ret[0].([]models.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListWebhooksCtx indicates an expected call of ListWebhooksCtx.
+func (mr *MockWebhookStorageMockRecorder) ListWebhooksCtx(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListWebhooksCtx", reflect.TypeOf((*MockWebhookStorage)(nil).ListWebhooksCtx), ctx)
+}
+
+// MockRevocationStorage is a mock of RevocationStorage interface.
+type MockRevocationStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockRevocationStorageMockRecorder
+}
+
+// MockRevocationStorageMockRecorder is the mock recorder for MockRevocationStorage.
+type MockRevocationStorageMockRecorder struct {
+	mock *MockRevocationStorage
+}
+
+// NewMockRevocationStorage creates a new mock instance.
+func NewMockRevocationStorage(ctrl *gomock.Controller) *MockRevocationStorage {
+	mock := &MockRevocationStorage{ctrl: ctrl}
+	mock.recorder = &MockRevocationStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRevocationStorage) EXPECT() *MockRevocationStorageMockRecorder {
+	return m.recorder
+}
+
+// ListRevocationsCtx mocks base method.
+func (m *MockRevocationStorage) ListRevocationsCtx(ctx context.Context, now time.Time) ([]models.Revocation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRevocationsCtx", ctx, now)
+	ret0, _ := ret[0].([]models.Revocation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRevocationsCtx indicates an expected call of ListRevocationsCtx.
+func (mr *MockRevocationStorageMockRecorder) ListRevocationsCtx(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRevocationsCtx", reflect.TypeOf((*MockRevocationStorage)(nil).ListRevocationsCtx), ctx, now)
+}
+
+// RevokeCtx mocks base method.
+func (m *MockRevocationStorage) RevokeCtx(ctx context.Context, rev models.Revocation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeCtx", ctx, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeCtx indicates an expected call of RevokeCtx.
+func (mr *MockRevocationStorageMockRecorder) RevokeCtx(ctx, rev interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeCtx", reflect.TypeOf((*MockRevocationStorage)(nil).RevokeCtx), ctx, rev)
+}
+
+// MockAPIKeyStorage is a mock of APIKeyStorage interface.
+type MockAPIKeyStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockAPIKeyStorageMockRecorder
+}
+
+// MockAPIKeyStorageMockRecorder is the mock recorder for MockAPIKeyStorage.
+type MockAPIKeyStorageMockRecorder struct {
+	mock *MockAPIKeyStorage
+}
+
+// NewMockAPIKeyStorage creates a new mock instance.
+func NewMockAPIKeyStorage(ctrl *gomock.Controller) *MockAPIKeyStorage {
+	mock := &MockAPIKeyStorage{ctrl: ctrl}
+	mock.recorder = &MockAPIKeyStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAPIKeyStorage) EXPECT() *MockAPIKeyStorageMockRecorder {
+	return m.recorder
+}
+
+// CreateAPIKeyCtx mocks base method.
+func (m *MockAPIKeyStorage) CreateAPIKeyCtx(ctx context.Context, key models.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKeyCtx", ctx, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAPIKeyCtx indicates an expected call of CreateAPIKeyCtx.
+func (mr *MockAPIKeyStorageMockRecorder) CreateAPIKeyCtx(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKeyCtx", reflect.TypeOf((*MockAPIKeyStorage)(nil).CreateAPIKeyCtx), ctx, key)
+}
+
+// DeleteAPIKeyCtx mocks base method.
+func (m *MockAPIKeyStorage) DeleteAPIKeyCtx(ctx context.Context, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKeyCtx", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKeyCtx indicates an expected call of DeleteAPIKeyCtx.
+func (mr *MockAPIKeyStorageMockRecorder) DeleteAPIKeyCtx(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKeyCtx", reflect.TypeOf((*MockAPIKeyStorage)(nil).DeleteAPIKeyCtx), ctx, id)
+}
+
+// ListAPIKeysCtx mocks base method.
+func (m *MockAPIKeyStorage) ListAPIKeysCtx(ctx context.Context) ([]models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAPIKeysCtx", ctx)
+	ret0, _ := ret[0].([]models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAPIKeysCtx indicates an expected call of ListAPIKeysCtx.
+func (mr *MockAPIKeyStorageMockRecorder) ListAPIKeysCtx(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAPIKeysCtx", reflect.TypeOf((*MockAPIKeyStorage)(nil).ListAPIKeysCtx), ctx)
+}
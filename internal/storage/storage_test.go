@@ -2,11 +2,21 @@ package storage
 
 import (
 	"context"
+	"database/sql/driver"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/rookgm/shortener/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStorage_SetAndGet(t *testing.T) {
@@ -38,8 +48,11 @@ func TestStorage_SetAndGet(t *testing.T) {
 
 	// set 3
 	url3 := models.ShrURL{
-		Alias: "dG56Hqxm",
-		URL:   "http://practicum.yandex.ru",
+		Alias:  "dG56Hqxm",
+		URL:    "http://practicum.yandex.ru",
+		UserID: "c81514ed-b47a-4d39-9591-b904db48a07a",
+		UTM:    models.UTM{Source: "flyer", Campaign: "spring"},
+		Rules:  []models.RedirectRule{{Platform: "ios", URL: "https://apps.apple.com/app/id1"}},
 	}
 	err = st.StoreURLCtx(ctx, url3)
 	assert.NoError(t, err, "set")
@@ -73,5 +86,995 @@ func TestStorage_SetAndGet(t *testing.T) {
 
 	v, err = fst.GetURLCtx(ctx, url3.Alias)
 	assert.NoError(t, err, "get")
-	assert.Equal(t, url3.URL, v.URL)
+	// stored url gets the first version and creation time
+	url3.Version = 1
+	assert.False(t, v.CreatedAt.IsZero(), "creation time is not set")
+	url3.CreatedAt = v.CreatedAt
+	assert.Equal(t, url3, v)
+}
+
+func TestFileStorage_UpdateURLCtx(t *testing.T) {
+
+	fileName := "storage_update_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: uid})
+	require.NoError(t, err)
+
+	upd := models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru/new", UserID: uid}
+
+	got, err := st.UpdateURLCtx(ctx, upd, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.Version)
+
+	// stale version
+	_, err = st.UpdateURLCtx(ctx, upd, 1)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	// another user
+	_, err = st.UpdateURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: "other"}, 2)
+	assert.ErrorIs(t, err, ErrNotOwner)
+
+	// destination of another alias
+	_, err = st.UpdateURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://ya.ru", UserID: uid}, 2)
+	assert.ErrorIs(t, err, ErrURLExists)
+
+	// the last record wins after reload
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+
+	v, err := fst.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.Equal(t, "http://yandex.ru/new", v.URL)
+	assert.Equal(t, int64(2), v.Version)
+
+	urls, err := fst.GetUserURLsCtx(ctx, uid)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+}
+
+func TestFileStorage_GetURLHistoryCtx(t *testing.T) {
+
+	fileName := "storage_history_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+	_, err = st.UpdateURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru/new", UserID: uid}, 1)
+	require.NoError(t, err)
+	// only owner deletes url
+	failed, err := st.DeleteUserURLsCtx(ctx, "other", []string{"4rSPg8ap", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]error{"4rSPg8ap": ErrNotOwner, "unknown": ErrAliasNotFound}, failed)
+	failed, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	// deleting again is not an error
+	failed, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+
+	type event struct {
+		action  string
+		userID  string
+		url     string
+		prevURL string
+	}
+
+	want := []event{
+		{models.ActionCreate, uid, "http://yandex.ru", ""},
+		{models.ActionUpdate, uid, "http://yandex.ru/new", "http://yandex.ru"},
+		{models.ActionDelete, uid, "http://yandex.ru/new", "http://yandex.ru/new"},
+	}
+
+	check := func(st URLStorage) {
+		events, err := st.GetURLHistoryCtx(ctx, "4rSPg8ap")
+		require.NoError(t, err)
+
+		var got []event
+		for _, e := range events {
+			assert.False(t, e.Time.IsZero(), "event time is zero")
+			got = append(got, event{e.Action, e.UserID, e.URL, e.PrevURL})
+		}
+		assert.Equal(t, want, got)
+
+		v, err := st.GetURLCtx(ctx, "4rSPg8ap")
+		require.NoError(t, err)
+		assert.True(t, v.Deleted, "url is not deleted")
+	}
+
+	check(st)
+
+	// history is restored from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+
+	_, err = fst.GetURLHistoryCtx(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+}
+
+func TestMemStorage_RestoreUserURLsCtx(t *testing.T) {
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewMemStorage()
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+
+	// only owner restores url
+	failed, err := st.RestoreUserURLsCtx(ctx, "other", []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]error{"4rSPg8ap": ErrNotOwner}, failed)
+	v, err := st.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.True(t, v.Deleted, "url is restored by another user")
+
+	failed, err = st.RestoreUserURLsCtx(ctx, uid, []string{"4rSPg8ap", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]error{"unknown": ErrAliasNotFound}, failed)
+	v, err = st.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.False(t, v.Deleted, "url is not restored")
+
+	failed, err = st.RestoreUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]error{"4rSPg8ap": ErrURLNotDeleted}, failed)
+
+	events, err := st.GetURLHistoryCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, models.ActionRestore, events[2].Action)
+	assert.Equal(t, uid, events[2].UserID)
+}
+
+func TestFileStorage_PurgeDeletedURLsCtx(t *testing.T) {
+
+	fileName := "storage_purge_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid, Note: "purged note"})
+	require.NoError(t, err)
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: uid, Note: "kept note"})
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+
+	// deleted url is shortened again
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "dG56Hqxm", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+	v, err := st.GetAliasCtx(ctx, "http://yandex.ru")
+	require.NoError(t, err)
+	assert.Equal(t, "dG56Hqxm", v.Alias)
+
+	// retention period is not expired
+	n, err := st.PurgeDeletedURLsCtx(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = st.PurgeDeletedURLsCtx(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// content of purged url is removed from file
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "purged note")
+	assert.Contains(t, string(data), "kept note")
+
+	check := func(st URLStorage) {
+		_, err := st.GetURLCtx(ctx, "4rSPg8ap")
+		assert.ErrorIs(t, err, ErrURLNotFound)
+		// history of purged url is kept
+		events, err := st.GetURLHistoryCtx(ctx, "4rSPg8ap")
+		require.NoError(t, err)
+		actions := make([]string, 0, len(events))
+		for _, e := range events {
+			actions = append(actions, e.Action)
+		}
+		assert.Equal(t, []string{models.ActionCreate, models.ActionDelete, models.ActionPurge}, actions)
+
+		urls, err := st.GetUserURLsCtx(ctx, uid)
+		require.NoError(t, err)
+		assert.Len(t, urls, 2)
+	}
+
+	check(st)
+
+	// purged url is not loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+}
+
+func TestMemStorage_PurgeDeletedURLsCtx(t *testing.T) {
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewMemStorage()
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+
+	v, err := st.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.False(t, v.DeletedAt.IsZero(), "deletion time is not set")
+
+	n, err := st.PurgeDeletedURLsCtx(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = st.GetURLCtx(ctx, "4rSPg8ap")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+	urls, err := st.GetUserURLsCtx(ctx, uid)
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// history of purged url is kept
+	events, err := st.GetURLHistoryCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, models.ActionPurge, events[2].Action)
+}
+
+func TestFileStorage_ListUserURLsCtx(t *testing.T) {
+
+	fileName := "storage_list_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	for _, url := range []models.ShrURL{
+		{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid},
+		{Alias: "edVPg3ks", URL: "http://ya.ru/search", UserID: uid},
+		{Alias: "dG56Hqxm", URL: "http://YA.ru", UserID: uid},
+		{Alias: "RTfd56hn", URL: "http://go.dev", UserID: "other"},
+	} {
+		require.NoError(t, st.StoreURLCtx(ctx, url))
+	}
+
+	// list returns all user urls by pages of the limit
+	list := func(st URLStorage, q models.UserURLsQuery) []string {
+		var aliases []string
+		for {
+			urls, cursor, err := st.ListUserURLsCtx(ctx, q)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(urls), q.Limit)
+			for _, url := range urls {
+				aliases = append(aliases, url.Alias)
+			}
+			if cursor == "" {
+				return aliases
+			}
+			q.Cursor = cursor
+		}
+	}
+
+	q := models.UserURLsQuery{UserID: uid, SortBy: models.SortByCreated, Limit: 2}
+	all := list(st, q)
+	assert.ElementsMatch(t, []string{"4rSPg8ap", "edVPg3ks", "dG56Hqxm"}, all)
+
+	// creation time is restored from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	assert.Equal(t, all, list(fst, q))
+
+	q.Domain = "ya.ru"
+	assert.ElementsMatch(t, []string{"edVPg3ks", "dG56Hqxm"}, list(fst, q))
+
+	_, _, err := fst.ListUserURLsCtx(ctx, models.UserURLsQuery{UserID: uid, Cursor: "bad"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestMemStorage_SearchUserURLsCtx(t *testing.T) {
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewMemStorage()
+
+	for _, url := range []models.ShrURL{
+		{Alias: "4rSPg8ap", URL: "https://yandex.ru/maps", UserID: uid, Note: "office route"},
+		{Alias: "edVPg3ks", URL: "https://go.dev/doc", UserID: uid, Title: "Maps of Go packages"},
+		{Alias: "dG56Hqxm", URL: "https://yandex.ru/search", UserID: uid, Title: "Search"},
+		{Alias: "RTfd56hn", URL: "https://yandex.ru/maps/moscow", UserID: "other"},
+		{Alias: "6qxTVvsy", URL: "https://maps.google.com", UserID: uid},
+	} {
+		require.NoError(t, st.StoreURLCtx(ctx, url))
+	}
+	_, err := st.DeleteUserURLsCtx(ctx, uid, []string{"6qxTVvsy"})
+	require.NoError(t, err)
+
+	search := func(query string) []string {
+		urls, err := st.SearchUserURLsCtx(ctx, uid, query, 0)
+		require.NoError(t, err)
+		aliases := []string{}
+		for _, url := range urls {
+			aliases = append(aliases, url.Alias)
+		}
+		return aliases
+	}
+
+	// title match is ranked above destination match
+	assert.Equal(t, []string{"edVPg3ks", "4rSPg8ap"}, search("map"))
+	assert.Equal(t, []string{"4rSPg8ap"}, search("YANDEX offi"))
+	assert.Equal(t, []string{}, search("moscow"))
+
+	// index is updated with url
+	_, err = st.UpdateURLCtx(ctx, models.ShrURL{Alias: "dG56Hqxm", URL: "https://yandex.ru/search",
+		UserID: uid, Note: "maps search"}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"edVPg3ks", "dG56Hqxm", "4rSPg8ap"}, search("maps"))
+
+	_, err = st.SearchUserURLsCtx(ctx, uid, " ?! ", 0)
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+}
+
+func TestFileStorage_UpdateURLTagsCtx(t *testing.T) {
+
+	fileName := "storage_tags_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: uid}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "dG56Hqxm", URL: "http://go.dev", UserID: uid}))
+
+	tags, err := st.UpdateURLTagsCtx(ctx, uid, "4rSPg8ap", []string{"search", "ru"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ru", "search"}, tags)
+	_, err = st.UpdateURLTagsCtx(ctx, uid, "edVPg3ks", []string{"search"}, nil)
+	require.NoError(t, err)
+	_, err = st.UpdateURLTagsCtx(ctx, uid, "dG56Hqxm", []string{"search"}, nil)
+	require.NoError(t, err)
+	tags, err = st.UpdateURLTagsCtx(ctx, uid, "4rSPg8ap", nil, []string{"ru"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"search"}, tags)
+
+	_, err = st.UpdateURLTagsCtx(ctx, "other", "4rSPg8ap", []string{"mine"}, nil)
+	assert.ErrorIs(t, err, ErrNotOwner)
+
+	// tags of deleted urls are not counted
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"dG56Hqxm"})
+	require.NoError(t, err)
+	_, err = st.UpdateURLTagsCtx(ctx, uid, "dG56Hqxm", []string{"go"}, nil)
+	assert.ErrorIs(t, err, ErrURLDeleted)
+
+	check := func(st URLStorage) {
+		counts, err := st.GetUserTagsCtx(ctx, uid)
+		require.NoError(t, err)
+		assert.Equal(t, []models.TagCount{{Tag: "search", Count: 2}}, counts)
+
+		urls, _, err := st.ListUserURLsCtx(ctx, models.UserURLsQuery{UserID: uid, Tag: "search", IncludeDeleted: true})
+		require.NoError(t, err)
+		assert.Len(t, urls, 3)
+	}
+
+	check(st)
+
+	// tags are restored from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+}
+
+func TestMemStorage_StoreURLCtx_AliasExists(t *testing.T) {
+	ctx := context.Background()
+
+	st := NewMemStorage()
+
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "practicum", URL: "https://practicum.yandex.ru/"}))
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "practicum", URL: "https://go.dev/"})
+	assert.ErrorIs(t, err, ErrAliasExists)
+
+	// alias of batch url is not overwritten
+	results, err := st.StoreBatchURLCtx(ctx, []models.ShrURL{{Alias: "practicum", URL: "https://ya.ru/"}})
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{{Status: models.BatchRejected, Reason: ErrAliasExists.Error()}}, results)
+	v, err := st.GetURLCtx(ctx, "practicum")
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", v.URL)
+}
+
+func TestFileStorage_StoreBatchURLCtx(t *testing.T) {
+
+	fileName := "storage_batch_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru"}))
+
+	results, err := st.StoreBatchURLCtx(ctx, []models.ShrURL{
+		{Alias: "edVPg3ks", URL: "http://ya.ru"},
+		{Alias: "dG56Hqxm", URL: "http://yandex.ru"},
+		{Alias: "4rSPg8ap", URL: "http://go.dev"},
+		// the same url in batch
+		{Alias: "RTfd56hn", URL: "http://ya.ru"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{
+		{Status: models.BatchCreated, Alias: "edVPg3ks"},
+		{Status: models.BatchExists, Alias: "4rSPg8ap"},
+		{Status: models.BatchRejected, Reason: ErrAliasExists.Error()},
+		{Status: models.BatchExists, Alias: "edVPg3ks"},
+	}, results)
+
+	// created url is written to file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	v, err := fst.GetURLCtx(ctx, "edVPg3ks")
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", v.URL)
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "serialization", err: &pgconn.PgError{Code: pgerrcode.SerializationFailure}, want: true},
+		{name: "connection", err: fmt.Errorf("exec: %w", &pgconn.PgError{Code: pgerrcode.ConnectionFailure}), want: true},
+		{name: "unique_violation", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, want: false},
+		{name: "bad_conn", err: driver.ErrBadConn, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "not_found", err: ErrAliasNotFound, want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, IsTransient(test.err))
+		})
+	}
+}
+
+func TestFileStorage_TransferUserURLsCtx(t *testing.T) {
+
+	fileName := "storage_transfer_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	anonymous := "c81514ed-b47a-4d39-9591-b904db48a07a"
+	account := "4b9e2c1a-7d3f-4e8a-9c6b-1f2e3d4c5b6a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: account})
+	require.NoError(t, err)
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: anonymous, Title: "search"})
+	require.NoError(t, err)
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "dG56Hqxm", URL: "http://go.dev", UserID: anonymous})
+	require.NoError(t, err)
+	_, err = st.DeleteUserURLsCtx(ctx, anonymous, []string{"dG56Hqxm"})
+	require.NoError(t, err)
+
+	n, err := st.TransferUserURLsCtx(ctx, anonymous, account)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// nothing is left to transfer
+	n, err = st.TransferUserURLsCtx(ctx, anonymous, account)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	check := func(st URLStorage) {
+		_, err := st.GetUserURLsCtx(ctx, anonymous)
+		assert.ErrorIs(t, err, ErrUserNotFound)
+
+		// urls are in order of creation including deleted one
+		urls, err := st.GetUserURLsCtx(ctx, account)
+		require.NoError(t, err)
+		require.Len(t, urls, 3)
+		assert.Equal(t, []string{"4rSPg8ap", "edVPg3ks", "dG56Hqxm"},
+			[]string{urls[0].Alias, urls[1].Alias, urls[2].Alias})
+		assert.True(t, urls[2].Deleted)
+
+		found, err := st.SearchUserURLsCtx(ctx, account, "search", 10)
+		require.NoError(t, err)
+		require.Len(t, found, 1)
+		assert.Equal(t, "edVPg3ks", found[0].Alias)
+
+		history, err := st.GetURLHistoryCtx(ctx, "edVPg3ks")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, models.ActionTransfer, history[1].Action)
+		assert.Equal(t, account, history[1].UserID)
+	}
+
+	check(st)
+
+	// transfer is loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+}
+
+func TestFileStorage_Users(t *testing.T) {
+
+	fileName := "storage_users_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".users")
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	user := models.User{
+		ID:           "4b9e2c1a-7d3f-4e8a-9c6b-1f2e3d4c5b6a",
+		Login:        "alice",
+		PasswordHash: "$2a$10$hash",
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	require.NoError(t, st.CreateUserCtx(ctx, user))
+	err := st.CreateUserCtx(ctx, models.User{ID: "other", Login: "alice"})
+	assert.ErrorIs(t, err, ErrLoginExists)
+
+	check := func(st UserStorage) {
+		got, err := st.GetUserByLoginCtx(ctx, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, user, got)
+
+		got, err = st.GetUserCtx(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, user, got)
+
+		_, err = st.GetUserByLoginCtx(ctx, "bob")
+		assert.ErrorIs(t, err, ErrAccountNotFound)
+		_, err = st.GetUserCtx(ctx, "other")
+		assert.ErrorIs(t, err, ErrAccountNotFound)
+	}
+
+	check(st)
+
+	// accounts are loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+}
+
+func TestFileStorage_Webhooks(t *testing.T) {
+
+	fileName := "storage_webhooks_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".webhooks")
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	now := time.Now().UTC().Truncate(time.Second)
+	hook := models.Webhook{
+		ID:        "a1",
+		UserID:    "user",
+		URL:       "https://example.com/hook",
+		Secret:    "secret",
+		Events:    []string{"link.created"},
+		CreatedAt: now,
+	}
+	removed := models.Webhook{ID: "b2", UserID: "user", URL: "https://example.com/other", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, st.CreateWebhookCtx(ctx, hook))
+	require.NoError(t, st.CreateWebhookCtx(ctx, removed))
+
+	letter := func(id string) models.WebhookDeadLetter {
+		return models.WebhookDeadLetter{HookID: hook.ID, EventID: id, EventType: "link.created", Alias: "6qxTVvsy",
+			URL: "https://go.dev/", EventTime: now, Attempts: 5, LastError: "unexpected status 502", Time: now}
+	}
+	// only two latest dead letters are kept
+	for _, id := range []string{"e1", "e2", "e3"} {
+		require.NoError(t, st.AddWebhookDeadLetterCtx(ctx, letter(id), 2))
+	}
+	require.NoError(t, st.AddWebhookDeadLetterCtx(ctx, models.WebhookDeadLetter{HookID: removed.ID, EventID: "e4"}, 2))
+	require.NoError(t, st.DeleteWebhookCtx(ctx, removed.ID))
+
+	assert.ErrorIs(t, st.DeleteWebhookCtx(ctx, removed.ID), ErrWebhookNotFound)
+	assert.ErrorIs(t, st.AddWebhookDeadLetterCtx(ctx, models.WebhookDeadLetter{HookID: removed.ID}, 2), ErrWebhookNotFound)
+
+	check := func(st WebhookStorage) {
+		hooks, err := st.ListWebhooksCtx(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Webhook{hook}, hooks)
+
+		letters, err := st.ListWebhookDeadLettersCtx(ctx, hook.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.WebhookDeadLetter{letter("e3"), letter("e2")}, letters)
+
+		letters, err = st.ListWebhookDeadLettersCtx(ctx, removed.ID)
+		require.NoError(t, err)
+		assert.Empty(t, letters)
+	}
+
+	check(st)
+
+	// webhooks are loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+
+	info, err := os.Stat(fileName + ".webhooks")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileStorage_APIKeys(t *testing.T) {
+
+	fileName := "storage_apikeys_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".apikeys")
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key := models.APIKey{
+		ID:        "a1",
+		UserID:    "user",
+		Name:      "ci",
+		Prefix:    "shr_3f9a0c1b",
+		Hash:      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		Scopes:    []string{"read"},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	// key does not expire
+	other := models.APIKey{ID: "b2", UserID: "user", Prefix: "shr_1a2b3c4d", Hash: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752",
+		CreatedAt: now.Add(time.Second)}
+	revoked := models.APIKey{ID: "c3", UserID: "user", Prefix: "shr_5e6f7a8b", Hash: "fd61a03af4f77d870fc21e05e7e80678095c92d808cfb3b5c279ee04c74aca13",
+		CreatedAt: now.Add(2 * time.Second)}
+	for _, k := range []models.APIKey{key, other, revoked} {
+		require.NoError(t, st.CreateAPIKeyCtx(ctx, k))
+	}
+	require.NoError(t, st.DeleteAPIKeyCtx(ctx, revoked.ID))
+	assert.ErrorIs(t, st.DeleteAPIKeyCtx(ctx, revoked.ID), ErrAPIKeyNotFound)
+
+	check := func(st APIKeyStorage) {
+		keys, err := st.ListAPIKeysCtx(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []models.APIKey{key, other}, keys)
+	}
+
+	check(st)
+
+	// API keys are loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+
+	info, err := os.Stat(fileName + ".apikeys")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileStorage_Revocations(t *testing.T) {
+
+	fileName := "storage_revocations_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".revocations")
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	now := time.Now().UTC().Truncate(time.Second)
+	session := models.Revocation{SessionID: "s1", UserID: "user", Time: now, Until: now.Add(time.Hour)}
+	expired := models.Revocation{SessionID: "s2", UserID: "user", Time: now.Add(-2 * time.Hour), Until: now.Add(-time.Hour)}
+	user := models.Revocation{UserID: "user", Time: now.Add(-time.Minute), Until: now.Add(time.Hour)}
+	// the latest revocation of user replaces previous one
+	latest := models.Revocation{UserID: "user", Time: now, Until: now.Add(2 * time.Hour)}
+	for _, rev := range []models.Revocation{session, expired, user, latest} {
+		require.NoError(t, st.RevokeCtx(ctx, rev))
+	}
+
+	check := func(st RevocationStorage) {
+		revs, err := st.ListRevocationsCtx(ctx, now)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.Revocation{session, latest}, revs)
+	}
+
+	check(st)
+
+	// revocations are loaded from file, expired and replaced ones are removed from it
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	assert.Equal(t, 2, fst.revocationRecords)
+	check(fst)
+}
+
+func TestFileStorage_LongRecord(t *testing.T) {
+
+	fileName := "storage_long_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	// record is longer than default limit of line
+	note := strings.Repeat("n", 100*1024)
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", Note: note}))
+
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	url, err := fst.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.Equal(t, note, url.Note)
+}
+
+func TestFileStorage_URLIndex(t *testing.T) {
+
+	fileName := "storage_index_test.json"
+	defer os.Remove(fileName)
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid}))
+	_, err := st.UpdateURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://ya.ru", UserID: uid}, 1)
+	require.NoError(t, err)
+
+	// previous destination is free after update
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "dG56Hqxm", URL: "http://yandex.ru", UserID: uid}))
+	assert.ErrorIs(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "http://ya.ru", UserID: uid}), ErrURLExists)
+
+	// destination of deleted url is free
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	_, err = st.GetAliasCtx(ctx, "http://ya.ru")
+	assert.ErrorIs(t, err, ErrAliasNotFound)
+	results, err := st.StoreBatchURLCtx(ctx, []models.ShrURL{
+		{Alias: "RTfd56hn", URL: "http://ya.ru", UserID: uid},
+		{Alias: "6qxTVvsy", URL: "http://yandex.ru", UserID: uid},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.BatchResult{
+		{Status: models.BatchCreated, Alias: "RTfd56hn"},
+		{Status: models.BatchExists, Alias: "dG56Hqxm"},
+	}, results)
+
+	// url shortened again is not restored
+	failed, err := st.RestoreUserURLsCtx(ctx, uid, []string{"4rSPg8ap"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]error{"4rSPg8ap": ErrURLExists}, failed)
+
+	_, err = st.DeleteUserURLsCtx(ctx, uid, []string{"RTfd56hn"})
+	require.NoError(t, err)
+	_, err = st.PurgeDeletedURLsCtx(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "EwHXdJfB", URL: "http://ya.ru", UserID: uid}))
+
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	for _, s := range []*FileStorage{st, fst} {
+		url, err := s.GetAliasCtx(ctx, "http://yandex.ru")
+		require.NoError(t, err)
+		assert.Equal(t, "dG56Hqxm", url.Alias)
+		url, err = s.GetAliasCtx(ctx, "http://ya.ru")
+		require.NoError(t, err)
+		assert.Equal(t, "EwHXdJfB", url.Alias)
+	}
+}
+
+func TestFileStorage_RegisterClickCtx(t *testing.T) {
+
+	fileName := "storage_clicks_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".clicks")
+
+	compactEvery := clicksCompactEvery
+	clicksCompactEvery = 3
+	defer func() { clicksCompactEvery = compactEvery }()
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid,
+		Variants: []models.Variant{{Name: "a", URL: "http://yandex.ru/a", Weight: 1}, {Name: "b", URL: "http://yandex.ru/b", Weight: 1}}})
+	require.NoError(t, err)
+	err = st.StoreURLCtx(ctx, models.ShrURL{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: uid})
+	require.NoError(t, err)
+
+	// clicks file is compacted while clicking
+	for _, variant := range []string{"a", "a", "b", "a", "b"} {
+		_, err := st.RegisterClickCtx(ctx, "4rSPg8ap", variant)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 4; i++ {
+		_, err := st.RegisterClickCtx(ctx, "edVPg3ks", "")
+		require.NoError(t, err)
+	}
+	_, err = st.RegisterClickCtx(ctx, "unknown", "")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+
+	check := func(st URLStorage) {
+		v, err := st.GetURLCtx(ctx, "4rSPg8ap")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), v.Clicks)
+		require.Len(t, v.Variants, 2)
+		assert.Equal(t, int64(3), v.Variants[0].Clicks)
+		assert.Equal(t, int64(2), v.Variants[1].Clicks)
+
+		v, err = st.GetURLCtx(ctx, "edVPg3ks")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), v.Clicks)
+	}
+
+	check(st)
+	require.NoError(t, st.Close())
+
+	// clicks are loaded from file
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	check(fst)
+}
+
+func TestFileStorage_RegisterClickCtx_Concurrent(t *testing.T) {
+
+	fileName := "storage_clicks_concurrent_test.json"
+	defer os.Remove(fileName)
+	defer os.Remove(fileName + ".clicks")
+
+	compactEvery := clicksCompactEvery
+	clicksCompactEvery = 7
+	defer func() { clicksCompactEvery = compactEvery }()
+
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewFileStorage(fileName)
+	require.NotNil(t, st)
+	require.NoError(t, st.LoadFromFile())
+	defer st.Close()
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.NoError(t, err)
+
+	// urls are read and changed while clicking
+	const workers, clicks = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < clicks; j++ {
+				_, err := st.RegisterClickCtx(ctx, "4rSPg8ap", "")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < clicks; j++ {
+			_, _, err := st.ListUserURLsCtx(ctx, models.UserURLsQuery{UserID: uid, SortBy: models.SortByClicks})
+			assert.NoError(t, err)
+			_, err = st.UpdateURLTagsCtx(ctx, uid, "4rSPg8ap", []string{"tag"}, nil)
+			assert.NoError(t, err)
+		}
+	}()
+	wg.Wait()
+
+	v, err := st.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*clicks), v.Clicks)
+
+	fst := NewFileStorage(fileName)
+	require.NoError(t, fst.LoadFromFile())
+	v, err = fst.GetURLCtx(ctx, "4rSPg8ap")
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*clicks), v.Clicks)
+}
+
+func TestFileStorage_WriteError(t *testing.T) {
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	// file cannot be opened for writing
+	st := NewFileStorage(filepath.Join(t.TempDir(), "missing", "storage.json"))
+	require.NotNil(t, st)
+
+	err := st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid})
+	require.Error(t, err)
+
+	_, err = st.StoreBatchURLCtx(ctx, []models.ShrURL{
+		{Alias: "edVPg3ks", URL: "http://ya.ru", UserID: uid},
+		{Alias: "dG56Hqxm", URL: "http://go.dev", UserID: uid},
+	})
+	require.Error(t, err)
+
+	// urls are not stored in memory when records are not written
+	for _, alias := range []string{"4rSPg8ap", "edVPg3ks", "dG56Hqxm"} {
+		_, err := st.GetURLCtx(ctx, alias)
+		assert.ErrorIs(t, err, ErrURLNotFound)
+	}
+	_, err = st.GetUserURLsCtx(ctx, uid)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = st.GetURLHistoryCtx(ctx, "4rSPg8ap")
+	assert.ErrorIs(t, err, ErrURLNotFound)
+}
+
+func TestMemStorage_RegisterClickCtx(t *testing.T) {
+	ctx := context.Background()
+	uid := "c81514ed-b47a-4d39-9591-b904db48a07a"
+
+	st := NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "4rSPg8ap", URL: "http://yandex.ru", UserID: uid}))
+
+	// concurrent clicks get exactly one first click
+	var wg sync.WaitGroup
+	var first atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			click, err := st.RegisterClickCtx(ctx, "4rSPg8ap", "")
+			assert.NoError(t, err)
+			assert.Equal(t, uid, click.UserID)
+			assert.Equal(t, "http://yandex.ru", click.URL)
+			if click.First {
+				first.Add(1)
+				assert.Equal(t, int64(1), click.Clicks)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), first.Load())
+
+	_, err := st.RegisterClickCtx(ctx, "unknown", "")
+	assert.ErrorIs(t, err, ErrURLNotFound)
 }
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/rookgm/shortener/internal/models"
+)
+
+// applyTags returns new sorted unique tags with added and removed tags,
+// tags being added are not removed
+func applyTags(tags []string, add []string, remove []string) []string {
+	set := make(map[string]struct{}, len(tags)+len(add))
+	for _, t := range tags {
+		set[t] = struct{}{}
+	}
+	for _, t := range remove {
+		delete(set, t)
+	}
+	for _, t := range add {
+		set[t] = struct{}{}
+	}
+
+	res := make([]string, 0, len(set))
+	for t := range set {
+		res = append(res, t)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// updateTags checks that user may change tags of cur url and returns url with changed tags
+func updateTags(cur models.ShrURL, userID string, add []string, remove []string) (models.ShrURL, error) {
+	switch {
+	case cur.UserID != userID:
+		return models.ShrURL{}, ErrNotOwner
+	case cur.Deleted:
+		return models.ShrURL{}, ErrURLDeleted
+	}
+	cur.Tags = applyTags(cur.Tags, add, remove)
+	return cur, nil
+}
+
+// countTags counts tags of not deleted urls of in-process storages
+func countTags(m map[string]models.ShrURL, aliases []string) []models.TagCount {
+	counts := make(map[string]int)
+	for _, alias := range aliases {
+		url := m[alias]
+		if url.Deleted {
+			continue
+		}
+		for _, t := range url.Tags {
+			counts[t]++
+		}
+	}
+
+	res := make([]models.TagCount, 0, len(counts))
+	for t, n := range counts {
+		res = append(res, models.TagCount{Tag: t, Count: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Tag < res[j].Tag
+	})
+	return res
+}
@@ -0,0 +1,338 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/events"
+	"github.com/rookgm/shortener/internal/logger"
+	"go.uber.org/zap"
+)
+
+// headers of webhook request
+const (
+	HeaderEvent     = "X-Shortener-Event"
+	HeaderDelivery  = "X-Shortener-Delivery"
+	HeaderSignature = "X-Shortener-Signature"
+)
+
+// default parameters of dispatcher
+const (
+	defaultAttempts  = 5
+	defaultBackoff   = time.Second
+	defaultTimeout   = 10 * time.Second
+	defaultWorkers   = 16
+	defaultQueueSize = 1000
+)
+
+// errQueueFull is last error of dead letter dropped because delivery queue is full
+const errQueueFull = "delivery queue is full"
+
+// payload is body of webhook request
+type payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      payloadData `json:"data"`
+}
+
+// payloadData describes link of event
+type payloadData struct {
+	Alias       string `json:"alias"`
+	OriginalURL string `json:"original_url,omitempty"`
+}
+
+// Sign returns signature of body sent in X-Shortener-Signature header,
+// it is hex encoded HMAC-SHA256 of body keyed by hook secret with "sha256=" prefix
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Option sets parameter of dispatcher
+type Option func(*Dispatcher)
+
+// WithClient sets HTTP client sending webhook requests
+func WithClient(client *http.Client) Option {
+	return func(d *Dispatcher) {
+		if client != nil {
+			d.client = client
+		}
+	}
+}
+
+// WithRetries sets number of delivery attempts and delay before the second one,
+// the delay is doubled for every next attempt
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(d *Dispatcher) {
+		if attempts > 0 {
+			d.attempts = attempts
+		}
+		if backoff > 0 {
+			d.backoff = backoff
+		}
+	}
+}
+
+// WithWorkers sets number of concurrent deliveries and number of deliveries waiting for worker,
+// event is moved to dead letters if its delivery does not fit in the queue
+func WithWorkers(workers int, queueSize int) Option {
+	return func(d *Dispatcher) {
+		if workers > 0 {
+			d.workers = workers
+		}
+		if queueSize > 0 {
+			d.queueSize = queueSize
+		}
+	}
+}
+
+// job is event to be delivered to hook
+type job struct {
+	hook  Hook
+	event Event
+}
+
+// Dispatcher delivers events to hooks subscribed to them.
+// Deliveries are queued and made by fixed number of workers.
+type Dispatcher struct {
+	registry  *Registry
+	client    *http.Client
+	attempts  int
+	backoff   time.Duration
+	workers   int
+	queueSize int
+
+	// mu guards closing of queue against concurrent Publish
+	mu     sync.RWMutex
+	closed bool
+	queue  chan job
+	wg     sync.WaitGroup
+
+	// ctx is context of deliveries, it is cancelled when shutdown is out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewDispatcher creates dispatcher of hooks from registry and starts its workers
+func NewDispatcher(registry *Registry, opts ...Option) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		registry:  registry,
+		client:    &http.Client{Timeout: defaultTimeout},
+		attempts:  defaultAttempts,
+		backoff:   defaultBackoff,
+		workers:   defaultWorkers,
+		queueSize: defaultQueueSize,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	d.queue = make(chan job, d.queueSize)
+	d.wg.Add(d.workers)
+	for range d.workers {
+		go func() {
+			defer d.wg.Done()
+			for j := range d.queue {
+				d.deliver(j.hook, j.event)
+			}
+		}()
+	}
+	return d
+}
+
+// Publish queues delivery of event to hooks of its user, it does not wait for delivery.
+// If the queue is full, event is moved to dead letters of the hook without delivery.
+func (d *Dispatcher) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return
+	}
+	for _, hook := range d.registry.match(event) {
+		select {
+		case d.queue <- job{hook: hook, event: event}:
+		default:
+			logger.Log.Warn("webhook delivery queue is full", zap.String("hook", hook.ID),
+				zap.String("event", event.ID))
+			d.deadLetter(hook, DeadLetter{
+				Event:     event,
+				LastError: errQueueFull,
+				Time:      time.Now(),
+			})
+		}
+	}
+}
+
+// Shutdown stops accepting events and waits for queued deliveries.
+// If ctx is done before, deliveries are cancelled and moved to dead letters.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// deliver sends event to hook retrying failures with exponential backoff,
+// event is moved to dead letters after the last attempt
+func (d *Dispatcher) deliver(hook Hook, event Event) {
+	body, err := json.Marshal(payload{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.Time,
+		Data:      payloadData{Alias: event.Alias, OriginalURL: event.URL},
+	})
+	if err != nil {
+		logger.Log.Error("cannot encode webhook payload", zap.Error(err))
+		return
+	}
+
+	deliveryID := newID()
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		delivery := d.send(hook, event, deliveryID, body)
+		delivery.Attempt = attempt
+		d.registry.logDelivery(hook.ID, delivery)
+		if delivery.Error == "" {
+			return
+		}
+		logger.Log.Debug("webhook delivery failed", zap.String("hook", hook.ID),
+			zap.Int("attempt", attempt), zap.String("error", delivery.Error))
+
+		lastErr := delivery.Error
+		if attempt < d.attempts {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+				backoff *= 2
+				continue
+			case <-d.ctx.Done():
+				timer.Stop()
+				lastErr = d.ctx.Err().Error()
+			}
+		}
+
+		d.deadLetter(hook, DeadLetter{
+			Event:     event,
+			Attempts:  attempt,
+			LastError: lastErr,
+			Time:      time.Now(),
+		})
+		return
+	}
+}
+
+// deadLetter stores event not delivered to hook.
+// It is stored even if deliveries are cancelled by shutdown, so storage call has its own timeout.
+func (d *Dispatcher) deadLetter(hook Hook, dl DeadLetter) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if err := d.registry.addDeadLetter(ctx, hook.ID, dl); err != nil {
+		logger.Log.Error("cannot store webhook dead letter", zap.String("hook", hook.ID),
+			zap.String("event", dl.Event.ID), zap.Error(err))
+	}
+}
+
+// send makes one request to hook, delivery has error if it failed or response status is not 2xx
+func (d *Dispatcher) send(hook Hook, event Event, deliveryID string, body []byte) Delivery {
+	delivery := Delivery{
+		ID:        deliveryID,
+		EventID:   event.ID,
+		EventType: event.Type,
+		Time:      time.Now(),
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	delivery.Duration = time.Since(delivery.Time)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	defer resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		delivery.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return delivery
+}
+
+// fromBusEvent converts link event of bus to webhook event,
+// only the first click of link is delivered to webhooks
+func fromBusEvent(ev events.Event) (Event, bool) {
+	event := Event{UserID: ev.UserID, Alias: ev.Alias, URL: ev.URL, Time: ev.Time}
+	switch ev.Type {
+	case events.TypeCreated:
+		event.Type = EventCreated
+	case events.TypeUpdated:
+		event.Type = EventUpdated
+	case events.TypeDeleted:
+		event.Type = EventDeleted
+	case events.TypeClicked:
+		if !ev.First {
+			return Event{}, false
+		}
+		event.Type = EventFirstClick
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+// Consume publishes events received by subscription until it is cancelled.
+// Publish does not block, so subscription buffer is drained as fast as events are matched.
+func (d *Dispatcher) Consume(sub *events.Subscription) {
+	for ev := range sub.C() {
+		if event, ok := fromBusEvent(ev); ok {
+			d.Publish(event)
+		}
+	}
+}
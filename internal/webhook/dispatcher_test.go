@@ -0,0 +1,270 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rookgm/shortener/internal/events"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records webhook requests, it fails first requests with status
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	failures atomic.Int32
+	status   int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	rc.mu.Unlock()
+
+	if rc.failures.Add(-1) >= 0 {
+		w.WriteHeader(rc.status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+// newTestRegistry creates registry of hooks kept in memory storage
+func newTestRegistry(t *testing.T) *Registry {
+	registry, err := NewRegistry(context.Background(), storage.NewMemStorage())
+	require.NoError(t, err)
+	return registry
+}
+
+// addHook registers hook in registry
+func addHook(t *testing.T, registry *Registry, hook Hook) Hook {
+	hook, err := registry.Add(context.Background(), hook)
+	require.NoError(t, err)
+	return hook
+}
+
+// deadLetters returns dead letters of hook
+func deadLetters(t *testing.T, registry *Registry, hookID string) []DeadLetter {
+	letters, err := registry.DeadLetters(context.Background(), hookID)
+	require.NoError(t, err)
+	return letters
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	rc.failures.Store(2)
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	registry := newTestRegistry(t)
+	hook := addHook(t, registry, Hook{UserID: "user", URL: srv.URL, Secret: "secret", Events: []string{EventCreated}})
+	// hooks of other users and events are not called
+	addHook(t, registry, Hook{UserID: "other", URL: srv.URL, Secret: "secret"})
+	addHook(t, registry, Hook{UserID: "user", URL: srv.URL, Secret: "secret", Events: []string{EventDeleted}})
+
+	d := NewDispatcher(registry, WithRetries(3, time.Millisecond))
+	d.Publish(Event{Type: EventCreated, UserID: "user", Alias: "6qxTVvsy", URL: "https://go.dev/"})
+	require.NoError(t, d.Shutdown(context.Background()))
+
+	require.Equal(t, 3, rc.count())
+	req, body := rc.requests[2], rc.bodies[2]
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, EventCreated, req.Header.Get(HeaderEvent))
+	assert.Equal(t, Sign("secret", body), req.Header.Get(HeaderSignature))
+	// retries keep delivery ID
+	assert.Equal(t, rc.requests[0].Header.Get(HeaderDelivery), req.Header.Get(HeaderDelivery))
+
+	var p payload
+	require.NoError(t, json.Unmarshal(body, &p))
+	assert.Equal(t, EventCreated, p.Type)
+	assert.Equal(t, payloadData{Alias: "6qxTVvsy", OriginalURL: "https://go.dev/"}, p.Data)
+
+	deliveries := registry.Deliveries(hook.ID)
+	require.Len(t, deliveries, 3)
+	assert.Equal(t, 3, deliveries[0].Attempt)
+	assert.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, "unexpected status 500", deliveries[1].Error)
+	assert.Empty(t, deadLetters(t, registry, hook.ID))
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	rc := &receiver{status: http.StatusBadGateway}
+	rc.failures.Store(10)
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	registry := newTestRegistry(t)
+	hook := addHook(t, registry, Hook{UserID: "user", URL: srv.URL, Secret: "secret"})
+
+	d := NewDispatcher(registry, WithRetries(2, time.Millisecond))
+	d.Publish(Event{Type: EventDeleted, UserID: "user", Alias: "6qxTVvsy"})
+	require.NoError(t, d.Shutdown(context.Background()))
+
+	assert.Equal(t, 2, rc.count())
+	letters := deadLetters(t, registry, hook.ID)
+	require.Len(t, letters, 1)
+	assert.Equal(t, EventDeleted, letters[0].Event.Type)
+	assert.Equal(t, "6qxTVvsy", letters[0].Event.Alias)
+	assert.Equal(t, 2, letters[0].Attempts)
+	assert.Equal(t, "unexpected status 502", letters[0].LastError)
+
+	// events are not accepted after shutdown
+	d.Publish(Event{Type: EventDeleted, UserID: "user", Alias: "RTfd56hn"})
+	assert.Equal(t, 2, rc.count())
+}
+
+func TestDispatcher_ShutdownTimeout(t *testing.T) {
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	rc.failures.Store(10)
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	registry := newTestRegistry(t)
+	hook := addHook(t, registry, Hook{UserID: "user", URL: srv.URL, Secret: "secret"})
+
+	// delivery waits for retry longer than shutdown
+	d := NewDispatcher(registry, WithRetries(5, time.Hour))
+	d.Publish(Event{Type: EventUpdated, UserID: "user", Alias: "6qxTVvsy"})
+	require.Eventually(t, func() bool { return rc.count() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Shutdown(ctx), context.DeadlineExceeded)
+
+	letters := deadLetters(t, registry, hook.ID)
+	require.Len(t, letters, 1)
+	assert.Equal(t, context.Canceled.Error(), letters[0].LastError)
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	registry := newTestRegistry(t)
+	hook := addHook(t, registry, Hook{UserID: "user", URL: srv.URL, Secret: "secret"})
+
+	// one delivery is made and one is waiting for worker
+	d := NewDispatcher(registry, WithWorkers(1, 1), WithRetries(1, time.Millisecond))
+	d.Publish(Event{Type: EventCreated, UserID: "user", Alias: "6qxTVvsy"})
+	require.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, time.Millisecond)
+	d.Publish(Event{Type: EventCreated, UserID: "user", Alias: "RTfd56hn"})
+	// publishing does not wait for slow hook
+	d.Publish(Event{Type: EventCreated, UserID: "user", Alias: "Kd9sL2xq"})
+
+	close(release)
+	require.NoError(t, d.Shutdown(context.Background()))
+
+	assert.Equal(t, int32(2), requests.Load())
+	letters := deadLetters(t, registry, hook.ID)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "Kd9sL2xq", letters[0].Event.Alias)
+	assert.Equal(t, 0, letters[0].Attempts)
+	assert.Equal(t, errQueueFull, letters[0].LastError)
+}
+
+func TestRegistry(t *testing.T) {
+	registry := newTestRegistry(t)
+	first := addHook(t, registry, Hook{UserID: "user", URL: "https://example.com/a"})
+	second := addHook(t, registry, Hook{UserID: "user", URL: "https://example.com/b"})
+	addHook(t, registry, Hook{UserID: "other", URL: "https://example.com/c"})
+
+	hooks := registry.List("user")
+	require.Len(t, hooks, 2)
+	assert.Equal(t, first.ID, hooks[0].ID)
+	assert.Equal(t, second.ID, hooks[1].ID)
+
+	_, ok := registry.Get("other", first.ID)
+	assert.False(t, ok)
+	ok, err := registry.Remove(context.Background(), "other", first.ID)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = registry.Remove(context.Background(), "user", first.ID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok = registry.Get("user", first.ID)
+	assert.False(t, ok)
+}
+
+func TestRegistry_Load(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+
+	registry, err := NewRegistry(ctx, store)
+	require.NoError(t, err)
+	hook := addHook(t, registry, Hook{UserID: "user", URL: "https://example.com/a", Secret: "secret", Events: []string{EventCreated}})
+	removed := addHook(t, registry, Hook{UserID: "user", URL: "https://example.com/b"})
+	for i := range maxDeadLetters + 1 {
+		require.NoError(t, registry.addDeadLetter(ctx, hook.ID, DeadLetter{
+			Event:    Event{ID: strconv.Itoa(i), Type: EventCreated, Alias: "6qxTVvsy"},
+			Attempts: 5,
+		}))
+	}
+	_, err = registry.Remove(ctx, "user", removed.ID)
+	require.NoError(t, err)
+
+	// hooks and dead letters are kept after restart
+	registry, err = NewRegistry(ctx, store)
+	require.NoError(t, err)
+	assert.Equal(t, []Hook{hook}, registry.List("user"))
+
+	letters := deadLetters(t, registry, hook.ID)
+	require.Len(t, letters, maxDeadLetters)
+	assert.Equal(t, strconv.Itoa(maxDeadLetters), letters[0].Event.ID)
+	assert.Equal(t, "user", letters[0].Event.UserID)
+	// dead letter of removed hook is ignored
+	assert.NoError(t, registry.addDeadLetter(ctx, removed.ID, DeadLetter{Event: Event{ID: "1"}}))
+}
+
+func TestFromBusEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		ev     events.Event
+		want   string
+		wantOk bool
+	}{
+		{name: "created", ev: events.Event{Type: events.TypeCreated}, want: EventCreated, wantOk: true},
+		{name: "updated", ev: events.Event{Type: events.TypeUpdated}, want: EventUpdated, wantOk: true},
+		{name: "deleted", ev: events.Event{Type: events.TypeDeleted}, want: EventDeleted, wantOk: true},
+		{name: "first_click", ev: events.Event{Type: events.TypeClicked, Clicks: 1, First: true}, want: EventFirstClick, wantOk: true},
+		{name: "next_click", ev: events.Event{Type: events.TypeClicked, Clicks: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.ev.UserID = "user"
+			test.ev.Alias = "6qxTVvsy"
+			event, ok := fromBusEvent(test.ev)
+			require.Equal(t, test.wantOk, ok)
+			if ok {
+				assert.Equal(t, test.want, event.Type)
+				assert.Equal(t, "user", event.UserID)
+				assert.Equal(t, "6qxTVvsy", event.Alias)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Package webhook notifies user endpoints about lifecycle events of their links.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+)
+
+// event types
+const (
+	EventCreated    = "link.created"
+	EventUpdated    = "link.updated"
+	EventDeleted    = "link.deleted"
+	EventFirstClick = "link.first_click"
+)
+
+// EventTypes is list of all event types
+var EventTypes = []string{EventCreated, EventUpdated, EventDeleted, EventFirstClick}
+
+// maximum number of deliveries and dead letters kept per webhook
+const (
+	maxDeliveries  = 100
+	maxDeadLetters = 100
+)
+
+// Hook is endpoint registered by user
+type Hook struct {
+	ID     string
+	UserID string
+	URL    string
+	// Secret is key of HMAC signature of payload
+	Secret string
+	// Events is event types the hook is subscribed to, empty means all events
+	Events    []string
+	CreatedAt time.Time
+}
+
+// accepts reports whether the hook is subscribed to event type
+func (h Hook) accepts(eventType string) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, eventType)
+}
+
+// Event is lifecycle event of link
+type Event struct {
+	ID     string
+	Type   string
+	UserID string
+	Alias  string
+	// URL is destination of link, it is empty for deleted link
+	URL  string
+	Time time.Time
+}
+
+// Delivery is attempt to deliver event to hook
+type Delivery struct {
+	ID        string
+	EventID   string
+	EventType string
+	Attempt   int
+	// StatusCode is response status, zero if request failed
+	StatusCode int
+	Error      string
+	Time       time.Time
+	Duration   time.Duration
+}
+
+// DeadLetter is event not delivered after all attempts
+type DeadLetter struct {
+	Event     Event
+	Attempts  int
+	LastError string
+	Time      time.Time
+}
+
+// newID returns random ID
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewSecret returns random signing secret
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Registry keeps hooks of users with their delivery logs and dead letters.
+// Hooks and dead letters are kept in storage, hooks are cached to match events without storage calls.
+// Delivery logs are kept in memory only.
+type Registry struct {
+	store      storage.WebhookStorage
+	mu         sync.RWMutex
+	hooks      map[string]Hook
+	deliveries map[string][]Delivery
+}
+
+// NewRegistry creates registry of hooks loaded from store
+func NewRegistry(ctx context.Context, store storage.WebhookStorage) (*Registry, error) {
+	stored, err := store.ListWebhooksCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{
+		store:      store,
+		hooks:      make(map[string]Hook),
+		deliveries: make(map[string][]Delivery),
+	}
+	for _, hook := range stored {
+		r.hooks[hook.ID] = Hook(hook)
+	}
+	return r, nil
+}
+
+// Add registers hook and returns it with assigned ID
+func (r *Registry) Add(ctx context.Context, hook Hook) (Hook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hook.ID = newID()
+	hook.CreatedAt = time.Now()
+	if err := r.store.CreateWebhookCtx(ctx, models.Webhook(hook)); err != nil {
+		return Hook{}, err
+	}
+	r.hooks[hook.ID] = hook
+	return hook, nil
+}
+
+// Get returns hook of the user by ID
+func (r *Registry) Get(userID string, id string) (Hook, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	hook, ok := r.hooks[id]
+	if !ok || hook.UserID != userID {
+		return Hook{}, false
+	}
+	return hook, true
+}
+
+// List returns hooks of the user ordered by creation time
+func (r *Registry) List(userID string) []Hook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var hooks []Hook
+	for _, hook := range r.hooks {
+		if hook.UserID == userID {
+			hooks = append(hooks, hook)
+		}
+	}
+	slices.SortFunc(hooks, func(a, b Hook) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return hooks
+}
+
+// Remove removes hook of the user with its logs, it returns false if hook is not found
+func (r *Registry) Remove(ctx context.Context, userID string, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hook, ok := r.hooks[id]
+	if !ok || hook.UserID != userID {
+		return false, nil
+	}
+	err := r.store.DeleteWebhookCtx(ctx, id)
+	if err != nil && !errors.Is(err, storage.ErrWebhookNotFound) {
+		return false, err
+	}
+	delete(r.hooks, id)
+	delete(r.deliveries, id)
+	return true, nil
+}
+
+// match returns hooks of event's user subscribed to the event
+func (r *Registry) match(event Event) []Hook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var hooks []Hook
+	for _, hook := range r.hooks {
+		if hook.UserID == event.UserID && hook.accepts(event.Type) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// logDelivery adds delivery to log of the hook, the oldest deliveries are dropped
+func (r *Registry) logDelivery(hookID string, d Delivery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.hooks[hookID]; !ok {
+		return
+	}
+	log := append(r.deliveries[hookID], d)
+	if len(log) > maxDeliveries {
+		log = log[len(log)-maxDeliveries:]
+	}
+	r.deliveries[hookID] = log
+}
+
+// addDeadLetter stores event not delivered to the hook, the oldest dead letters are dropped
+func (r *Registry) addDeadLetter(ctx context.Context, hookID string, dl DeadLetter) error {
+	err := r.store.AddWebhookDeadLetterCtx(ctx, models.WebhookDeadLetter{
+		HookID:    hookID,
+		EventID:   dl.Event.ID,
+		EventType: dl.Event.Type,
+		Alias:     dl.Event.Alias,
+		URL:       dl.Event.URL,
+		EventTime: dl.Event.Time,
+		Attempts:  dl.Attempts,
+		LastError: dl.LastError,
+		Time:      dl.Time,
+	}, maxDeadLetters)
+	// hook is removed while event was delivered
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Deliveries returns delivery log of the hook, the latest delivery goes first
+func (r *Registry) Deliveries(hookID string) []Delivery {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	log := slices.Clone(r.deliveries[hookID])
+	slices.Reverse(log)
+	return log
+}
+
+// DeadLetters returns events not delivered to the hook, the latest goes first
+func (r *Registry) DeadLetters(ctx context.Context, hookID string) ([]DeadLetter, error) {
+	stored, err := r.store.ListWebhookDeadLettersCtx(ctx, hookID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	userID := r.hooks[hookID].UserID
+	r.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(stored))
+	for _, dl := range stored {
+		letters = append(letters, DeadLetter{
+			Event: Event{
+				ID:     dl.EventID,
+				Type:   dl.EventType,
+				UserID: userID,
+				Alias:  dl.Alias,
+				URL:    dl.URL,
+				Time:   dl.EventTime,
+			},
+			Attempts:  dl.Attempts,
+			LastError: dl.LastError,
+			Time:      dl.Time,
+		})
+	}
+	return letters, nil
+}
@@ -0,0 +1,314 @@
+// Package worker runs background processing of user requests.
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/queue"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// ErrClosed is an error when task is pushed after shutdown
+var ErrClosed = errors.New("worker is closed")
+
+// default parameters of deleter
+const (
+	defaultBatchSize  = 1000
+	defaultInterval   = time.Second
+	defaultRetries    = 3
+	defaultBackoff    = 100 * time.Millisecond
+	defaultQueueDepth = 1000
+)
+
+// Option sets parameter of deleter
+type Option func(*Deleter)
+
+// WithBatchSize sets number of pending aliases flushed without waiting for interval
+func WithBatchSize(size int) Option {
+	return func(d *Deleter) {
+		if size > 0 {
+			d.batchSize = size
+		}
+	}
+}
+
+// WithInterval sets how long tasks are accumulated before flush
+func WithInterval(interval time.Duration) Option {
+	return func(d *Deleter) {
+		if interval > 0 {
+			d.interval = interval
+		}
+	}
+}
+
+// WithRetries sets number of retries of transient failure and delay before the first one,
+// the delay is doubled for every next retry
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(d *Deleter) {
+		if retries >= 0 {
+			d.retries = retries
+		}
+		if backoff > 0 {
+			d.backoff = backoff
+		}
+	}
+}
+
+// WithQueue sets durable queue, tasks are persisted to it before they are accepted
+// and acknowledged after their urls are deleted. Tasks pending in the queue on creation
+// of deleter are replayed by Run.
+func WithQueue(q *queue.Queue[models.UserDeleteTask]) Option {
+	return func(d *Deleter) {
+		d.queue = q
+	}
+}
+
+// queuedTask is task with its sequence number in durable queue
+type queuedTask struct {
+	seq  uint64
+	task models.UserDeleteTask
+}
+
+// userBatch is pending tasks of one user
+type userBatch struct {
+	aliases []string
+	tasks   []queuedTask
+}
+
+// Deleter deletes user urls in background.
+// Tasks of the same user are coalesced and deleted by one storage call,
+// progress of the tasks is reported to their jobs.
+type Deleter struct {
+	store    storage.URLStorage
+	registry *jobs.Registry
+
+	batchSize int
+	interval  time.Duration
+	retries   int
+	backoff   time.Duration
+	// queue is durable queue of tasks, nil if tasks are kept in memory only
+	queue *queue.Queue[models.UserDeleteTask]
+	// replay is tasks pending in queue when deleter is created
+	replay []queue.Entry[models.UserDeleteTask]
+
+	// mu guards closing of in against concurrent Push
+	mu     sync.RWMutex
+	closed bool
+	in     chan queuedTask
+
+	// ctx is context of storage calls, it is cancelled when drain is out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewDeleter creates deleter, Run must be called to process tasks
+func NewDeleter(store storage.URLStorage, registry *jobs.Registry, opts ...Option) *Deleter {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Deleter{
+		store:     store,
+		registry:  registry,
+		batchSize: defaultBatchSize,
+		interval:  defaultInterval,
+		retries:   defaultRetries,
+		backoff:   defaultBackoff,
+		in:        make(chan queuedTask, defaultQueueDepth),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.queue != nil {
+		d.replay = d.queue.Pending()
+	}
+	return d
+}
+
+// Push queues task, it blocks while the queue is full
+func (d *Deleter) Push(task models.UserDeleteTask) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+	qt := queuedTask{task: task}
+	if d.queue != nil {
+		seq, err := d.queue.Push(task)
+		if err != nil {
+			return err
+		}
+		qt.seq = seq
+	}
+	d.in <- qt
+	return nil
+}
+
+// Run processes tasks until Shutdown is called and queued tasks are flushed
+func (d *Deleter) Run() {
+	defer close(d.done)
+
+	pending := make(map[string]*userBatch)
+	size := 0
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if size == 0 {
+			return
+		}
+		logger.Log.Debug("flush deletion", zap.Int("users", len(pending)), zap.Int("aliases", size))
+		for uid, b := range pending {
+			d.deleteUserURLs(uid, b)
+		}
+		pending = make(map[string]*userBatch)
+		size = 0
+	}
+
+	add := func(qt queuedTask) {
+		b, ok := pending[qt.task.UID]
+		if !ok {
+			b = &userBatch{}
+			pending[qt.task.UID] = b
+		}
+		b.aliases = append(b.aliases, qt.task.Aliases...)
+		b.tasks = append(b.tasks, qt)
+		size += len(qt.task.Aliases)
+	}
+
+	// tasks accepted before restart, their jobs are registered again
+	for _, e := range d.replay {
+		if e.Item.JobID != "" {
+			d.registry.Recreate(e.Item.JobID, e.Item.UID, jobs.KindDelete, len(e.Item.Aliases))
+		}
+		add(queuedTask{seq: e.Seq, task: e.Item})
+	}
+	if size > 0 {
+		logger.Log.Info("replay deletion", zap.Int("aliases", size))
+		flush()
+	}
+
+	for {
+		select {
+		case qt, ok := <-d.in:
+			if !ok {
+				flush()
+				logger.Log.Debug("deleter is stopped")
+				return
+			}
+			add(qt)
+			if size >= d.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown stops accepting tasks and waits until queued tasks are flushed.
+// If ctx is done before, pending storage calls are cancelled and ctx error is returned.
+func (d *Deleter) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.in)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-d.done
+		return ctx.Err()
+	}
+}
+
+// deleteUserURLs deletes urls of the user batch and reports progress of its jobs,
+// item of job error is index of alias in the task.
+// Tasks failed by transient error or interrupted by shutdown are not acknowledged in durable queue,
+// so they are replayed on restart. Other failures would repeat on replay, so their tasks are acknowledged.
+func (d *Deleter) deleteUserURLs(uid string, b *userBatch) {
+	for _, qt := range b.tasks {
+		d.registry.Start(qt.task.JobID)
+	}
+
+	failed, err := d.deleteWithRetry(uid, b.aliases)
+	if err != nil {
+		logger.Log.Error("can't delete user urls", zap.String("uid", uid), zap.Strings("aliases", b.aliases), zap.Error(err))
+	}
+	if d.queue != nil && !retryable(err) {
+		seqs := make([]uint64, 0, len(b.tasks))
+		for _, qt := range b.tasks {
+			seqs = append(seqs, qt.seq)
+		}
+		if err := d.queue.Ack(seqs...); err != nil {
+			logger.Log.Error("can't acknowledge deletion", zap.String("uid", uid), zap.Error(err))
+		}
+	}
+
+	for _, qt := range b.tasks {
+		task := qt.task
+		if err != nil {
+			d.registry.Finish(task.JobID, err)
+			continue
+		}
+		for i, alias := range task.Aliases {
+			if err, ok := failed[alias]; ok {
+				d.registry.Progress(task.JobID, &jobs.ItemError{Item: i, Key: alias, Error: err.Error()})
+				continue
+			}
+			d.registry.Progress(task.JobID, nil)
+		}
+		d.registry.Finish(task.JobID, nil)
+	}
+}
+
+// retryable reports whether failed deletion should be replayed on restart
+func retryable(err error) bool {
+	return err != nil && (storage.IsTransient(err) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// deleteWithRetry deletes urls retrying transient failures with exponential backoff
+func (d *Deleter) deleteWithRetry(uid string, aliases []string) (map[string]error, error) {
+	var failed map[string]error
+	err := withRetry(d.ctx, d.retries, d.backoff, func(ctx context.Context) error {
+		var err error
+		failed, err = d.store.DeleteUserURLsCtx(ctx, uid, aliases)
+		return err
+	})
+	return failed, err
+}
+
+// withRetry calls fn retrying transient failures with exponential backoff,
+// backoff is delay before the first retry
+func withRetry(ctx context.Context, retries int, backoff time.Duration, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt == retries || !storage.IsTransient(err) {
+			return err
+		}
+		logger.Log.Warn("retry storage call", zap.Int("attempt", attempt+1), zap.Error(err))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
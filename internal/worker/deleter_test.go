@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/queue"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleter_Coalesce(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: "user"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://ya.ru/", UserID: "other"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "EwHXdJfB", URL: "https://go.dev/doc", UserID: "user"}))
+
+	registry := jobs.NewRegistry()
+	d := NewDeleter(st, registry, WithInterval(time.Hour))
+	go d.Run()
+
+	first := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy", "RTfd56hn"}, JobID: registry.Create("user", "delete", 2)}
+	second := models.UserDeleteTask{UID: "user", Aliases: []string{"unknown", "EwHXdJfB"}, JobID: registry.Create("user", "delete", 2)}
+	require.NoError(t, d.Push(first))
+	require.NoError(t, d.Push(second))
+
+	// queued tasks are flushed on shutdown
+	require.NoError(t, d.Shutdown(ctx))
+	assert.ErrorIs(t, d.Push(first), ErrClosed)
+
+	job, _ := registry.Get(first.JobID)
+	assert.Equal(t, jobs.StatusPartial, job.Status)
+	assert.Equal(t, []jobs.ItemError{{Item: 1, Key: "RTfd56hn", Error: storage.ErrNotOwner.Error()}}, job.Errors)
+
+	job, _ = registry.Get(second.JobID)
+	assert.Equal(t, jobs.StatusPartial, job.Status)
+	assert.Equal(t, 2, job.Processed)
+	assert.Equal(t, []jobs.ItemError{{Item: 0, Key: "unknown", Error: storage.ErrAliasNotFound.Error()}}, job.Errors)
+
+	for alias, deleted := range map[string]bool{"6qxTVvsy": true, "RTfd56hn": false, "EwHXdJfB": true} {
+		url, err := st.GetURLCtx(ctx, alias)
+		require.NoError(t, err)
+		assert.Equal(t, deleted, url.Deleted, alias)
+	}
+}
+
+func TestDeleter_BatchSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storeMock := storage.NewMockURLStorage(ctrl)
+	// both tasks of the user are deleted by one call as soon as batch is full
+	storeMock.EXPECT().
+		DeleteUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy", "RTfd56hn"}).
+		Return(map[string]error{}, nil)
+
+	registry := jobs.NewRegistry()
+	d := NewDeleter(storeMock, registry, WithInterval(time.Hour), WithBatchSize(2))
+	go d.Run()
+	defer d.Shutdown(context.Background())
+
+	first := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", "delete", 1)}
+	second := models.UserDeleteTask{UID: "user", Aliases: []string{"RTfd56hn"}, JobID: registry.Create("user", "delete", 1)}
+	require.NoError(t, d.Push(first))
+	require.NoError(t, d.Push(second))
+
+	require.Eventually(t, func() bool {
+		job, _ := registry.Get(second.JobID)
+		return job.Status == jobs.StatusDone
+	}, time.Second, 10*time.Millisecond)
+	job, _ := registry.Get(first.JobID)
+	assert.Equal(t, jobs.StatusDone, job.Status)
+}
+
+func TestDeleter_Retry(t *testing.T) {
+	transient := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+
+	tests := []struct {
+		name       string
+		errs       []error
+		wantStatus string
+		wantErr    string
+	}{
+		{
+			name:       "transient_recovered",
+			errs:       []error{transient, transient, nil},
+			wantStatus: jobs.StatusDone,
+		},
+		{
+			name:       "transient_exhausted",
+			errs:       []error{transient, transient, transient},
+			wantStatus: jobs.StatusFailed,
+			wantErr:    transient.Error(),
+		},
+		{
+			name:       "permanent",
+			errs:       []error{errors.New("disk is full")},
+			wantStatus: jobs.StatusFailed,
+			wantErr:    "disk is full",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storeMock := storage.NewMockURLStorage(ctrl)
+			var calls []*gomock.Call
+			for _, err := range test.errs {
+				calls = append(calls, storeMock.EXPECT().
+					DeleteUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).
+					Return(map[string]error{}, err))
+			}
+			gomock.InOrder(calls...)
+
+			registry := jobs.NewRegistry()
+			d := NewDeleter(storeMock, registry, WithRetries(2, time.Millisecond))
+			go d.Run()
+
+			task := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", "delete", 1)}
+			require.NoError(t, d.Push(task))
+			require.NoError(t, d.Shutdown(context.Background()))
+
+			job, _ := registry.Get(task.JobID)
+			assert.Equal(t, test.wantStatus, job.Status)
+			assert.Equal(t, test.wantErr, job.Err)
+		})
+	}
+}
+
+func TestDeleter_ShutdownTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storeMock := storage.NewMockURLStorage(ctrl)
+	// storage call is blocked until drain is out of time
+	storeMock.EXPECT().
+		DeleteUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).
+		DoAndReturn(func(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+	registry := jobs.NewRegistry()
+	d := NewDeleter(storeMock, registry)
+	go d.Run()
+
+	task := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", "delete", 1)}
+	require.NoError(t, d.Push(task))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Shutdown(ctx), context.DeadlineExceeded)
+
+	job, _ := registry.Get(task.JobID)
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+}
+
+func TestDeleter_Queue(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "queue.json")
+
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: "user"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://ya.ru/", UserID: "user"}))
+
+	// task is accepted, but process is stopped before deletion
+	q, err := queue.Open[models.UserDeleteTask](path)
+	require.NoError(t, err)
+	d := NewDeleter(st, jobs.NewRegistry(), WithQueue(q))
+	task := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy", "RTfd56hn"}, JobID: "job"}
+	require.NoError(t, d.Push(task))
+	require.NoError(t, q.Close())
+
+	// task is replayed on start and acknowledged after deletion
+	q, err = queue.Open[models.UserDeleteTask](path)
+	require.NoError(t, err)
+	defer q.Close()
+	require.Len(t, q.Pending(), 1)
+	assert.Equal(t, task, q.Pending()[0].Item)
+
+	registry := jobs.NewRegistry()
+	d = NewDeleter(st, registry, WithQueue(q), WithInterval(time.Hour))
+	go d.Run()
+	require.NoError(t, d.Shutdown(ctx))
+
+	assert.Empty(t, q.Pending())
+	// job of replayed task is found after restart
+	job, ok := registry.Get(task.JobID)
+	require.True(t, ok)
+	assert.Equal(t, "user", job.UserID)
+	assert.Equal(t, jobs.StatusDone, job.Status)
+	for _, alias := range task.Aliases {
+		url, err := st.GetURLCtx(ctx, alias)
+		require.NoError(t, err)
+		assert.True(t, url.Deleted, alias)
+	}
+}
+
+func TestDeleter_QueueFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantPending int
+	}{
+		{
+			// failed task is kept to be replayed
+			name:        "transient",
+			err:         &pgconn.PgError{Code: pgerrcode.ConnectionFailure},
+			wantPending: 1,
+		},
+		{
+			// task would fail again on replay
+			name:        "permanent",
+			err:         errors.New("disk is full"),
+			wantPending: 0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storeMock := storage.NewMockURLStorage(ctrl)
+			storeMock.EXPECT().
+				DeleteUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).
+				Return(nil, test.err).AnyTimes()
+
+			q, err := queue.Open[models.UserDeleteTask](filepath.Join(t.TempDir(), "queue.json"))
+			require.NoError(t, err)
+			defer q.Close()
+
+			registry := jobs.NewRegistry()
+			d := NewDeleter(storeMock, registry, WithQueue(q), WithRetries(1, time.Millisecond))
+			go d.Run()
+			task := models.UserDeleteTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", jobs.KindDelete, 1)}
+			require.NoError(t, d.Push(task))
+			require.NoError(t, d.Shutdown(context.Background()))
+
+			assert.Len(t, q.Pending(), test.wantPending)
+			job, _ := registry.Get(task.JobID)
+			assert.Equal(t, jobs.StatusFailed, job.Status)
+		})
+	}
+}
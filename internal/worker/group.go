@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// Group runs background jobs outliving requests, e.g. imports, and waits for them on shutdown
+type Group struct {
+	// mu guards adding to wg against concurrent Shutdown
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// ctx is context of jobs, it is cancelled when drain is out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewGroup creates empty group
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in background, fn should stop when its ctx is done
+func (g *Group) Go(fn func(ctx context.Context)) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return ErrClosed
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn(g.ctx)
+	}()
+	return nil
+}
+
+// Shutdown stops accepting jobs and waits until running jobs are finished.
+// If ctx is done before, context of jobs is cancelled and ctx error is returned.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
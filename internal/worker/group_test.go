@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroup_Shutdown(t *testing.T) {
+	g := NewGroup()
+
+	var finished atomic.Int32
+	for i := 0; i < 3; i++ {
+		require.NoError(t, g.Go(func(ctx context.Context) {
+			time.Sleep(20 * time.Millisecond)
+			finished.Add(1)
+		}))
+	}
+
+	// running jobs are waited for
+	require.NoError(t, g.Shutdown(context.Background()))
+	assert.Equal(t, int32(3), finished.Load())
+	assert.ErrorIs(t, g.Go(func(ctx context.Context) {}), ErrClosed)
+}
+
+func TestGroup_ShutdownTimeout(t *testing.T) {
+	g := NewGroup()
+
+	var cancelled atomic.Bool
+	require.NoError(t, g.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		cancelled.Store(true)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, g.Shutdown(ctx), context.DeadlineExceeded)
+	assert.True(t, cancelled.Load())
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/logger"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Restorer restores deleted user urls in background, progress of tasks is reported to their jobs
+type Restorer struct {
+	store    storage.URLStorage
+	registry *jobs.Registry
+
+	retries int
+	backoff time.Duration
+
+	// mu guards closing of in against concurrent Push
+	mu     sync.RWMutex
+	closed bool
+	in     chan models.UserRestoreTask
+
+	// ctx is context of storage calls, it is cancelled when drain is out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRestorer creates restorer, Run must be called to process tasks
+func NewRestorer(store storage.URLStorage, registry *jobs.Registry) *Restorer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Restorer{
+		store:    store,
+		registry: registry,
+		retries:  defaultRetries,
+		backoff:  defaultBackoff,
+		in:       make(chan models.UserRestoreTask, defaultQueueDepth),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+}
+
+// Push queues task, it blocks while the queue is full
+func (rs *Restorer) Push(task models.UserRestoreTask) error {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	if rs.closed {
+		return ErrClosed
+	}
+	rs.in <- task
+	return nil
+}
+
+// Run processes tasks until Shutdown is called and queued tasks are processed
+func (rs *Restorer) Run() {
+	defer close(rs.done)
+
+	for task := range rs.in {
+		rs.restoreUserURLs(task)
+	}
+	logger.Log.Debug("restorer is stopped")
+}
+
+// Shutdown stops accepting tasks and waits until queued tasks are processed.
+// If ctx is done before, pending storage calls are cancelled and ctx error is returned.
+func (rs *Restorer) Shutdown(ctx context.Context) error {
+	rs.mu.Lock()
+	if !rs.closed {
+		rs.closed = true
+		close(rs.in)
+	}
+	rs.mu.Unlock()
+
+	select {
+	case <-rs.done:
+		return nil
+	case <-ctx.Done():
+		rs.cancel()
+		<-rs.done
+		return ctx.Err()
+	}
+}
+
+// restoreUserURLs restores urls of the task and reports progress of its job
+func (rs *Restorer) restoreUserURLs(task models.UserRestoreTask) {
+	rs.registry.Start(task.JobID)
+
+	var failed map[string]error
+	err := withRetry(rs.ctx, rs.retries, rs.backoff, func(ctx context.Context) error {
+		var err error
+		failed, err = rs.store.RestoreUserURLsCtx(ctx, task.UID, task.Aliases)
+		return err
+	})
+	if err != nil {
+		logger.Log.Error("can't restore user urls", zap.String("uid", task.UID), zap.Error(err))
+		rs.registry.Finish(task.JobID, err)
+		return
+	}
+
+	for i, alias := range task.Aliases {
+		if err, ok := failed[alias]; ok {
+			rs.registry.Progress(task.JobID, &jobs.ItemError{Item: i, Key: alias, Error: err.Error()})
+			continue
+		}
+		rs.registry.Progress(task.JobID, nil)
+	}
+	rs.registry.Finish(task.JobID, nil)
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rookgm/shortener/internal/jobs"
+	"github.com/rookgm/shortener/internal/models"
+	"github.com/rookgm/shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestorer_Drain(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: "user"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://ya.ru/", UserID: "user"}))
+	_, err := st.DeleteUserURLsCtx(ctx, "user", []string{"6qxTVvsy", "RTfd56hn"})
+	require.NoError(t, err)
+
+	registry := jobs.NewRegistry()
+	rs := NewRestorer(st, registry)
+	go rs.Run()
+
+	task := models.UserRestoreTask{UID: "user", Aliases: []string{"6qxTVvsy", "RTfd56hn"}, JobID: registry.Create("user", "restore", 2)}
+	require.NoError(t, rs.Push(task))
+
+	// queued tasks are processed on shutdown
+	require.NoError(t, rs.Shutdown(ctx))
+	assert.ErrorIs(t, rs.Push(task), ErrClosed)
+
+	job, _ := registry.Get(task.JobID)
+	assert.Equal(t, jobs.StatusDone, job.Status)
+	assert.Equal(t, 2, job.Processed)
+
+	for _, alias := range task.Aliases {
+		url, err := st.GetURLCtx(ctx, alias)
+		require.NoError(t, err)
+		assert.False(t, url.Deleted, alias)
+	}
+}
+
+func TestRestorer_Partial(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemStorage()
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "6qxTVvsy", URL: "https://go.dev/", UserID: "user"}))
+	require.NoError(t, st.StoreURLCtx(ctx, models.ShrURL{Alias: "RTfd56hn", URL: "https://ya.ru/", UserID: "user"}))
+	_, err := st.DeleteUserURLsCtx(ctx, "user", []string{"6qxTVvsy"})
+	require.NoError(t, err)
+
+	registry := jobs.NewRegistry()
+	rs := NewRestorer(st, registry)
+	go rs.Run()
+
+	// the second url is not deleted, the third one is unknown
+	task := models.UserRestoreTask{UID: "user", Aliases: []string{"6qxTVvsy", "RTfd56hn", "unknown"}, JobID: registry.Create("user", "restore", 3)}
+	require.NoError(t, rs.Push(task))
+	require.NoError(t, rs.Shutdown(ctx))
+
+	job, _ := registry.Get(task.JobID)
+	assert.Equal(t, jobs.StatusPartial, job.Status)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, []jobs.ItemError{
+		{Item: 1, Key: "RTfd56hn", Error: storage.ErrURLNotDeleted.Error()},
+		{Item: 2, Key: "unknown", Error: storage.ErrAliasNotFound.Error()},
+	}, job.Errors)
+}
+
+func TestRestorer_Retry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	transient := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	storeMock := storage.NewMockURLStorage(ctrl)
+	gomock.InOrder(
+		storeMock.EXPECT().RestoreUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).Return(nil, transient),
+		storeMock.EXPECT().RestoreUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).Return(nil, nil),
+	)
+
+	registry := jobs.NewRegistry()
+	rs := NewRestorer(storeMock, registry)
+	rs.backoff = time.Millisecond
+	go rs.Run()
+
+	task := models.UserRestoreTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", "restore", 1)}
+	require.NoError(t, rs.Push(task))
+	require.NoError(t, rs.Shutdown(context.Background()))
+
+	job, _ := registry.Get(task.JobID)
+	assert.Equal(t, jobs.StatusDone, job.Status)
+}
+
+func TestRestorer_ShutdownTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storeMock := storage.NewMockURLStorage(ctrl)
+	// storage call is blocked until drain is out of time
+	storeMock.EXPECT().
+		RestoreUserURLsCtx(gomock.Any(), "user", []string{"6qxTVvsy"}).
+		DoAndReturn(func(ctx context.Context, userID string, aliases []string) (map[string]error, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+	registry := jobs.NewRegistry()
+	rs := NewRestorer(storeMock, registry)
+	go rs.Run()
+
+	task := models.UserRestoreTask{UID: "user", Aliases: []string{"6qxTVvsy"}, JobID: registry.Create("user", "restore", 1)}
+	require.NoError(t, rs.Push(task))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, rs.Shutdown(ctx), context.DeadlineExceeded)
+
+	job, _ := registry.Get(task.JobID)
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+}